@@ -5,10 +5,17 @@ import (
 	"errors"
 	"aaronlindsay.com/go/pkg/pso2/util"
 	"github.com/quarnster/util/encoding/binary"
+	bin "encoding/binary"
 )
 
 const (
 	HeaderMagic uint32 = 0x00706661 // little endian "afp\0"
+
+	headerSize = 0x10
+	entryHeaderSize = 0x30
+	entryNameSize = 0x20
+	entryTypeSize = 4
+	entryAlignment = 0x10
 )
 
 type Archive struct {
@@ -34,6 +41,9 @@ type archiveEntry struct {
 	Alignment []uint8 `skip:"DataEnd-0x30" length:"0"`
 
 	Data io.ReadSeeker `if:"0"`
+
+	// Where the entry header begins in the original archive, or -1 if it has been modified
+	offset int64 `if:"0"`
 }
 
 func (h *archiveHeader) Validate() error {
@@ -69,16 +79,127 @@ func (a *Archive) parse() (err error) {
 		}
 
 		entry.Data = io.NewSectionReader(util.ReaderAt(a.reader), entryOffset + int64(entry.DataOffset), int64(entry.DataSize))
+		entry.offset = entryOffset
 		entryOffset += int64(entry.DataEnd)
 	}
 
 	return
 }
 
+// CreateArchive returns an empty archive, ready for AddEntry
+func CreateArchive() *Archive {
+	return &Archive{ header: archiveHeader{ Magic: HeaderMagic, Count2: 1 } }
+}
+
+func align(size uint32) uint32 {
+	return (size + entryAlignment - 1) / entryAlignment * entryAlignment
+}
+
 func (a *Archive) Write(writer io.Writer) error {
+	header := a.header
+	header.EntryCount = uint32(len(a.entries))
+
+	if err := bin.Write(writer, bin.LittleEndian, &header); err != nil {
+		return err
+	}
+
+	for i := range a.entries {
+		entry := &a.entries[i]
+
+		// Untouched entries are copied as is, alignment and all
+		if entry.offset >= 0 && a.reader != nil {
+			if _, err := io.Copy(writer, io.NewSectionReader(util.ReaderAt(a.reader), entry.offset, int64(entry.DataEnd))); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(entry.Name) > entryNameSize {
+			return errors.New(entry.Name + ": name too long")
+		}
+
+		if len(entry.Type) > entryTypeSize {
+			return errors.New(entry.Name + ": type too long")
+		}
+
+		var name [entryNameSize]uint8
+		var fileType [entryTypeSize]uint8
+		copy(name[:], entry.Name)
+		copy(fileType[:], entry.Type)
+
+		if err := bin.Write(writer, bin.LittleEndian, name); err != nil {
+			return err
+		}
+
+		if err := bin.Write(writer, bin.LittleEndian, []uint32{ entry.DataSize, entry.DataOffset, entry.DataEnd }); err != nil {
+			return err
+		}
+
+		if err := bin.Write(writer, bin.LittleEndian, fileType); err != nil {
+			return err
+		}
+
+		if _, err := writer.Write(make([]uint8, entry.DataOffset - entryHeaderSize)); err != nil {
+			return err
+		}
+
+		if _, err := entry.Data.Seek(0, 0); err != nil {
+			return err
+		}
+
+		if _, err := io.CopyN(writer, entry.Data, int64(entry.DataSize)); err != nil {
+			return err
+		}
+
+		if _, err := writer.Write(make([]uint8, entry.DataEnd - entry.DataOffset - entry.DataSize)); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func (e *archiveEntry) setData(data io.ReadSeeker, size uint32) {
+	if e.DataOffset < entryHeaderSize {
+		e.DataOffset = entryHeaderSize
+	}
+
+	e.Data = data
+	e.DataSize = size
+	e.DataEnd = align(e.DataOffset + size)
+	e.offset = -1
+}
+
+// ReplaceEntry swaps out the contents of the entry with the same name
+func (a *Archive) ReplaceEntry(entry Entry, data io.ReadSeeker, size uint32) {
+	for i := range a.entries {
+		if a.entries[i].Name == entry.Name {
+			a.entries[i].setData(data, size)
+			break
+		}
+	}
+}
+
+func (a *Archive) RemoveEntry(entry Entry) {
+	for i := range a.entries {
+		if a.entries[i].Name == entry.Name {
+			// Copy so that anyone iterating over the old slice isn't disrupted
+			a.entries = append(a.entries[:i:i], a.entries[i + 1:]...)
+			break
+		}
+	}
+}
+
+// AddEntry appends a new entry to the end of the archive
+func (a *Archive) AddEntry(name, fileType string, data io.ReadSeeker, size uint32) Entry {
+	entry := archiveEntry{ Name: name, Type: fileType }
+	entry.setData(data, size)
+
+	a.entries = append(a.entries, entry)
+
+	return a.Entry(len(a.entries) - 1)
+}
+
 type Entry struct {
 	Type, Name string
 	Size uint32
@@ -0,0 +1,130 @@
+package afp
+
+import (
+	"bytes"
+	"testing"
+	"io/ioutil"
+	bin "encoding/binary"
+)
+
+// testEntry lays out an entry by hand, with its data at offset and padded to end
+func testEntry(name, fileType string, data []uint8, offset, end uint32) []uint8 {
+	var buffer bytes.Buffer
+	var nameField [entryNameSize]uint8
+	var typeField [entryTypeSize]uint8
+	copy(nameField[:], name)
+	copy(typeField[:], fileType)
+
+	buffer.Write(nameField[:])
+	bin.Write(&buffer, bin.LittleEndian, []uint32{ uint32(len(data)), offset, end })
+	buffer.Write(typeField[:])
+	buffer.Write(make([]uint8, offset - entryHeaderSize))
+	buffer.Write(data)
+	buffer.Write(make([]uint8, end - offset - uint32(len(data))))
+	return buffer.Bytes()
+}
+
+func testArchive(entries ...[]uint8) []uint8 {
+	var buffer bytes.Buffer
+	bin.Write(&buffer, bin.LittleEndian, &archiveHeader{ Magic: HeaderMagic, EntryCount: uint32(len(entries)), Count2: 1 })
+	for _, e := range entries {
+		buffer.Write(e)
+	}
+
+	return buffer.Bytes()
+}
+
+// The second entry has more padding than it needs, which is kept as long as it isn't replaced
+var (
+	testEntryA = testEntry("a.aqo", "aqo", []uint8("first"), entryHeaderSize, 0x40)
+	testEntryB = testEntry("b.aqn", "aqn", bytes.Repeat([]uint8{ 0xbb }, 0x10), 0x40, 0x70)
+)
+
+func writeArchive(t *testing.T, a *Archive) []uint8 {
+	var buffer bytes.Buffer
+	if err := a.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func readArchive(t *testing.T, data []uint8) *Archive {
+	a, err := NewArchive(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func entryData(t *testing.T, e Entry) string {
+	if _, err := e.Data.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(e.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestArchiveUnchanged(t *testing.T) {
+	original := testArchive(testEntryA, testEntryB)
+
+	if data := writeArchive(t, readArchive(t, original)); !bytes.Equal(data, original) {
+		t.Errorf("rewritten archive differs:\n%x\n%x", data, original)
+	}
+}
+
+func TestArchiveEdit(t *testing.T) {
+	a := readArchive(t, testArchive(testEntryA, testEntryB))
+
+	a.ReplaceEntry(a.Entry(0), bytes.NewReader([]uint8("replaced data")), 13)
+	a.RemoveEntry(a.Entry(1))
+	a.AddEntry("c.aqm", "aqm", bytes.NewReader([]uint8("0123456789abcdef!")), 17)
+
+	// Written entries start at 0x30 and are padded out to 0x10 bytes
+	expected := testArchive(
+		testEntry("a.aqo", "aqo", []uint8("replaced data"), entryHeaderSize, 0x40),
+		testEntry("c.aqm", "aqm", []uint8("0123456789abcdef!"), entryHeaderSize, 0x50),
+	)
+
+	data := writeArchive(t, a)
+	if !bytes.Equal(data, expected) {
+		t.Errorf("edited archive differs:\n%x\n%x", data, expected)
+	}
+
+	read := readArchive(t, data)
+	if read.EntryCount() != 2 {
+		t.Fatalf("%d entries", read.EntryCount())
+	}
+
+	for i, s := range []string{ "replaced data", "0123456789abcdef!" } {
+		if d := entryData(t, read.Entry(i)); d != s {
+			t.Errorf("entry %d: %q", i, d)
+		}
+	}
+}
+
+// Replacing a later entry leaves the earlier ones as they were, and keeps its own data offset
+func TestArchiveReplaceLater(t *testing.T) {
+	a := readArchive(t, testArchive(testEntryA, testEntryB))
+	a.ReplaceEntry(a.Entry(1), bytes.NewReader([]uint8("new")), 3)
+
+	expected := testArchive(testEntryA, testEntry("b.aqn", "aqn", []uint8("new"), 0x40, 0x50))
+	if data := writeArchive(t, a); !bytes.Equal(data, expected) {
+		t.Errorf("archive differs:\n%x\n%x", data, expected)
+	}
+}
+
+func TestCreateArchive(t *testing.T) {
+	a := CreateArchive()
+	a.AddEntry("a.aqo", "aqo", bytes.NewReader([]uint8("first")), 5)
+
+	if data := writeArchive(t, a); !bytes.Equal(data, testArchive(testEntryA)) {
+		t.Errorf("created archive %x", data)
+	}
+}
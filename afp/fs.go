@@ -0,0 +1,18 @@
+package afp
+
+import (
+	"io/fs"
+	"aaronlindsay.com/go/pkg/pso2/util"
+)
+
+// FS exposes the archive's entries as a flat directory. File info Sys() values are the corresponding Entry.
+func (a *Archive) FS() fs.FS {
+	entries := make([]util.FSEntry, a.EntryCount())
+
+	for i := range entries {
+		entry := a.Entry(i)
+		entries[i] = util.FSEntry{ Path: entry.Name, Size: int64(entry.Size), Data: util.ReaderAt(entry.Data), Sys: entry }
+	}
+
+	return util.NewFS(entries)
+}
@@ -0,0 +1,27 @@
+package afp
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestArchiveFS(t *testing.T) {
+	fsys := readArchive(t, testArchive(testEntryA, testEntryB)).FS()
+	if err := fstest.TestFS(fsys, "a.aqo", "b.aqn"); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := fs.ReadFile(fsys, "a.aqo"); err != nil || string(data) != "first" {
+		t.Errorf("a.aqo: %q, %v", data, err)
+	}
+
+	info, err := fs.Stat(fsys, "b.aqn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry, ok := info.Sys().(Entry); !ok || entry.Name != "b.aqn" || info.Size() != 0x10 {
+		t.Errorf("b.aqn info %+v", info)
+	}
+}
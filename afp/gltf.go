@@ -0,0 +1,467 @@
+package afp
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"bytes"
+	"encoding/json"
+	"encoding/base64"
+	bin "encoding/binary"
+)
+
+// glTF 2.0 constants
+const (
+	gltfFloat = 5126
+	gltfUnsignedShort = 5123
+	gltfUnsignedInt = 5125
+	gltfArrayBuffer = 34962
+	gltfElementArrayBuffer = 34963
+	gltfTriangles = 4
+)
+
+type gltfAccessor struct {
+	BufferView int `json:"bufferView"`
+	ComponentType int `json:"componentType"`
+	Count int `json:"count"`
+	Type string `json:"type"`
+	Min []float32 `json:"min,omitempty"`
+	Max []float32 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target int `json:"target,omitempty"`
+}
+
+type gltfNode struct {
+	Name string `json:"name,omitempty"`
+	Children []int `json:"children,omitempty"`
+	Mesh *int `json:"mesh,omitempty"`
+	Skin *int `json:"skin,omitempty"`
+	Translation *[3]float32 `json:"translation,omitempty"`
+	Rotation *[4]float32 `json:"rotation,omitempty"`
+	Scale *[3]float32 `json:"scale,omitempty"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices int `json:"indices"`
+	Material *int `json:"material,omitempty"`
+	Mode int `json:"mode"`
+}
+
+type gltfMesh struct {
+	Name string `json:"name,omitempty"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfSkin struct {
+	InverseBindMatrices int `json:"inverseBindMatrices"`
+	Skeleton *int `json:"skeleton,omitempty"`
+	Joints []int `json:"joints"`
+}
+
+type gltfMaterial struct {
+	Name string `json:"name,omitempty"`
+	PBR struct {
+		BaseColorFactor [4]float32 `json:"baseColorFactor"`
+		MetallicFactor float32 `json:"metallicFactor"`
+	} `json:"pbrMetallicRoughness"`
+	AlphaMode string `json:"alphaMode,omitempty"`
+	DoubleSided bool `json:"doubleSided,omitempty"`
+}
+
+type gltfTarget struct {
+	Node int `json:"node"`
+	Path string `json:"path"`
+}
+
+type gltfChannel struct {
+	Sampler int `json:"sampler"`
+	Target gltfTarget `json:"target"`
+}
+
+type gltfSampler struct {
+	Input int `json:"input"`
+	Output int `json:"output"`
+	Interpolation string `json:"interpolation"`
+}
+
+type gltfAnimation struct {
+	Name string `json:"name,omitempty"`
+	Channels []gltfChannel `json:"channels"`
+	Samplers []gltfSampler `json:"samplers"`
+}
+
+type gltfBuffer struct {
+	ByteLength int `json:"byteLength"`
+	URI string `json:"uri"`
+}
+
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltfDocument struct {
+	Asset struct {
+		Version string `json:"version"`
+		Generator string `json:"generator"`
+	} `json:"asset"`
+	Scene int `json:"scene"`
+	Scenes []gltfScene `json:"scenes"`
+	Nodes []gltfNode `json:"nodes"`
+	Meshes []gltfMesh `json:"meshes,omitempty"`
+	Skins []gltfSkin `json:"skins,omitempty"`
+	Materials []gltfMaterial `json:"materials,omitempty"`
+	Animations []gltfAnimation `json:"animations,omitempty"`
+	Accessors []gltfAccessor `json:"accessors,omitempty"`
+	BufferViews []gltfBufferView `json:"bufferViews,omitempty"`
+	Buffers []gltfBuffer `json:"buffers,omitempty"`
+
+	data bytes.Buffer
+}
+
+// Appends data to the single buffer as a new accessor
+func (d *gltfDocument) accessor(data interface{}, componentType, count int, accessorType string, target int) int {
+	for d.data.Len() % 4 != 0 {
+		d.data.WriteByte(0)
+	}
+
+	offset := d.data.Len()
+	bin.Write(&d.data, bin.LittleEndian, data)
+
+	d.BufferViews = append(d.BufferViews, gltfBufferView{ 0, offset, d.data.Len() - offset, target })
+	d.Accessors = append(d.Accessors, gltfAccessor{ BufferView: len(d.BufferViews) - 1, ComponentType: componentType, Count: count, Type: accessorType })
+
+	return len(d.Accessors) - 1
+}
+
+func (d *gltfDocument) vec(values [][4]float32, components int, normalize bool) int {
+	data := make([]float32, 0, len(values) * components)
+	for _, v := range values {
+		if normalize {
+			length := float32(math.Sqrt(float64(v[0] * v[0] + v[1] * v[1] + v[2] * v[2])))
+			if length > 0 {
+				v[0], v[1], v[2] = v[0] / length, v[1] / length, v[2] / length
+			}
+		}
+		data = append(data, v[:components]...)
+	}
+
+	return d.accessor(data, gltfFloat, len(values), fmt.Sprintf("VEC%d", components), gltfArrayBuffer)
+}
+
+// Diffuse colors have no alpha, so transparency is left to the material's alpha mode. A black diffuse color is
+// treated as unset.
+func baseColorFactor(diffuse []float32) [4]float32 {
+	color := [4]float32{ 1, 1, 1, 1 }
+	for _, c := range diffuse {
+		if c != 0 {
+			copy(color[:3], diffuse)
+			break
+		}
+	}
+
+	return color
+}
+
+// Euler angles (radians, applied X then Y then Z) to a quaternion
+func eulerQuaternion(r [3]float32) [4]float32 {
+	cx, sx := math.Cos(float64(r[0]) / 2), math.Sin(float64(r[0]) / 2)
+	cy, sy := math.Cos(float64(r[1]) / 2), math.Sin(float64(r[1]) / 2)
+	cz, sz := math.Cos(float64(r[2]) / 2), math.Sin(float64(r[2]) / 2)
+
+	return [4]float32{
+		float32(sx * cy * cz - cx * sy * sz),
+		float32(cx * sy * cz + sx * cy * sz),
+		float32(cx * cy * sz - sx * sy * cz),
+		float32(cx * cy * cz + sx * sy * sz),
+	}
+}
+
+// An Animation names a motion to include when exporting a model
+type Animation struct {
+	Name string
+	Motion *Motion
+}
+
+// WriteGLTF exports the model's meshes and skeleton as a self-contained glTF 2.0 document, along with any
+// motions that animate its skeleton
+func (m *Model) WriteGLTF(writer io.Writer, animations ...Animation) error {
+	d := &gltfDocument{}
+	d.Asset.Version = "2.0"
+	d.Asset.Generator = "aaronlindsay.com/go/pkg/pso2/afp"
+
+	var roots []int
+
+	// Bones keep their indices so that the skin's joints line up with the vertex bone palettes
+	for i := range m.Nodes {
+		node := &m.Nodes[i]
+		translation, rotation, scale := node.Position, eulerQuaternion(node.Rotation), node.Scale
+		d.Nodes = append(d.Nodes, gltfNode{ Name: node.Name, Translation: &translation, Rotation: &rotation, Scale: &scale })
+	}
+
+	for i := range m.Nodes {
+		if parent := m.Nodes[i].Parent; parent >= 0 && parent < len(m.Nodes) && parent != i {
+			d.Nodes[parent].Children = append(d.Nodes[parent].Children, i)
+		} else {
+			roots = append(roots, i)
+		}
+	}
+
+	for i := range m.NodeOs {
+		node := &m.NodeOs[i]
+		translation, rotation := node.Position, eulerQuaternion(node.Rotation)
+		d.Nodes = append(d.Nodes, gltfNode{ Name: node.Name, Translation: &translation, Rotation: &rotation })
+
+		if node.Parent >= 0 && node.Parent < len(m.Nodes) {
+			d.Nodes[node.Parent].Children = append(d.Nodes[node.Parent].Children, len(d.Nodes) - 1)
+		} else {
+			roots = append(roots, len(d.Nodes) - 1)
+		}
+	}
+
+	var skin *int
+	if len(m.Nodes) > 0 {
+		matrices := make([]float32, 0, len(m.Nodes) * 16)
+		joints := make([]int, len(m.Nodes))
+		for i := range m.Nodes {
+			// Row major with row vectors is the same layout as glTF's column major with column vectors
+			matrices = append(matrices, m.Nodes[i].Matrix[:]...)
+			joints[i] = i
+		}
+
+		d.Skins = append(d.Skins, gltfSkin{ InverseBindMatrices: d.accessor(matrices, gltfFloat, len(m.Nodes), "MAT4", 0), Joints: joints })
+		if len(roots) > 0 {
+			d.Skins[0].Skeleton = &roots[0]
+		}
+		skin = new(int)
+	}
+
+	for i := range m.Materials {
+		mat := &m.Materials[i]
+		material := gltfMaterial{ Name: mat.Name }
+		material.PBR.BaseColorFactor = baseColorFactor(mat.Diffuse[:])
+		if mat.BlendMode != "" && mat.BlendMode != "opaque" {
+			material.AlphaMode = "BLEND"
+		}
+		d.Materials = append(d.Materials, material)
+	}
+
+	attributes := make(map[int]map[string]int)
+	skinned := make(map[int]bool)
+
+	for i := range m.Meshes {
+		mesh := &m.Meshes[i]
+		if mesh.VertexSet >= len(m.VertexSets) || mesh.PrimitiveSet >= len(m.PrimitiveSets) {
+			return fmt.Errorf("mesh %d: vertex or primitive set out of range", i)
+		}
+
+		// glTF has no way to describe an empty primitive
+		vset := &m.VertexSets[mesh.VertexSet]
+		triangles := m.PrimitiveSets[mesh.PrimitiveSet].Triangles()
+		if vset.VertexCount == 0 || len(triangles) == 0 {
+			continue
+		}
+
+		if _, ok := attributes[mesh.VertexSet]; !ok {
+			attributes[mesh.VertexSet] = m.gltfAttributes(d, vset, skinned, mesh.VertexSet)
+		}
+
+		if _, ok := attributes[mesh.VertexSet]["POSITION"]; !ok {
+			return fmt.Errorf("mesh %d: vertex set has no positions", i)
+		}
+
+		indices := make([]uint32, len(triangles))
+		for t, index := range triangles {
+			if index >= vset.VertexCount {
+				return fmt.Errorf("mesh %d: vertex index out of range", i)
+			}
+			indices[t] = uint32(index)
+		}
+
+		primitive := gltfPrimitive{
+			Attributes: attributes[mesh.VertexSet],
+			Indices: d.accessor(indices, gltfUnsignedInt, len(indices), "SCALAR", gltfElementArrayBuffer),
+			Mode: gltfTriangles,
+		}
+		if mesh.Material >= 0 && mesh.Material < len(m.Materials) {
+			material := mesh.Material
+			primitive.Material = &material
+		}
+
+		d.Meshes = append(d.Meshes, gltfMesh{ Primitives: []gltfPrimitive{ primitive } })
+
+		node := gltfNode{ Name: fmt.Sprintf("mesh%d", i), Mesh: new(int) }
+		*node.Mesh = len(d.Meshes) - 1
+		if skinned[mesh.VertexSet] {
+			node.Skin = skin
+		}
+
+		d.Nodes = append(d.Nodes, node)
+		roots = append(roots, len(d.Nodes) - 1)
+	}
+
+	for _, animation := range animations {
+		if a := m.gltfAnimation(d, animation); len(a.Channels) > 0 {
+			d.Animations = append(d.Animations, a)
+		}
+	}
+
+	d.Scenes = []gltfScene{ gltfScene{ roots } }
+	if d.data.Len() > 0 {
+		d.Buffers = []gltfBuffer{ gltfBuffer{ d.data.Len(), "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(d.data.Bytes()) } }
+	}
+
+	data, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(data)
+	return err
+}
+
+func (m *Model) gltfAttributes(d *gltfDocument, vset *VertexSet, skinned map[int]bool, index int) map[string]int {
+	attributes := make(map[string]int)
+
+	if positions, ok := vset.Attribute(VertexPosition); ok {
+		attributes["POSITION"] = d.vec(positions, 3, false)
+
+		accessor := &d.Accessors[attributes["POSITION"]]
+		accessor.Min = []float32{ float32(math.Inf(1)), float32(math.Inf(1)), float32(math.Inf(1)) }
+		accessor.Max = []float32{ float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)) }
+		for _, p := range positions {
+			for c := 0; c < 3; c++ {
+				accessor.Min[c] = float32(math.Min(float64(accessor.Min[c]), float64(p[c])))
+				accessor.Max[c] = float32(math.Max(float64(accessor.Max[c]), float64(p[c])))
+			}
+		}
+	}
+
+	if normals, ok := vset.Attribute(VertexNormal); ok {
+		attributes["NORMAL"] = d.vec(normals, 3, true)
+	}
+
+	for i, semantic := range []int{ VertexUV0, VertexUV1 } {
+		if uvs, ok := vset.Attribute(semantic); ok {
+			attributes[fmt.Sprintf("TEXCOORD_%d", i)] = d.vec(uvs, 2, false)
+		}
+	}
+
+	if colors, ok := vset.Attribute(VertexColor); ok {
+		attributes["COLOR_0"] = d.vec(colors, 4, false)
+	}
+
+	weights, hasWeights := vset.Attribute(VertexWeights)
+	joints, hasJoints := vset.Attribute(VertexJoints)
+	if hasWeights && hasJoints && len(m.Nodes) > 0 {
+		jointData := make([]uint16, 0, len(joints) * 4)
+		for v := range joints {
+			var sum float32
+			for c := 0; c < 4; c++ {
+				joint := int(joints[v][c])
+				if joint < len(vset.BonePalette) {
+					joint = vset.BonePalette[joint]
+				}
+
+				if joint >= len(m.Nodes) || joint < 0 {
+					joint, weights[v][c] = 0, 0
+				}
+
+				jointData = append(jointData, uint16(joint))
+				sum += weights[v][c]
+			}
+
+			if sum > 0 {
+				for c := range weights[v] {
+					weights[v][c] /= sum
+				}
+			} else {
+				weights[v] = [4]float32{ 1, 0, 0, 0 }
+			}
+		}
+
+		attributes["JOINTS_0"] = d.accessor(jointData, gltfUnsignedShort, len(joints), "VEC4", gltfArrayBuffer)
+		attributes["WEIGHTS_0"] = d.vec(weights, 4, false)
+		skinned[index] = true
+	}
+
+	return attributes
+}
+
+// Segments are matched to bones by name, falling back to their index
+func (m *Model) motionNode(segment *MotionSegment) int {
+	for i := range m.Nodes {
+		if m.Nodes[i].Name == segment.Name {
+			return i
+		}
+	}
+
+	if segment.NodeID >= 0 && segment.NodeID < len(m.Nodes) {
+		return segment.NodeID
+	}
+
+	return -1
+}
+
+func (m *Model) gltfAnimation(d *gltfDocument, animation Animation) (a gltfAnimation) {
+	a.Name = animation.Name
+
+	speed := animation.Motion.FrameSpeed
+	if speed <= 0 {
+		speed = 30
+	}
+
+	for s := range animation.Motion.Segments {
+		segment := &animation.Motion.Segments[s]
+
+		node := m.motionNode(segment)
+		if node < 0 {
+			continue
+		}
+
+		for t := range segment.Tracks {
+			track := &segment.Tracks[t]
+			if len(track.Values) == 0 || len(track.Values) != len(track.Frames) {
+				continue
+			}
+
+			path, components := "", 3
+			switch track.Type {
+				case MotionKeyPosition:
+					path = "translation"
+				case MotionKeyRotation:
+					path, components = "rotation", 4
+				case MotionKeyScale:
+					path = "scale"
+				default:
+					continue
+			}
+
+			// Animation data isn't vertex data, so the buffer views get no target
+			values := make([]float32, 0, len(track.Values) * components)
+			for _, v := range track.Values {
+				values = append(values, v[:components]...)
+			}
+			output := d.accessor(values, gltfFloat, len(track.Values), fmt.Sprintf("VEC%d", components), 0)
+
+			times := make([]float32, len(track.Frames))
+			for i, frame := range track.Frames {
+				times[i] = float32(frame) / speed
+			}
+
+			input := d.accessor(times, gltfFloat, len(times), "SCALAR", 0)
+			d.Accessors[input].Min = []float32{ times[0] }
+			d.Accessors[input].Max = []float32{ times[len(times) - 1] }
+
+			a.Samplers = append(a.Samplers, gltfSampler{ input, output, "LINEAR" })
+			a.Channels = append(a.Channels, gltfChannel{ len(a.Samplers) - 1, gltfTarget{ node, path } })
+		}
+	}
+
+	return
+}
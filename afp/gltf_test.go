@@ -0,0 +1,43 @@
+package afp
+
+import (
+	"bytes"
+	"testing"
+	"encoding/json"
+)
+
+func TestGLTFEmptyMesh(t *testing.T) {
+	m := &Model{
+		VertexSets: []VertexSet{ { VertexSize: 12, Layout: []VertexElement{ { VertexPosition, VertexFloat3, 0 } } } },
+		PrimitiveSets: []PrimitiveSet{ {} },
+		Meshes: []Mesh{ { Material: -1 } },
+	}
+
+	var out bytes.Buffer
+	if err := m.WriteGLTF(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	var d map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := d["meshes"]; ok {
+		t.Error("empty mesh was exported")
+	}
+
+	if _, ok := d["buffers"]; ok {
+		t.Error("empty buffer was exported")
+	}
+}
+
+func TestBaseColorFactor(t *testing.T) {
+	if c := baseColorFactor([]float32{ 0.5, 0.25, 1 }); c != [4]float32{ 0.5, 0.25, 1, 1 } {
+		t.Errorf("base color %v", c)
+	}
+
+	if c := baseColorFactor([]float32{ 0, 0, 0 }); c != [4]float32{ 1, 1, 1, 1 } {
+		t.Errorf("unset base color %v", c)
+	}
+}
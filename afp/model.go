@@ -4,23 +4,49 @@ import (
 	"io"
 	"fmt"
 	"errors"
+	"io/ioutil"
 	"aaronlindsay.com/go/pkg/pso2/util"
 	"github.com/quarnster/util/encoding/binary"
+	bin "encoding/binary"
 )
 
 const (
 	ModelHeaderMagic uint32 = 0x46425456 // little endian "VTBF"
 )
 
-type Model struct {
+// VTBF is the tagged container shared by models, skeletons and motions
+type VTBF struct {
 	reader io.ReadSeeker
 
 	Header ModelHeader
 	Entries []ModelEntry
+
+	// Header bytes beyond ModelHeader
+	headerData []uint8
+}
+
+func NewVTBF(reader io.ReadSeeker) (*VTBF, error) {
+	v := &VTBF{ reader: reader }
+	return v, v.parse()
+}
+
+type Model struct {
+	VTBF
+
+	VertexSets []VertexSet
+	PrimitiveSets []PrimitiveSet
+	Meshes []Mesh
+	Materials []Material
+	Shaders []Shader
+	TextureStates []TextureState
+	TextureSets []TextureSet
+	Textures []string
+	Nodes []Node
+	NodeOs []NodeO
 }
 
 func NewModel(reader io.ReadSeeker) (*Model, error) {
-	m := &Model{ reader: reader }
+	m := &Model{ VTBF: VTBF{ reader: reader } }
 	return m, m.parse()
 }
 
@@ -39,6 +65,7 @@ type ModelEntry struct {
 	Alignment []uint8 `skip:"Size-4" length:"0"`
 
 	Data io.ReadSeeker `if:"0"`
+	Section *Section `if:"0"`
 }
 
 func (h *ModelHeader) Validate() error {
@@ -57,14 +84,25 @@ func (h *ModelHeader) Validate() error {
 	return nil
 }
 
-func (m *Model) parse() (err error) {
+func (m *VTBF) parse() (err error) {
 	reader := binary.BinaryReader{ Reader: m.reader, Endianess: binary.LittleEndian }
 
+	if _, err = m.reader.Seek(0, 0); err != nil {
+		return
+	}
+
 	if err = reader.ReadInterface(&m.Header); err != nil {
 		return
 	}
 
-	reader.Seek(int64(m.Header.HeaderSize) - 0x10, 1)
+	if err = m.Header.Validate(); err != nil {
+		return
+	}
+
+	m.headerData = make([]uint8, m.Header.HeaderSize - 0x10)
+	if _, err = io.ReadFull(m.reader, m.headerData); err != nil {
+		return
+	}
 
 	offset := int64(m.Header.HeaderSize)
 	for err == nil {
@@ -80,83 +118,79 @@ func (m *Model) parse() (err error) {
 		entry.Data = io.NewSectionReader(util.ReaderAt(m.reader), offset + 0x0c, int64(entry.Size) - 0x04)
 		offset += 0x08 + int64(entry.Size)
 
-		m.Entries = append(m.Entries, entry)
+		if entry.Type != "vtc0" {
+			return errors.New("vtc0 tag expected")
+		}
 
-		switch entry.SubType {
-			case "NODE": // Bone data
+		if entry.Section, err = readSection(entry.SubType, entry.Data); err != nil {
+			return fmt.Errorf("%s: %v", entry.SubType, err)
+		}
 
-			case "NODO": // More bone things
+		if entry.Section.padding, err = ioutil.ReadAll(entry.Data); err != nil {
+			return
+		}
 
-			case "VSET": // Vertex data shit
-				err = parseModelEntryVSET(&entry)
+		if _, err = entry.Data.Seek(0, 0); err != nil {
+			return
 		}
+
+		m.Entries = append(m.Entries, entry)
 	}
 
 	return
 }
 
-func (m *Model) Write(writer io.Writer) error {
-	return nil
-}
+func (m *Model) parse() (err error) {
+	if err = m.VTBF.parse(); err != nil {
+		return
+	}
 
-func parseModelEntryVSET(entry *ModelEntry) error {
-	reader := binary.BinaryReader{ Reader: entry.Data, Endianess: binary.LittleEndian }
+	for _, entry := range m.Entries {
+		if err = m.decodeSection(entry.Section); err != nil {
+			return fmt.Errorf("%s: %v", entry.SubType, err)
+		}
+	}
 
-	var err error
+	return
+}
 
-	unk, err := reader.Uint16()
-	count, err := reader.Uint16()
+// Write encodes the model, including any changes made to its typed sections
+func (m *Model) Write(writer io.Writer) (err error) {
+	if err = m.encodeSections(); err != nil {
+		return
+	}
 
-	fmt.Printf("Count: %04x\n", count)
-	fmt.Printf("Unk: %04x\n", unk)
+	return m.VTBF.Write(writer)
+}
 
-	for i := uint16(0); i < count && err == nil; i++ {
-		var identifier uint16
-		identifier, err = reader.Uint16()
-		var data []uint8
+func (m *VTBF) Write(writer io.Writer) (err error) {
+	var modelType [4]uint8
+	copy(modelType[:], m.Header.Type)
 
-		if err != nil {
-			break
+	header := []interface{}{ m.Header.Magic, uint32(0x10 + len(m.headerData)), modelType, m.Header.Unk, m.headerData }
+	for _, v := range header {
+		if err = bin.Write(writer, bin.LittleEndian, v); err != nil {
+			return
 		}
+	}
 
-		if identifier & 0x8000 != 0 { // Size is fucked up
-			var sz, unk uint8
-			unk, err = reader.Uint8()
-
-			var size uint16
-			if unk == 0x10 {
-				size, err = reader.Uint16()
-			} else if unk == 0x08 {
-				sz, err = reader.Uint8();
-				size = uint16(sz)
-			} else {
-				return errors.New("Unknown size flag")
-			}
-
-			fmt.Printf("Oh %02x, %04x\n", unk, size)
-			x := 2
-			data = make([]uint8, x * int(size + 1))
-		} else if identifier & 0x0900 != 0 { // and 0x0900
-			data = make([]uint8, 4)
-		} else if identifier & 0x0600 == 0x0600 { // and 0x0600
-			data = make([]uint8, 2)
-		} else if identifier & 0xff00 != 0 {
-			fmt.Printf("what the fuck is %04x\n", identifier & 0xff00)
-			//break
+	for _, entry := range m.Entries {
+		data, err := entry.Section.Bytes()
+		if err != nil {
+			return fmt.Errorf("%s: %v", entry.SubType, err)
 		}
 
-		_, err = util.ReadN(entry.Data, data)
-
-		fmt.Printf("\t\t%04x (%08x): %x\n", identifier, len(data), data)
-	}
-
-	if _, err = entry.Data.Read(make([]uint8, 1)); err != io.EOF {
-		return errors.New("Expected EOF")
-	}
+		var tag, subType [4]uint8
+		copy(tag[:], entry.Type)
+		copy(subType[:], entry.SubType)
 
-	if err == io.EOF {
-		return nil
+		chunk := []interface{}{ tag, uint32(len(subType) + len(data)), subType, data }
+		for _, v := range chunk {
+			if err = bin.Write(writer, bin.LittleEndian, v); err != nil {
+				return err
+			}
+		}
 	}
 
-	return err
+	return
 }
@@ -0,0 +1,569 @@
+package afp
+
+import (
+	"fmt"
+	"math"
+	bin "encoding/binary"
+)
+
+// Vertex element semantics (VTXE)
+const (
+	VertexPosition = 0x00
+	VertexWeights = 0x01
+	VertexNormal = 0x02
+	VertexColor = 0x03
+	VertexColor2 = 0x04
+	VertexJoints = 0x0b
+	VertexUV0 = 0x10
+	VertexUV1 = 0x11
+	VertexUV2 = 0x12
+	VertexUV3 = 0x13
+	VertexTangent = 0x20
+	VertexBinormal = 0x21
+)
+
+// Vertex element formats (VTXE)
+const (
+	VertexFloat2 = 0x02
+	VertexFloat3 = 0x03
+	VertexFloat4 = 0x04
+	VertexUbyte4 = 0x05
+	VertexUbyte4N = 0x11
+	VertexShort2N = 0x07
+	VertexShort4N = 0x0f
+)
+
+type VertexElement struct {
+	Semantic, Format, Offset int
+}
+
+type VertexSet struct {
+	VertexSize, VertexCount int
+	BonePalette []int
+	EdgeVertices []int
+
+	Layout []VertexElement
+	Data []uint8
+}
+
+// Primitive modes (PSET)
+const (
+	PrimitiveTriangles = 0
+	PrimitiveTriangleStrip = 1
+)
+
+type PrimitiveSet struct {
+	Mode int
+	Indices []int
+}
+
+type Mesh struct {
+	Flags int
+	Material, Render, Shader, TextureSet, BaseNode int
+	VertexSet, PrimitiveSet int
+}
+
+type Material struct {
+	Name string
+	Diffuse, Ambient, Specular, Emissive [3]float32
+	Power float32
+	BlendMode string
+}
+
+type Shader struct {
+	Flags int
+	PixelShader, VertexShader string
+}
+
+type TextureState struct {
+	Tag, UsageOrder, UVSet int
+	Name string
+}
+
+type TextureSet struct {
+	Mode int
+	TextureStates []int
+}
+
+type Node struct {
+	Name string
+	Flags, Index, Parent, FirstChild, NextSibling int
+	Animated bool
+	Position, Rotation, Scale [3]float32
+
+	// Inverse bind matrix, row major with the translation in the last row
+	Matrix [16]float32
+}
+
+// Effect attachment points (NODO)
+type NodeO struct {
+	Name string
+	Flags, Parent int
+	Position, Rotation [3]float32
+}
+
+// Tag ids for each typed section
+const (
+	idVertexSize = 0xb6
+	idVertexCount = 0xbf
+	idBonePalette = 0xbe
+	idEdgeVertices = 0xc8
+
+	idVertexSemantic = 0xd0
+	idVertexFormat = 0xd1
+	idVertexOffset = 0xd2
+
+	idVertexData = 0xba
+
+	idPrimitiveMode = 0xc6
+	idPrimitiveIndices = 0xb8
+
+	idMeshFlags = 0xb0
+	idMeshMaterial = 0xb1
+	idMeshRender = 0xb2
+	idMeshShader = 0xb3
+	idMeshTextureSet = 0xb4
+	idMeshBaseNode = 0xb5
+	idMeshVertexSet = 0xc0
+	idMeshPrimitiveSet = 0xc1
+
+	idMaterialDiffuse = 0x30
+	idMaterialAmbient = 0x31
+	idMaterialSpecular = 0x32
+	idMaterialEmissive = 0x33
+	idMaterialPower = 0x34
+	idMaterialName = 0x39
+	idMaterialBlendMode = 0x3a
+
+	idShaderFlags = 0x90
+	idShaderPixel = 0x91
+	idShaderVertex = 0x92
+
+	idTextureStateTag = 0x60
+	idTextureStateUsage = 0x61
+	idTextureStateUVSet = 0x62
+	idTextureStateName = 0x6a
+
+	idTextureSetMode = 0x70
+	idTextureSetStates = 0x76
+
+	idTextureFile = 0x80
+
+	idNodePosition = 0x01
+	idNodeRotation = 0x02
+	idNodeScale = 0x03
+	idNodeFlags = 0x04
+	idNodeAnimated = 0x05
+	idNodeParent = 0x06
+	idNodeIndex = 0x07
+	idNodeFirstChild = 0x08
+	idNodeNextSibling = 0x09
+	idNodeMatrix = 0x0a
+	idNodeName = 0x0d
+)
+
+// ElementSize returns the size in bytes of a vertex element format
+func ElementSize(format int) (int, error) {
+	switch format {
+		case VertexFloat2:
+			return 8, nil
+		case VertexFloat3:
+			return 12, nil
+		case VertexFloat4:
+			return 16, nil
+		case VertexUbyte4, VertexUbyte4N, VertexShort2N:
+			return 4, nil
+		case VertexShort4N:
+			return 8, nil
+	}
+
+	return 0, fmt.Errorf("unknown vertex format 0x%x", format)
+}
+
+func (m *Model) decodeSection(s *Section) (err error) {
+	elements := s.Elements()
+
+	switch s.Type {
+		case "VSET":
+			for _, e := range elements {
+				m.VertexSets = append(m.VertexSets, VertexSet{
+					VertexSize: e.Int(idVertexSize),
+					VertexCount: e.Int(idVertexCount),
+					BonePalette: e.Ints(idBonePalette),
+					EdgeVertices: e.Ints(idEdgeVertices),
+				})
+			}
+
+		case "VTXE":
+			// Vertex layouts follow VSET, one per vertex set
+			v, err := m.nextVertexSet(func(v *VertexSet) bool { return v.Layout == nil })
+			if err != nil {
+				return err
+			}
+
+			v.Layout = []VertexElement{}
+			for _, e := range elements {
+				element := VertexElement{ e.Int(idVertexSemantic), e.Int(idVertexFormat), e.Int(idVertexOffset) }
+				size, err := ElementSize(element.Format)
+				if err != nil {
+					return err
+				}
+
+				if element.Offset + size > v.VertexSize {
+					return fmt.Errorf("vertex element 0x%x out of bounds", element.Semantic)
+				}
+
+				v.Layout = append(v.Layout, element)
+			}
+
+		case "VTXL":
+			v, err := m.nextVertexSet(func(v *VertexSet) bool { return v.Data == nil })
+			if err != nil {
+				return err
+			}
+
+			if len(elements) > 0 {
+				v.Data = elements[0].Bytes(idVertexData)
+			}
+
+			if len(v.Data) != v.VertexSize * v.VertexCount {
+				return fmt.Errorf("vertex data size mismatch (0x%x != 0x%x * 0x%x)", len(v.Data), v.VertexSize, v.VertexCount)
+			}
+
+		case "PSET":
+			for _, e := range elements {
+				m.PrimitiveSets = append(m.PrimitiveSets, PrimitiveSet{ e.Int(idPrimitiveMode), e.Ints(idPrimitiveIndices) })
+			}
+
+		case "MESH":
+			for _, e := range elements {
+				m.Meshes = append(m.Meshes, Mesh{
+					Flags: e.Int(idMeshFlags),
+					Material: e.Int(idMeshMaterial),
+					Render: e.Int(idMeshRender),
+					Shader: e.Int(idMeshShader),
+					TextureSet: e.Int(idMeshTextureSet),
+					BaseNode: e.Int(idMeshBaseNode),
+					VertexSet: e.Int(idMeshVertexSet),
+					PrimitiveSet: e.Int(idMeshPrimitiveSet),
+				})
+			}
+
+		case "MATE":
+			for _, e := range elements {
+				m.Materials = append(m.Materials, Material{
+					Name: e.String(idMaterialName),
+					Diffuse: e.Vector(idMaterialDiffuse),
+					Ambient: e.Vector(idMaterialAmbient),
+					Specular: e.Vector(idMaterialSpecular),
+					Emissive: e.Vector(idMaterialEmissive),
+					Power: e.Float(idMaterialPower),
+					BlendMode: e.String(idMaterialBlendMode),
+				})
+			}
+
+		case "SHAD":
+			for _, e := range elements {
+				m.Shaders = append(m.Shaders, Shader{ e.Int(idShaderFlags), e.String(idShaderPixel), e.String(idShaderVertex) })
+			}
+
+		case "TSTA":
+			for _, e := range elements {
+				m.TextureStates = append(m.TextureStates, TextureState{
+					e.Int(idTextureStateTag), e.Int(idTextureStateUsage), e.Int(idTextureStateUVSet), e.String(idTextureStateName),
+				})
+			}
+
+		case "TSET":
+			for _, e := range elements {
+				m.TextureSets = append(m.TextureSets, TextureSet{ e.Int(idTextureSetMode), e.Ints(idTextureSetStates) })
+			}
+
+		case "TEXF":
+			for _, e := range elements {
+				m.Textures = append(m.Textures, e.String(idTextureFile))
+			}
+
+		case "NODE":
+			m.Nodes = append(m.Nodes, decodeNodes(elements)...)
+
+		case "NODO":
+			m.NodeOs = append(m.NodeOs, decodeNodeOs(elements)...)
+	}
+
+	return
+}
+
+func (m *Model) nextVertexSet(pending func(v *VertexSet) bool) (*VertexSet, error) {
+	for i := range m.VertexSets {
+		if v := &m.VertexSets[i]; pending(v) {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("vertex data without a matching VSET")
+}
+
+// Attribute decodes one vertex element for every vertex, padding each value out to 4 components.
+// Normalised formats are scaled to [0, 1] or [-1, 1], and ok is false if the vertex set lacks the semantic.
+func (v *VertexSet) Attribute(semantic int) (values [][4]float32, ok bool) {
+	var element *VertexElement
+	for i := range v.Layout {
+		if v.Layout[i].Semantic == semantic {
+			element = &v.Layout[i]
+			break
+		}
+	}
+
+	if element == nil {
+		return nil, false
+	}
+
+	values = make([][4]float32, v.VertexCount)
+	for i := range values {
+		data := v.Data[i * v.VertexSize + element.Offset:]
+		value := &values[i]
+
+		switch element.Format {
+			case VertexFloat2, VertexFloat3, VertexFloat4:
+				for c := 0; c < element.Format; c++ {
+					value[c] = math.Float32frombits(bin.LittleEndian.Uint32(data[c * 4:]))
+				}
+			case VertexUbyte4:
+				for c := 0; c < 4; c++ {
+					value[c] = float32(data[c])
+				}
+			case VertexUbyte4N:
+				for c := 0; c < 4; c++ {
+					value[c] = float32(data[c]) / 0xff
+				}
+			case VertexShort2N, VertexShort4N:
+				count := 2
+				if element.Format == VertexShort4N {
+					count = 4
+				}
+
+				for c := 0; c < count; c++ {
+					value[c] = float32(int16(bin.LittleEndian.Uint16(data[c * 2:]))) / 0x7fff
+				}
+		}
+	}
+
+	return values, true
+}
+
+// Triangles returns the primitive set as a triangle list, dropping the degenerate triangles used to stitch strips
+func (p *PrimitiveSet) Triangles() (indices []int) {
+	if p.Mode != PrimitiveTriangleStrip {
+		return p.Indices
+	}
+
+	for i := 2; i < len(p.Indices); i++ {
+		a, b, c := p.Indices[i - 2], p.Indices[i - 1], p.Indices[i]
+		if a == b || b == c || a == c {
+			continue
+		}
+
+		if i % 2 == 0 {
+			indices = append(indices, a, b, c)
+		} else {
+			indices = append(indices, b, a, c)
+		}
+	}
+
+	return
+}
+
+// setVector writes a vector back into its tag if it differs from what decoding the tag gives. Tags that are missing
+// or don't hold floats are left alone, and a changed vector has to keep the tag's component count.
+func (e Element) setVector(id uint8, v []float32) error {
+	current := e.Floats(id)
+	if current == nil {
+		return nil
+	}
+
+	decoded := make([]float32, len(v))
+	copy(decoded, current)
+
+	changed := false
+	for i := range v {
+		if math.Float32bits(v[i]) != math.Float32bits(decoded[i]) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if len(current) != len(v) {
+		return fmt.Errorf("tag 0x%02x has %d components, not %d", id, len(current), len(v))
+	}
+
+	e.SetFloats(id, v)
+	return nil
+}
+
+// setVectors calls setVector for each id in turn
+func (e Element) setVectors(ids []uint8, vectors ...[]float32) error {
+	for i, id := range ids {
+		if err := e.setVector(id, vectors[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeSections writes the typed fields back into the tags they were decoded from. Tags that were not present
+// in the original model are not created.
+func (m *Model) encodeSections() error {
+	vertexLayouts, vertexData := 0, 0
+
+	for _, entry := range m.Entries {
+		s := entry.Section
+		elements := s.Elements()
+
+		count := len(elements)
+		switch s.Type {
+			case "VSET":
+				if count != len(m.VertexSets) {
+					return fmt.Errorf("VSET: element count changed")
+				}
+
+				for i, e := range elements {
+					v := &m.VertexSets[i]
+					e.SetInt(idVertexSize, v.VertexSize)
+					e.SetInt(idVertexCount, v.VertexCount)
+					e.SetInts(idBonePalette, v.BonePalette)
+					e.SetInts(idEdgeVertices, v.EdgeVertices)
+				}
+
+			case "VTXE":
+				if vertexLayouts >= len(m.VertexSets) || count != len(m.VertexSets[vertexLayouts].Layout) {
+					return fmt.Errorf("VTXE: element count changed")
+				}
+
+				for i, e := range elements {
+					element := &m.VertexSets[vertexLayouts].Layout[i]
+					e.SetInt(idVertexSemantic, element.Semantic)
+					e.SetInt(idVertexFormat, element.Format)
+					e.SetInt(idVertexOffset, element.Offset)
+				}
+				vertexLayouts++
+
+			case "VTXL":
+				if vertexData >= len(m.VertexSets) {
+					return fmt.Errorf("VTXL: vertex set count changed")
+				}
+
+				v := &m.VertexSets[vertexData]
+				if len(v.Data) != v.VertexSize * v.VertexCount {
+					return fmt.Errorf("VTXL: vertex data size mismatch")
+				}
+
+				if count > 0 {
+					elements[0].SetBytes(idVertexData, v.Data)
+				}
+				vertexData++
+
+			case "PSET":
+				if count != len(m.PrimitiveSets) {
+					return fmt.Errorf("PSET: element count changed")
+				}
+
+				for i, e := range elements {
+					e.SetInt(idPrimitiveMode, m.PrimitiveSets[i].Mode)
+					e.SetInts(idPrimitiveIndices, m.PrimitiveSets[i].Indices)
+				}
+
+			case "MESH":
+				if count != len(m.Meshes) {
+					return fmt.Errorf("MESH: element count changed")
+				}
+
+				for i, e := range elements {
+					mesh := &m.Meshes[i]
+					e.SetInt(idMeshFlags, mesh.Flags)
+					e.SetInt(idMeshMaterial, mesh.Material)
+					e.SetInt(idMeshRender, mesh.Render)
+					e.SetInt(idMeshShader, mesh.Shader)
+					e.SetInt(idMeshTextureSet, mesh.TextureSet)
+					e.SetInt(idMeshBaseNode, mesh.BaseNode)
+					e.SetInt(idMeshVertexSet, mesh.VertexSet)
+					e.SetInt(idMeshPrimitiveSet, mesh.PrimitiveSet)
+				}
+
+			case "MATE":
+				if count != len(m.Materials) {
+					return fmt.Errorf("MATE: element count changed")
+				}
+
+				for i, e := range elements {
+					mat := &m.Materials[i]
+					e.SetString(idMaterialName, mat.Name)
+					ids := []uint8{ idMaterialDiffuse, idMaterialAmbient, idMaterialSpecular, idMaterialEmissive }
+					if err := e.setVectors(ids, mat.Diffuse[:], mat.Ambient[:], mat.Specular[:], mat.Emissive[:]); err != nil {
+						return fmt.Errorf("MATE %d: %v", i, err)
+					}
+					e.SetFloat(idMaterialPower, mat.Power)
+					e.SetString(idMaterialBlendMode, mat.BlendMode)
+				}
+
+			case "SHAD":
+				if count != len(m.Shaders) {
+					return fmt.Errorf("SHAD: element count changed")
+				}
+
+				for i, e := range elements {
+					e.SetInt(idShaderFlags, m.Shaders[i].Flags)
+					e.SetString(idShaderPixel, m.Shaders[i].PixelShader)
+					e.SetString(idShaderVertex, m.Shaders[i].VertexShader)
+				}
+
+			case "TSTA":
+				if count != len(m.TextureStates) {
+					return fmt.Errorf("TSTA: element count changed")
+				}
+
+				for i, e := range elements {
+					t := &m.TextureStates[i]
+					e.SetInt(idTextureStateTag, t.Tag)
+					e.SetInt(idTextureStateUsage, t.UsageOrder)
+					e.SetInt(idTextureStateUVSet, t.UVSet)
+					e.SetString(idTextureStateName, t.Name)
+				}
+
+			case "TSET":
+				if count != len(m.TextureSets) {
+					return fmt.Errorf("TSET: element count changed")
+				}
+
+				for i, e := range elements {
+					e.SetInt(idTextureSetMode, m.TextureSets[i].Mode)
+					e.SetInts(idTextureSetStates, m.TextureSets[i].TextureStates)
+				}
+
+			case "TEXF":
+				if count != len(m.Textures) {
+					return fmt.Errorf("TEXF: element count changed")
+				}
+
+				for i, e := range elements {
+					e.SetString(idTextureFile, m.Textures[i])
+				}
+
+			case "NODE":
+				if err := encodeNodes(elements, m.Nodes); err != nil {
+					return err
+				}
+
+			case "NODO":
+				if err := encodeNodeOs(elements, m.NodeOs); err != nil {
+					return err
+				}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package afp
+
+import (
+	"testing"
+)
+
+func TestSetVector(t *testing.T) {
+	e := Element{
+		idMaterialDiffuse: &Tag{ ID: idMaterialDiffuse, Type: TagVector | TagFloat, Value: []float32{ 1, 1, 1 } },
+	}
+
+	diffuse := e.Vector(idMaterialDiffuse)
+	diffuse[1] = 0.5
+	if err := e.setVector(idMaterialDiffuse, diffuse[:]); err != nil {
+		t.Fatal(err)
+	}
+	if v := e.Vector(idMaterialDiffuse); v != diffuse {
+		t.Errorf("diffuse %v, expected %v", v, diffuse)
+	}
+
+	if err := e.setVector(idMaterialDiffuse, []float32{ 0, 0, 0, 1 }); err == nil {
+		t.Error("component count change was accepted")
+	}
+	if v := e.Vector(idMaterialDiffuse); v != diffuse {
+		t.Errorf("failed write changed diffuse to %v", v)
+	}
+
+	// Missing tags are left alone rather than created
+	if err := e.setVector(idMaterialAmbient, []float32{ 1, 2, 3 }); err != nil || e.Has(idMaterialAmbient) {
+		t.Errorf("missing tag: %v", err)
+	}
+}
+
+// Tags that don't hold 3 floats are written back only when the decoded vector changed
+func TestSetVectorUnchanged(t *testing.T) {
+	e := Element{
+		idMaterialAmbient: &Tag{ ID: idMaterialAmbient, Type: TagVector | TagInt32, Value: []int32{ 1, 2, 3 } },
+		idMaterialSpecular: &Tag{ ID: idMaterialSpecular, Type: TagRows | TagFloat, CountSize: TagCount8, Value: []float32{ 1, 2, 3, 4 } },
+	}
+
+	ambient, specular := e.Vector(idMaterialAmbient), e.Vector(idMaterialSpecular)
+	if err := e.setVectors([]uint8{ idMaterialAmbient, idMaterialSpecular }, ambient[:], specular[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := e.Floats(idMaterialSpecular); len(v) != 4 || v[3] != 4 {
+		t.Errorf("specular %v", v)
+	}
+
+	specular[0] = 0
+	if err := e.setVector(idMaterialSpecular, specular[:]); err == nil {
+		t.Error("changed row vector written with the wrong component count")
+	}
+}
@@ -0,0 +1,224 @@
+package afp
+
+import (
+	"io"
+	"fmt"
+)
+
+// Motion key types (MKEY)
+const (
+	MotionKeyPosition = 0x01
+	MotionKeyRotation = 0x02
+	MotionKeyScale = 0x03
+)
+
+// Key frame timings are stored in sixteenths of a frame, with the low bits flagging the first and last keys
+const (
+	motionFrameScale = 0x10
+	motionFrameFlags = motionFrameScale - 1
+	motionFrameFirst = 0x01
+	motionFrameLast = 0x02
+)
+
+// A Motion is an aqm file, holding key frame tracks for the nodes of a skeleton
+type Motion struct {
+	VTBF
+
+	Flags int
+	StartFrame, EndFrame int
+	FrameSpeed float32
+
+	Segments []MotionSegment
+}
+
+// A MotionSegment animates a single node (MSEG), identified by both its index and name
+type MotionSegment struct {
+	NodeType, NodeID int
+	Name string
+
+	Tracks []MotionTrack
+}
+
+// A MotionTrack holds the keys for one property of a node (MKEY).
+// Vector keys (positions, scales and xyzw rotation quaternions) are stored in Values, anything else in Floats.
+type MotionTrack struct {
+	Type, DataType int
+	Frames []int
+
+	// The low bits stored with each frame, written back with it. If keys are added or removed, the first and last
+	// key flags move to the new ends.
+	FrameFlags []int
+
+	Values [][4]float32
+	Floats []float32
+}
+
+const (
+	idMotionFlags = 0xe0
+	idMotionStartFrame = 0xe1
+	idMotionEndFrame = 0xe2
+	idMotionFrameSpeed = 0xe3
+	idMotionSegmentCount = 0xe4
+
+	idSegmentNodeType = 0xe5
+	idSegmentNodeID = 0xe6
+	idSegmentName = 0xe7
+	idSegmentTrackCount = 0xe8
+
+	idTrackType = 0xe9
+	idTrackDataType = 0xea
+	idTrackKeyCount = 0xeb
+	idTrackFrames = 0xec
+	idTrackValues = 0xed
+	idTrackFloats = 0xee
+)
+
+func NewMotion(reader io.ReadSeeker) (*Motion, error) {
+	m := &Motion{ VTBF: VTBF{ reader: reader } }
+	return m, m.parse()
+}
+
+func (m *Motion) parse() (err error) {
+	if err = m.VTBF.parse(); err != nil {
+		return
+	}
+
+	for _, entry := range m.Entries {
+		for _, e := range entry.Section.Elements() {
+			switch entry.SubType {
+				case "NDMO":
+					m.Flags = e.Int(idMotionFlags)
+					m.StartFrame = e.Int(idMotionStartFrame)
+					m.EndFrame = e.Int(idMotionEndFrame)
+					m.FrameSpeed = e.Float(idMotionFrameSpeed)
+
+				case "MSEG":
+					m.Segments = append(m.Segments, MotionSegment{
+						NodeType: e.Int(idSegmentNodeType),
+						NodeID: e.Int(idSegmentNodeID),
+						Name: e.String(idSegmentName),
+					})
+
+				case "MKEY":
+					// Tracks follow the segment they belong to
+					if len(m.Segments) == 0 {
+						return fmt.Errorf("MKEY: key frames without a matching MSEG")
+					}
+					segment := &m.Segments[len(m.Segments) - 1]
+
+					track := MotionTrack{ Type: e.Int(idTrackType), DataType: e.Int(idTrackDataType), Floats: e.Floats(idTrackFloats) }
+					for _, frame := range e.Ints(idTrackFrames) {
+						track.Frames = append(track.Frames, frame / motionFrameScale)
+						track.FrameFlags = append(track.FrameFlags, frame & motionFrameFlags)
+					}
+
+					values := e.Floats(idTrackValues)
+					track.Values = make([][4]float32, len(values) / 4)
+					for i := range track.Values {
+						copy(track.Values[i][:], values[i * 4:])
+					}
+
+					if keys := len(track.Values) + len(track.Floats); keys != len(track.Frames) {
+						return fmt.Errorf("MKEY: key count mismatch (%d frames, %d keys)", len(track.Frames), keys)
+					}
+
+					segment.Tracks = append(segment.Tracks, track)
+			}
+		}
+	}
+
+	return
+}
+
+// Write encodes the motion, including any changes made to its tracks. Key frames may be added or removed,
+// but segments and tracks may not.
+func (m *Motion) Write(writer io.Writer) (err error) {
+	segments, tracks := 0, 0
+
+	for _, entry := range m.Entries {
+		for _, e := range entry.Section.Elements() {
+			switch entry.SubType {
+				case "NDMO":
+					e.SetInt(idMotionFlags, m.Flags)
+					e.SetInt(idMotionStartFrame, m.StartFrame)
+					e.SetInt(idMotionEndFrame, m.EndFrame)
+					e.SetFloat(idMotionFrameSpeed, m.FrameSpeed)
+
+				case "MSEG":
+					if segments >= len(m.Segments) {
+						return fmt.Errorf("MSEG: segment count changed")
+					}
+
+					if segments > 0 && tracks != len(m.Segments[segments - 1].Tracks) {
+						return fmt.Errorf("MKEY: track count changed")
+					}
+
+					segment := &m.Segments[segments]
+					e.SetInt(idSegmentNodeType, segment.NodeType)
+					e.SetInt(idSegmentNodeID, segment.NodeID)
+					e.SetString(idSegmentName, segment.Name)
+					segments, tracks = segments + 1, 0
+
+				case "MKEY":
+					if segments == 0 || tracks >= len(m.Segments[segments - 1].Tracks) {
+						return fmt.Errorf("MKEY: track count changed")
+					}
+
+					track := &m.Segments[segments - 1].Tracks[tracks]
+					if err = track.encode(e); err != nil {
+						return
+					}
+					tracks++
+			}
+		}
+	}
+
+	if segments != len(m.Segments) || (segments > 0 && tracks != len(m.Segments[segments - 1].Tracks)) {
+		return fmt.Errorf("MSEG: segment count changed")
+	}
+
+	return m.VTBF.Write(writer)
+}
+
+func (t *MotionTrack) encode(e Element) error {
+	if keys := len(t.Values) + len(t.Floats); keys != len(t.Frames) || keys == 0 {
+		return fmt.Errorf("MKEY: key count mismatch (%d frames, %d keys)", len(t.Frames), keys)
+	}
+
+	frames := make([]int, len(t.Frames))
+	for i, frame := range t.Frames {
+		frames[i] = frame * motionFrameScale
+		if len(t.FrameFlags) == len(t.Frames) {
+			frames[i] |= t.FrameFlags[i] & motionFrameFlags
+			continue
+		}
+
+		if i < len(t.FrameFlags) {
+			frames[i] |= t.FrameFlags[i] & motionFrameFlags &^ (motionFrameFirst | motionFrameLast)
+		}
+		if i == 0 {
+			frames[i] |= motionFrameFirst
+		}
+		if i == len(frames) - 1 {
+			frames[i] |= motionFrameLast
+		}
+	}
+
+	values := make([]float32, 0, len(t.Values) * 4)
+	for _, v := range t.Values {
+		values = append(values, v[:]...)
+	}
+
+	e.SetInt(idTrackType, t.Type)
+	e.SetInt(idTrackDataType, t.DataType)
+	e.SetInt(idTrackKeyCount, len(t.Frames))
+	e.SetInts(idTrackFrames, frames)
+	if len(t.Values) > 0 {
+		e.SetFloats(idTrackValues, values)
+	}
+	if len(t.Floats) > 0 {
+		e.SetFloats(idTrackFloats, t.Floats)
+	}
+
+	return nil
+}
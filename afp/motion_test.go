@@ -0,0 +1,85 @@
+package afp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// A motion with one rotation track, whose frames carry low bits besides the first and last key flags
+func testMotion() []uint8 {
+	frames := []uint16{ 0 * motionFrameScale | motionFrameFirst | 0x4, 5 * motionFrameScale | 0x8, 10 * motionFrameScale | motionFrameLast }
+
+	return testVTBF("AQM\x00",
+		testSection("NDMO", [][]uint8{
+			testTag(idMotionFlags, TagInt32, 0, int32(2)),
+			testTag(idMotionStartFrame, TagInt32, 0, int32(0)),
+			testTag(idMotionEndFrame, TagInt32, 0, int32(10)),
+			testTag(idMotionFrameSpeed, TagFloat, 0, float32(30)),
+		}),
+		testSection("MSEG", [][]uint8{
+			testTag(idSegmentNodeType, TagInt32, 0, int32(2)),
+			testTag(idSegmentNodeID, TagInt32, 0, int32(0)),
+			testString(idSegmentName, "root"),
+		}),
+		testSection("MKEY", [][]uint8{
+			testTag(idTrackType, TagInt32, 0, int32(MotionKeyRotation)),
+			testTag(idTrackDataType, TagInt32, 0, int32(3)),
+			testTag(idTrackKeyCount, TagInt32, 0, int32(len(frames))),
+			testTag(idTrackFrames, TagArray | TagUint16, TagCount8, frames),
+			testTag(idTrackValues, TagRows | TagFloat, TagCount8, []float32{ 0, 0, 0, 1, 0, 0.5, 0, 1, 0, 1, 0, 0 }),
+		}),
+	)
+}
+
+func TestMotionWriteUnchanged(t *testing.T) {
+	data := testMotion()
+
+	m, err := NewMotion(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if track := m.Segments[0].Tracks[0]; !reflect.DeepEqual(track.Frames, []int{ 0, 5, 10 }) {
+		t.Errorf("frames %v", track.Frames)
+	}
+
+	var out bytes.Buffer
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("wrote\n%x\nexpected\n%x", out.Bytes(), data)
+	}
+}
+
+func TestMotionWriteKeys(t *testing.T) {
+	m, err := NewMotion(bytes.NewReader(testMotion()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	track := &m.Segments[0].Tracks[0]
+	track.Frames = append(track.Frames, 20)
+	track.Values = append(track.Values, [4]float32{ 0, 0, 0, 1 })
+
+	var out bytes.Buffer
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, err = NewMotion(bytes.NewReader(out.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	track = &m.Segments[0].Tracks[0]
+	if !reflect.DeepEqual(track.Frames, []int{ 0, 5, 10, 20 }) {
+		t.Errorf("frames %v", track.Frames)
+	}
+
+	// The last key flag moves to the new key, and the other bits stay with their keys
+	if expected := []int{ motionFrameFirst | 0x4, 0x8, 0, motionFrameLast }; !reflect.DeepEqual(track.FrameFlags, expected) {
+		t.Errorf("frame flags %v, expected %v", track.FrameFlags, expected)
+	}
+}
@@ -0,0 +1,141 @@
+package afp
+
+import (
+	"io"
+	"fmt"
+)
+
+// A Skeleton is an aqn file, holding the bone hierarchy (NODE) and attachment points (NODO) shared by a set of models
+type Skeleton struct {
+	VTBF
+
+	Nodes []Node
+	NodeOs []NodeO
+}
+
+func NewSkeleton(reader io.ReadSeeker) (*Skeleton, error) {
+	s := &Skeleton{ VTBF: VTBF{ reader: reader } }
+	return s, s.parse()
+}
+
+func (s *Skeleton) parse() (err error) {
+	if err = s.VTBF.parse(); err != nil {
+		return
+	}
+
+	for _, entry := range s.Entries {
+		elements := entry.Section.Elements()
+
+		switch entry.SubType {
+			case "NODE":
+				s.Nodes = append(s.Nodes, decodeNodes(elements)...)
+			case "NODO":
+				s.NodeOs = append(s.NodeOs, decodeNodeOs(elements)...)
+		}
+	}
+
+	return
+}
+
+// Write encodes the skeleton, including any changes made to its nodes
+func (s *Skeleton) Write(writer io.Writer) (err error) {
+	for _, entry := range s.Entries {
+		elements := entry.Section.Elements()
+
+		switch entry.SubType {
+			case "NODE":
+				err = encodeNodes(elements, s.Nodes)
+			case "NODO":
+				err = encodeNodeOs(elements, s.NodeOs)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return s.VTBF.Write(writer)
+}
+
+func decodeNodes(elements []Element) (nodes []Node) {
+	for _, e := range elements {
+		node := Node{
+			Name: e.String(idNodeName),
+			Flags: e.Int(idNodeFlags),
+			Index: e.Int(idNodeIndex),
+			Parent: e.Int(idNodeParent),
+			FirstChild: e.Int(idNodeFirstChild),
+			NextSibling: e.Int(idNodeNextSibling),
+			Animated: e.Int(idNodeAnimated) != 0,
+			Position: e.Vector(idNodePosition),
+			Rotation: e.Vector(idNodeRotation),
+			Scale: e.Vector(idNodeScale),
+		}
+		copy(node.Matrix[:], e.Floats(idNodeMatrix))
+
+		nodes = append(nodes, node)
+	}
+
+	return
+}
+
+func decodeNodeOs(elements []Element) (nodes []NodeO) {
+	for _, e := range elements {
+		nodes = append(nodes, NodeO{
+			Name: e.String(idNodeName),
+			Flags: e.Int(idNodeFlags),
+			Parent: e.Int(idNodeParent),
+			Position: e.Vector(idNodePosition),
+			Rotation: e.Vector(idNodeRotation),
+		})
+	}
+
+	return
+}
+
+func encodeNodes(elements []Element, nodes []Node) error {
+	if len(elements) != len(nodes) {
+		return fmt.Errorf("NODE: element count changed")
+	}
+
+	for i, e := range elements {
+		node := &nodes[i]
+		e.SetString(idNodeName, node.Name)
+		e.SetInt(idNodeFlags, node.Flags)
+		e.SetInt(idNodeIndex, node.Index)
+		e.SetInt(idNodeParent, node.Parent)
+		e.SetInt(idNodeFirstChild, node.FirstChild)
+		e.SetInt(idNodeNextSibling, node.NextSibling)
+		if e.Has(idNodeAnimated) && (e.Int(idNodeAnimated) != 0) != node.Animated {
+			animated := 0
+			if node.Animated {
+				animated = 1
+			}
+			e.SetInt(idNodeAnimated, animated)
+		}
+		ids := []uint8{ idNodePosition, idNodeRotation, idNodeScale, idNodeMatrix }
+		if err := e.setVectors(ids, node.Position[:], node.Rotation[:], node.Scale[:], node.Matrix[:]); err != nil {
+			return fmt.Errorf("NODE %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+func encodeNodeOs(elements []Element, nodes []NodeO) error {
+	if len(elements) != len(nodes) {
+		return fmt.Errorf("NODO: element count changed")
+	}
+
+	for i, e := range elements {
+		node := &nodes[i]
+		e.SetString(idNodeName, node.Name)
+		e.SetInt(idNodeFlags, node.Flags)
+		e.SetInt(idNodeParent, node.Parent)
+		if err := e.setVectors([]uint8{ idNodePosition, idNodeRotation }, node.Position[:], node.Rotation[:]); err != nil {
+			return fmt.Errorf("NODO %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,364 @@
+package afp
+
+import (
+	"io"
+	"fmt"
+	"strings"
+	bin "encoding/binary"
+)
+
+// VTBF tag data is a list of (id, type, value) triples. The low bits of the type select the scalar type,
+// 0x40 makes it a 3 component vector, 0x80 an array and 0xc0 an array of 4 component rows (matrices).
+const (
+	TagBool uint8 = 0x00
+	TagBool2 uint8 = 0x01
+	TagUint8 uint8 = 0x02
+	TagInt8 uint8 = 0x03
+	TagUint16 uint8 = 0x04
+	TagInt16 uint8 = 0x05
+	TagUint16b uint8 = 0x06
+	TagInt16b uint8 = 0x07
+	TagUint32 uint8 = 0x08
+	TagInt32 uint8 = 0x09
+	TagFloat uint8 = 0x0a
+
+	TagVector uint8 = 0x40
+	TagArray uint8 = 0x80
+	TagRows uint8 = 0xc0
+
+	tagScalarMask uint8 = 0x3f
+	tagShapeMask uint8 = 0xc0
+)
+
+// Array lengths are stored minus one, in a field whose size is given by one of these
+const (
+	TagCount8 uint8 = 0x08
+	TagCount16 uint8 = 0x10
+	TagCount32 uint8 = 0x18
+)
+
+// Special tag ids used to lay out lists of structures
+const (
+	TagIDListBegin uint8 = 0xfc
+	TagIDListEnd uint8 = 0xfd
+	TagIDElement uint8 = 0xfe
+)
+
+const (
+	vectorComponents = 3
+	rowComponents = 4
+)
+
+type Tag struct {
+	ID, Type uint8
+
+	// How the array length is encoded, for array and row types
+	CountSize uint8
+
+	// A scalar of the Go type matching Type, or a slice of them for vectors, arrays and rows
+	Value interface{}
+}
+
+// A Section is the payload of a vtc0 chunk
+type Section struct {
+	Type string
+	PointerCount uint16
+	Tags []Tag
+
+	// Anything left in the chunk after the tags
+	padding []uint8
+}
+
+func scalarSize(t uint8) (int, error) {
+	switch t & tagScalarMask {
+		case TagBool, TagBool2, TagUint8, TagInt8:
+			return 1, nil
+		case TagUint16, TagInt16, TagUint16b, TagInt16b:
+			return 2, nil
+		case TagUint32, TagInt32, TagFloat:
+			return 4, nil
+	}
+
+	return 0, fmt.Errorf("unknown VTBF value type 0x%02x", t)
+}
+
+func scalarSlice(t uint8, count int) interface{} {
+	switch t & tagScalarMask {
+		case TagBool, TagBool2, TagUint8:
+			return make([]uint8, count)
+		case TagInt8:
+			return make([]int8, count)
+		case TagUint16, TagUint16b:
+			return make([]uint16, count)
+		case TagInt16, TagInt16b:
+			return make([]int16, count)
+		case TagUint32:
+			return make([]uint32, count)
+		case TagInt32:
+			return make([]int32, count)
+		case TagFloat:
+			return make([]float32, count)
+	}
+
+	return nil
+}
+
+// Unwraps a single element slice
+func scalarValue(slice interface{}) interface{} {
+	switch s := slice.(type) {
+		case []uint8:
+			return s[0]
+		case []int8:
+			return s[0]
+		case []uint16:
+			return s[0]
+		case []int16:
+			return s[0]
+		case []uint32:
+			return s[0]
+		case []int32:
+			return s[0]
+		case []float32:
+			return s[0]
+	}
+
+	return nil
+}
+
+func readCount(reader io.Reader, countSize uint8) (count int, err error) {
+	switch countSize {
+		case TagCount8:
+			var c uint8
+			err = bin.Read(reader, bin.LittleEndian, &c)
+			count = int(c)
+		case TagCount16:
+			var c uint16
+			err = bin.Read(reader, bin.LittleEndian, &c)
+			count = int(c)
+		case TagCount32:
+			var c uint32
+			err = bin.Read(reader, bin.LittleEndian, &c)
+			count = int(c)
+		default:
+			err = fmt.Errorf("unknown VTBF count size 0x%02x", countSize)
+	}
+
+	return count + 1, err
+}
+
+func readTag(reader io.Reader) (tag Tag, err error) {
+	var id [2]uint8
+	if err = bin.Read(reader, bin.LittleEndian, &id); err != nil {
+		return
+	}
+	tag.ID, tag.Type = id[0], id[1]
+
+	if _, err = scalarSize(tag.Type); err != nil {
+		return
+	}
+
+	count := 1
+	switch tag.Type & tagShapeMask {
+		case TagVector:
+			count = vectorComponents
+		case TagArray, TagRows:
+			if err = bin.Read(reader, bin.LittleEndian, &tag.CountSize); err != nil {
+				return
+			}
+
+			if count, err = readCount(reader, tag.CountSize); err != nil {
+				return
+			}
+
+			if tag.Type & tagShapeMask == TagRows {
+				count *= rowComponents
+			}
+	}
+
+	value := scalarSlice(tag.Type, count)
+	if err = bin.Read(reader, bin.LittleEndian, value); err != nil {
+		return
+	}
+
+	if tag.Type & tagShapeMask == 0 {
+		tag.Value = scalarValue(value)
+	} else {
+		tag.Value = value
+	}
+
+	return
+}
+
+// readSection decodes the tag data following a vtc0 chunk's type string
+func readSection(sectionType string, reader io.Reader) (s *Section, err error) {
+	s = &Section{ Type: sectionType }
+
+	var count uint16
+	if err = bin.Read(reader, bin.LittleEndian, &s.PointerCount); err != nil {
+		return
+	}
+
+	if err = bin.Read(reader, bin.LittleEndian, &count); err != nil {
+		return
+	}
+
+	s.Tags = make([]Tag, count)
+	for i := range s.Tags {
+		if s.Tags[i], err = readTag(reader); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+	}
+
+	return
+}
+
+// An Element is one structure out of a section, indexed by tag id
+type Element map[uint8]*Tag
+
+// Elements splits a section into structures. Sections holding a single structure return one element.
+func (s *Section) Elements() (elements []Element) {
+	var current Element
+
+	for i := range s.Tags {
+		tag := &s.Tags[i]
+
+		switch tag.ID {
+			case TagIDListBegin, TagIDListEnd:
+				current = nil
+				continue
+			case TagIDElement:
+				current = nil
+		}
+
+		if current == nil {
+			current = make(Element)
+			elements = append(elements, current)
+		}
+
+		current[tag.ID] = tag
+	}
+
+	return
+}
+
+func (e Element) Has(id uint8) bool {
+	_, ok := e[id]
+	return ok
+}
+
+// Int converts any integer scalar tag, returning 0 if it is missing
+func (e Element) Int(id uint8) int {
+	tag, ok := e[id]
+	if !ok {
+		return 0
+	}
+
+	switch v := tag.Value.(type) {
+		case uint8:
+			return int(v)
+		case int8:
+			return int(v)
+		case uint16:
+			return int(v)
+		case int16:
+			return int(v)
+		case uint32:
+			return int(v)
+		case int32:
+			return int(v)
+		case float32:
+			return int(v)
+	}
+
+	return 0
+}
+
+func (e Element) Float(id uint8) float32 {
+	if tag, ok := e[id]; ok {
+		if v, ok := tag.Value.(float32); ok {
+			return v
+		}
+	}
+
+	return float32(e.Int(id))
+}
+
+// Floats returns the components of a vector, array or row tag
+func (e Element) Floats(id uint8) []float32 {
+	if tag, ok := e[id]; ok {
+		if v, ok := tag.Value.([]float32); ok {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func (e Element) Vector(id uint8) (v [3]float32) {
+	copy(v[:], e.Floats(id))
+	return
+}
+
+func (e Element) Vector4(id uint8) (v [4]float32) {
+	copy(v[:], e.Floats(id))
+	return
+}
+
+func (e Element) Bytes(id uint8) []uint8 {
+	if tag, ok := e[id]; ok {
+		if v, ok := tag.Value.([]uint8); ok {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// Strings are stored as byte arrays, possibly null padded
+func (e Element) String(id uint8) string {
+	return strings.TrimRight(string(e.Bytes(id)), "\x00")
+}
+
+// Ints converts any integer array tag
+func (e Element) Ints(id uint8) (values []int) {
+	tag, ok := e[id]
+	if !ok {
+		return
+	}
+
+	switch v := tag.Value.(type) {
+		case []uint8:
+			for _, i := range v {
+				values = append(values, int(i))
+			}
+		case []int8:
+			for _, i := range v {
+				values = append(values, int(i))
+			}
+		case []uint16:
+			for _, i := range v {
+				values = append(values, int(i))
+			}
+		case []int16:
+			for _, i := range v {
+				values = append(values, int(i))
+			}
+		case []uint32:
+			for _, i := range v {
+				values = append(values, int(i))
+			}
+		case []int32:
+			for _, i := range v {
+				values = append(values, int(i))
+			}
+		default:
+			if i := e.Int(id); tag.Type & tagShapeMask == 0 {
+				values = []int{i}
+			}
+	}
+
+	return
+}
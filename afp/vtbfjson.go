@@ -0,0 +1,273 @@
+package afp
+
+import (
+	"fmt"
+	"math"
+	"bytes"
+	"errors"
+	"strconv"
+	"encoding/json"
+)
+
+// The JSON form of a VTBF stream keeps everything needed to rebuild it byte for byte. Tag ids and types are
+// written in hex, array count sizes are kept, and non-finite floats are written as hex strings of their bits.
+// Byte arrays that look like text are written as strings.
+type vtbfJSON struct {
+	Type string `json:"type"`
+	Unk uint32 `json:"unk"`
+	Header []uint8 `json:"header,omitempty"`
+	Sections []sectionJSON `json:"sections"`
+}
+
+type sectionJSON struct {
+	Chunk string `json:"chunk"`
+	Type string `json:"type"`
+	Pointers uint16 `json:"pointers"`
+	Tags []tagJSON `json:"tags"`
+	Padding []uint8 `json:"padding,omitempty"`
+}
+
+type tagJSON struct {
+	ID string `json:"id"`
+	Type string `json:"type"`
+	Count uint8 `json:"count,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+func floatJSON(f float32) interface{} {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return fmt.Sprintf("0x%08x", math.Float32bits(f))
+	}
+
+	return f
+}
+
+func isText(data []uint8) bool {
+	text := false
+	for _, c := range data {
+		if c >= 0x20 && c < 0x7f {
+			text = true
+		} else if c != 0 {
+			return false
+		}
+	}
+
+	return text
+}
+
+func tagValueJSON(tag *Tag) (interface{}, error) {
+	switch v := tag.Value.(type) {
+		case float32:
+			return floatJSON(v), nil
+		case []float32:
+			values := make([]interface{}, len(v))
+			for i, f := range v {
+				values[i] = floatJSON(f)
+			}
+
+			if tag.Type & tagShapeMask == TagRows {
+				rows := make([][]interface{}, 0, len(values) / rowComponents)
+				for i := 0; i + rowComponents <= len(values); i += rowComponents {
+					rows = append(rows, values[i:i + rowComponents])
+				}
+				return rows, nil
+			}
+
+			return values, nil
+		case []uint8:
+			if isText(v) && tag.Type & tagShapeMask == TagArray {
+				return string(v), nil
+			}
+
+			// Avoid base64
+			values := make([]uint16, len(v))
+			for i, b := range v {
+				values[i] = uint16(b)
+			}
+			return values, nil
+		case nil:
+			return nil, errors.New("missing tag value")
+	}
+
+	return tag.Value, nil
+}
+
+func (v *VTBF) MarshalJSON() ([]byte, error) {
+	doc := vtbfJSON{ Type: v.Header.Type, Unk: v.Header.Unk, Header: v.headerData }
+
+	for _, entry := range v.Entries {
+		s := entry.Section
+		section := sectionJSON{ Chunk: entry.Type, Type: s.Type, Pointers: s.PointerCount, Tags: make([]tagJSON, len(s.Tags)), Padding: s.padding }
+
+		for i := range s.Tags {
+			tag := &s.Tags[i]
+
+			value, err := tagValueJSON(tag)
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+
+			section.Tags[i] = tagJSON{ fmt.Sprintf("0x%02x", tag.ID), fmt.Sprintf("0x%02x", tag.Type), tag.CountSize, data }
+		}
+
+		doc.Sections = append(doc.Sections, section)
+	}
+
+	return json.Marshal(&doc)
+}
+
+func parseFloatJSON(value interface{}) (float32, error) {
+	switch v := value.(type) {
+		case json.Number:
+			f, err := strconv.ParseFloat(string(v), 32)
+			return float32(f), err
+		case string:
+			bits, err := strconv.ParseUint(v, 0, 32)
+			return math.Float32frombits(uint32(bits)), err
+	}
+
+	return 0, fmt.Errorf("invalid float %v", value)
+}
+
+func parseIntJSON(value interface{}, bits int, signed bool) (int64, error) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("invalid integer %v", value)
+	}
+
+	if signed {
+		return strconv.ParseInt(string(n), 0, bits)
+	}
+
+	u, err := strconv.ParseUint(string(n), 0, bits)
+	return int64(u), err
+}
+
+// Flattens rows into a single list of components
+func flattenJSON(value interface{}) (values []interface{}) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return []interface{}{ value }
+	}
+
+	for _, v := range list {
+		values = append(values, flattenJSON(v)...)
+	}
+
+	return
+}
+
+func tagValueFromJSON(tag *Tag, data json.RawMessage) (err error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err = decoder.Decode(&value); err != nil {
+		return
+	}
+
+	if _, err = scalarSize(tag.Type); err != nil {
+		return
+	}
+
+	var values []interface{}
+	if text, ok := value.(string); ok && tag.Type & tagShapeMask != 0 {
+		for _, c := range []uint8(text) {
+			values = append(values, json.Number(strconv.Itoa(int(c))))
+		}
+	} else {
+		values = flattenJSON(value)
+	}
+
+	slice := scalarSlice(tag.Type, len(values))
+	for i, v := range values {
+		switch s := slice.(type) {
+			case []float32:
+				s[i], err = parseFloatJSON(v)
+			default:
+				var n int64
+				switch s := slice.(type) {
+					case []uint8:
+						n, err = parseIntJSON(v, 8, false)
+						s[i] = uint8(n)
+					case []int8:
+						n, err = parseIntJSON(v, 8, true)
+						s[i] = int8(n)
+					case []uint16:
+						n, err = parseIntJSON(v, 16, false)
+						s[i] = uint16(n)
+					case []int16:
+						n, err = parseIntJSON(v, 16, true)
+						s[i] = int16(n)
+					case []uint32:
+						n, err = parseIntJSON(v, 32, false)
+						s[i] = uint32(n)
+					case []int32:
+						n, err = parseIntJSON(v, 32, true)
+						s[i] = int32(n)
+				}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	if tag.Type & tagShapeMask == 0 {
+		if len(values) != 1 {
+			return errors.New("scalar tag expects a single value")
+		}
+		tag.Value = scalarValue(slice)
+	} else {
+		tag.Value = slice
+	}
+
+	return
+}
+
+func (v *VTBF) UnmarshalJSON(data []byte) error {
+	var doc vtbfJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	*v = VTBF{}
+	v.Header = ModelHeader{ ModelHeaderMagic, uint32(0x10 + len(doc.Header)), doc.Type, doc.Unk }
+	v.headerData = doc.Header
+
+	for _, section := range doc.Sections {
+		s := &Section{ Type: section.Type, PointerCount: section.Pointers, Tags: make([]Tag, len(section.Tags)), padding: section.Padding }
+
+		for i, t := range section.Tags {
+			tag := &s.Tags[i]
+
+			id, err := strconv.ParseUint(t.ID, 0, 8)
+			if err != nil {
+				return err
+			}
+
+			tagType, err := strconv.ParseUint(t.Type, 0, 8)
+			if err != nil {
+				return err
+			}
+
+			tag.ID, tag.Type, tag.CountSize = uint8(id), uint8(tagType), t.Count
+			if tag.CountSize == 0 {
+				tag.CountSize = TagCount8
+			}
+
+			if err = tagValueFromJSON(tag, t.Value); err != nil {
+				return fmt.Errorf("%s tag %s: %v", section.Type, t.ID, err)
+			}
+		}
+
+		v.Entries = append(v.Entries, ModelEntry{ Type: section.Chunk, SubType: section.Type, Section: s })
+	}
+
+	return nil
+}
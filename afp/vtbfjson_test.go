@@ -0,0 +1,91 @@
+package afp
+
+import (
+	"math"
+	"bytes"
+	"strings"
+	"testing"
+	"encoding/json"
+)
+
+// A stream whose tags need the special cases of the JSON form: non-finite floats, text and binary byte arrays and
+// every count size
+func testJSONStream() []uint8 {
+	nan, inf := math.Float32frombits(0x7fc00001), float32(math.Inf(-1))
+
+	return testVTBF("AQO\x00",
+		testSection("ROOT", [][]uint8{
+			testString(0x00, "name"),
+			testTag(0x01, TagArray | TagUint8, TagCount8, []uint8{ 0x00, 0x80, 0xff }),
+			testTag(0x02, TagArray | TagUint8, TagCount8, []uint8{ 'A', 0x01 }),
+			testTag(0x03, TagArray | TagUint8, TagCount8, []uint8{ 0, 0 }),
+			testTag(0x04, TagVector | TagUint8, 0, []uint8{ 'a', 'b', 'c' }),
+		}),
+		testSection("MATE", testList([][]uint8{
+			testTag(0x10, TagFloat, 0, nan),
+			testTag(0x11, TagVector | TagFloat, 0, []float32{ inf, 0.5, float32(math.Inf(1)) }),
+			testTag(0x12, TagRows | TagFloat, TagCount8, []float32{ 1, nan, -2, 0.1 }),
+			testTag(0x13, TagArray | TagInt16, TagCount16, []int16{ -1, 2 }),
+			testTag(0x14, TagArray | TagUint32, TagCount32, []uint32{ 0xffffffff }),
+			testTag(0x15, TagInt8, 0, int8(-128)),
+		}), 0xcc, 0xcc),
+	)
+}
+
+func TestVTBFJSONRoundTrip(t *testing.T) {
+	data := testJSONStream()
+
+	v, err := NewVTBF(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`"0x7fc00001"`,
+		`"0xff800000"`,
+		`"0x7f800000"`,
+		`"value":"name\u0000"`,
+		`"value":[0,128,255]`,
+		`"value":[65,1]`,
+		`"value":[0,0]`,
+		`"value":[97,98,99]`,
+	} {
+		if !strings.Contains(string(doc), expected) {
+			t.Errorf("%s missing from %s", expected, doc)
+		}
+	}
+
+	var decoded VTBF
+	if err := json.Unmarshal(doc, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := decoded.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("wrote\n%x\nexpected\n%x", out.Bytes(), data)
+	}
+}
+
+func TestVTBFJSONErrors(t *testing.T) {
+	for _, doc := range []string{
+		`{"type":"AQO","sections":[{"type":"ROOT","tags":[{"id":"0x00","type":"0x0a","value":"0x1234567890"}]}]}`,
+		`{"type":"AQO","sections":[{"type":"ROOT","tags":[{"id":"0x00","type":"0x02","value":256}]}]}`,
+		`{"type":"AQO","sections":[{"type":"ROOT","tags":[{"id":"0x00","type":"0x09","value":[1,2]}]}]}`,
+		`{"type":"AQO","sections":[{"type":"ROOT","tags":[{"id":"0x00","type":"0x3f","value":1}]}]}`,
+		`{"type":"AQO","sections":[{"type":"ROOT","tags":[{"id":"0x100","type":"0x09","value":1}]}]}`,
+	} {
+		var v VTBF
+		if err := json.Unmarshal([]uint8(doc), &v); err == nil {
+			t.Errorf("%s accepted", doc)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package afp
+
+import (
+	"io"
+	"bytes"
+	"errors"
+	bin "encoding/binary"
+)
+
+func countCapacity(countSize uint8) int {
+	switch countSize {
+		case TagCount8:
+			return 0x100
+		case TagCount16:
+			return 0x10000
+	}
+
+	return 1 << 32
+}
+
+func tagValueLength(value interface{}) int {
+	switch v := value.(type) {
+		case []uint8:
+			return len(v)
+		case []int8:
+			return len(v)
+		case []uint16:
+			return len(v)
+		case []int16:
+			return len(v)
+		case []uint32:
+			return len(v)
+		case []int32:
+			return len(v)
+		case []float32:
+			return len(v)
+	}
+
+	return 1
+}
+
+func writeTag(writer io.Writer, tag *Tag) error {
+	if err := bin.Write(writer, bin.LittleEndian, [2]uint8{ tag.ID, tag.Type }); err != nil {
+		return err
+	}
+
+	count := tagValueLength(tag.Value)
+	switch tag.Type & tagShapeMask {
+		case TagVector:
+			if count != vectorComponents {
+				return errors.New("VTBF vector length mismatch")
+			}
+		case TagArray, TagRows:
+			if tag.Type & tagShapeMask == TagRows {
+				if count % rowComponents != 0 {
+					return errors.New("VTBF row length mismatch")
+				}
+				count /= rowComponents
+			}
+
+			if count == 0 {
+				return errors.New("VTBF arrays cannot be empty")
+			}
+
+			// Grow the count field if the array no longer fits
+			for count > countCapacity(tag.CountSize) {
+				tag.CountSize += TagCount8
+			}
+
+			var err error
+			switch tag.CountSize {
+				case TagCount8:
+					err = bin.Write(writer, bin.LittleEndian, []uint8{ tag.CountSize, uint8(count - 1) })
+				case TagCount16:
+					err = bin.Write(writer, bin.LittleEndian, tag.CountSize)
+					if err == nil {
+						err = bin.Write(writer, bin.LittleEndian, uint16(count - 1))
+					}
+				default:
+					tag.CountSize = TagCount32
+					err = bin.Write(writer, bin.LittleEndian, tag.CountSize)
+					if err == nil {
+						err = bin.Write(writer, bin.LittleEndian, uint32(count - 1))
+					}
+			}
+
+			if err != nil {
+				return err
+			}
+	}
+
+	return bin.Write(writer, bin.LittleEndian, tag.Value)
+}
+
+// Bytes encodes the section as it appears after a vtc0 chunk's type string
+func (s *Section) Bytes() ([]uint8, error) {
+	var buffer bytes.Buffer
+
+	if len(s.Tags) > 0xffff {
+		return nil, errors.New("too many VTBF tags")
+	}
+
+	bin.Write(&buffer, bin.LittleEndian, s.PointerCount)
+	bin.Write(&buffer, bin.LittleEndian, uint16(len(s.Tags)))
+
+	for i := range s.Tags {
+		if err := writeTag(&buffer, &s.Tags[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	buffer.Write(s.padding)
+
+	return buffer.Bytes(), nil
+}
+
+// SetInt stores an integer in an existing scalar tag, keeping its type
+func (e Element) SetInt(id uint8, value int) {
+	tag, ok := e[id]
+	if !ok {
+		return
+	}
+
+	switch tag.Value.(type) {
+		case uint8:
+			tag.Value = uint8(value)
+		case int8:
+			tag.Value = int8(value)
+		case uint16:
+			tag.Value = uint16(value)
+		case int16:
+			tag.Value = int16(value)
+		case uint32:
+			tag.Value = uint32(value)
+		case int32:
+			tag.Value = int32(value)
+		case float32:
+			tag.Value = float32(value)
+	}
+}
+
+func (e Element) SetFloat(id uint8, value float32) {
+	if tag, ok := e[id]; ok {
+		if _, ok := tag.Value.(float32); ok {
+			tag.Value = value
+		} else {
+			e.SetInt(id, int(value))
+		}
+	}
+}
+
+// SetFloats replaces the components of an existing float vector, array or row tag
+func (e Element) SetFloats(id uint8, values []float32) {
+	if tag, ok := e[id]; ok {
+		if _, ok := tag.Value.([]float32); ok {
+			tag.Value = append([]float32(nil), values...)
+		}
+	}
+}
+
+// SetInts replaces the contents of an existing integer array tag, keeping its element type
+func (e Element) SetInts(id uint8, values []int) {
+	tag, ok := e[id]
+	if !ok {
+		return
+	}
+
+	value := scalarSlice(tag.Type, len(values))
+	for i, v := range values {
+		switch s := value.(type) {
+			case []uint8:
+				s[i] = uint8(v)
+			case []int8:
+				s[i] = int8(v)
+			case []uint16:
+				s[i] = uint16(v)
+			case []int16:
+				s[i] = int16(v)
+			case []uint32:
+				s[i] = uint32(v)
+			case []int32:
+				s[i] = int32(v)
+			case []float32:
+				s[i] = float32(v)
+		}
+	}
+
+	if tag.Type & tagShapeMask != 0 && value != nil {
+		tag.Value = value
+	}
+}
+
+func (e Element) SetBytes(id uint8, value []uint8) {
+	if tag, ok := e[id]; ok {
+		if _, ok := tag.Value.([]uint8); ok {
+			tag.Value = value
+		}
+	}
+}
+
+// SetString stores a string, null terminated if the previous value was
+func (e Element) SetString(id uint8, value string) {
+	old := e.Bytes(id)
+	if old == nil || e.String(id) == value {
+		return
+	}
+
+	data := []uint8(value)
+	if old[len(old) - 1] == 0 {
+		data = append(data, 0)
+	}
+
+	e.SetBytes(id, data)
+}
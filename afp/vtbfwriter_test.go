@@ -0,0 +1,173 @@
+package afp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"io/ioutil"
+	bin "encoding/binary"
+)
+
+// testTag lays out a tag by hand. Arrays and rows are given the count size to store their length with.
+func testTag(id, tagType, countSize uint8, value interface{}) []uint8 {
+	var buffer bytes.Buffer
+	buffer.Write([]uint8{ id, tagType })
+
+	if shape := tagType & tagShapeMask; shape == TagArray || shape == TagRows {
+		count := reflect.ValueOf(value).Len()
+		if shape == TagRows {
+			count /= rowComponents
+		}
+
+		buffer.WriteByte(countSize)
+		switch countSize {
+			case TagCount8:
+				buffer.WriteByte(uint8(count - 1))
+			case TagCount16:
+				bin.Write(&buffer, bin.LittleEndian, uint16(count - 1))
+			default:
+				bin.Write(&buffer, bin.LittleEndian, uint32(count - 1))
+		}
+	}
+
+	bin.Write(&buffer, bin.LittleEndian, value)
+	return buffer.Bytes()
+}
+
+func testString(id uint8, s string) []uint8 {
+	return testTag(id, TagArray | TagUint8, TagCount8, []uint8(s + "\x00"))
+}
+
+// testList wraps the tags of each element in list markers
+func testList(elements ...[][]uint8) (tags [][]uint8) {
+	tags = append(tags, testTag(TagIDListBegin, TagUint32, 0, uint32(len(elements))))
+	for i, e := range elements {
+		tags = append(tags, testTag(TagIDElement, TagUint32, 0, uint32(i)))
+		tags = append(tags, e...)
+	}
+
+	return append(tags, testTag(TagIDListEnd, TagUint32, 0, uint32(0)))
+}
+
+// testSection returns a vtc0 chunk holding the tags followed by padding
+func testSection(sectionType string, tags [][]uint8, padding ...uint8) []uint8 {
+	var body bytes.Buffer
+	body.WriteString(sectionType)
+	bin.Write(&body, bin.LittleEndian, []uint16{ 0, uint16(len(tags)) })
+	for _, tag := range tags {
+		body.Write(tag)
+	}
+	body.Write(padding)
+
+	var chunk bytes.Buffer
+	chunk.WriteString("vtc0")
+	bin.Write(&chunk, bin.LittleEndian, uint32(body.Len()))
+	chunk.Write(body.Bytes())
+	return chunk.Bytes()
+}
+
+func testVTBF(modelType string, sections ...[]uint8) []uint8 {
+	var buffer bytes.Buffer
+	bin.Write(&buffer, bin.LittleEndian, []uint32{ ModelHeaderMagic, 0x10 })
+	buffer.WriteString(modelType)
+	bin.Write(&buffer, bin.LittleEndian, uint32(0x4c000001))
+	for _, s := range sections {
+		buffer.Write(s)
+	}
+
+	return buffer.Bytes()
+}
+
+// A model using the less common encodings: integer and row vectors, 16-bit counts that would fit in 8 bits and
+// padding after the tags
+func testModel() []uint8 {
+	matrix := make([]float32, 16)
+	for i := range matrix {
+		matrix[i] = float32(i) / 4
+	}
+
+	return testVTBF("AQO\x00",
+		testSection("ROOT", [][]uint8{ testString(0x00, "test") }),
+		testSection("MATE", testList([][]uint8{
+			testTag(idMaterialDiffuse, TagVector | TagFloat, 0, []float32{ 1, 0.5, 0.25 }),
+			testTag(idMaterialAmbient, TagVector | TagInt32, 0, []int32{ 1, 2, 3 }),
+			testTag(idMaterialSpecular, TagRows | TagFloat, TagCount8, []float32{ 1, 1, 1, 1 }),
+			testTag(idMaterialPower, TagFloat, 0, float32(5)),
+			testString(idMaterialName, "mat0"),
+		})),
+		testSection("NODE", testList([][]uint8{
+			testString(idNodeName, "root"),
+			testTag(idNodeParent, TagInt32, 0, int32(-1)),
+			testTag(idNodePosition, TagVector | TagFloat, 0, []float32{ 0, 1, 0 }),
+			testTag(idNodeMatrix, TagRows | TagFloat, TagCount8, matrix),
+		})),
+		testSection("PSET", testList([][]uint8{
+			testTag(idPrimitiveMode, TagInt32, 0, int32(PrimitiveTriangles)),
+			testTag(idPrimitiveIndices, TagArray | TagUint16, TagCount16, []uint16{ 0, 1, 2 }),
+		}), 0, 0, 0),
+	)
+}
+
+func TestModelWriteUnchanged(t *testing.T) {
+	data := testModel()
+
+	m, err := NewModel(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range m.Entries {
+		expected, err := ioutil.ReadAll(entry.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		section, err := entry.Section.Bytes()
+		if err != nil || !bytes.Equal(section, expected) {
+			t.Errorf("%s: encoded %x, %v, expected %x", entry.SubType, section, err, expected)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("wrote\n%x\nexpected\n%x", out.Bytes(), data)
+	}
+}
+
+func TestModelWriteChanged(t *testing.T) {
+	m, err := NewModel(bytes.NewReader(testModel()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Materials[0].Diffuse[1] = 0.75
+	m.Nodes[0].Matrix[12] = -1
+	m.PrimitiveSets[0].Indices = []int{ 2, 1, 0 }
+
+	var out bytes.Buffer
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = NewModel(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Materials[0].Diffuse != [3]float32{ 1, 0.75, 0.25 } || m.Nodes[0].Matrix[12] != -1 || !reflect.DeepEqual(m.PrimitiveSets[0].Indices, []int{ 2, 1, 0 }) {
+		t.Errorf("changes lost: %+v %+v %+v", m.Materials[0], m.Nodes[0], m.PrimitiveSets[0])
+	}
+}
+
+func TestModelHeaderSize(t *testing.T) {
+	data := testModel()
+	data[4] = 4
+
+	if _, err := NewModel(bytes.NewReader(data)); err == nil {
+		t.Error("header size 4 accepted")
+	}
+}
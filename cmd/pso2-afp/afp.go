@@ -6,7 +6,14 @@ import (
 	"io"
 	"path"
 	"flag"
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"io/ioutil"
+	"encoding/json"
 	"aaronlindsay.com/go/pkg/pso2/afp"
+	"aaronlindsay.com/go/pkg/pso2/format"
 )
 
 func usage() {
@@ -25,15 +32,63 @@ func ragequit(apath string, err error) {
 	}
 }
 
+type flagReplaceType map[string]string
+
+func (f flagReplaceType) String() (value string) {
+	value = `"`
+	first := true
+	for i, s := range f {
+		if !first {
+			value += ","
+		}
+		first = false
+		value += i + ":" + s
+	}
+	value += `"`
+	return
+}
+
+func (f *flagReplaceType) Set(value string) error {
+	*f = make(map[string]string)
+
+	values := strings.Split(value, ",")
+
+	for _, v := range values {
+		value := strings.Split(v, ":")
+
+		if len(value) != 2 {
+			return errors.New("invalid replacement format")
+		}
+
+		(*f)[value[0]] = value[1]
+	}
+
+	return nil
+}
+
+func isVTBF(data io.ReadSeeker) bool {
+	var magic [4]uint8
+	_, err := io.ReadFull(data, magic[:])
+	data.Seek(0, 0)
+	return err == nil && string(magic[:]) == "VTBF"
+}
+
 func main() {
 	var flagPrint bool
 	var flagExtract string
 	var flagWrite string
+	var flagGLTF string
+	var flagJSON bool
+	var flagReplace, flagTextures flagReplaceType
 
 	flag.Usage = usage
 	flag.BoolVar(&flagPrint, "p", false, "print details about the archive")
 	flag.StringVar(&flagExtract, "x", "", "extract the archive to a folder")
 	flag.StringVar(&flagWrite, "w", "", "write a repacked archive")
+	flag.BoolVar(&flagJSON, "json", false, "extract VTBF entries as editable JSON, use with -x (replacements ending in .json are encoded back to VTBF)")
+	flag.StringVar(&flagGLTF, "gltf", "", "export the archive's models and motions to glTF (additional models are written alongside, named after their entries)")
+	flag.Var(&flagReplace, "r", `replace an entry while repacking, use with -w (comma-separated, entry format is "filename:path". an empty path deletes the entry, and unknown filenames are added)`)
+	flag.Var(&flagTextures, "t", `rename textures referenced by models while repacking, use with -w (comma-separated, entry format is "old.dds:new.dds")`)
 	flag.Parse()
 
 	if flag.NArg() != 1 {
@@ -56,6 +111,12 @@ func main() {
 
 			fmt.Printf("\t%s (%s):\t0x%08x\n", file.Name, file.Type, file.Size);
 
+			if info, err := format.Sniff(file.Data); err != nil {
+				fmt.Fprintln(os.Stderr, file.Name, err)
+			} else if info != nil {
+				fmt.Printf("\t\t%s\n", info)
+			}
+
 			if file.Type == "aqo" {
 				m, err := afp.NewModel(file.Data)
 				ragequit(file.Name, err)
@@ -63,6 +124,26 @@ func main() {
 				for _, entry := range m.Entries {
 					fmt.Printf("\t\t%s (%s):\t0x%08x\n", entry.Type, entry.SubType, entry.Size);
 				}
+
+				fmt.Printf("\t\t%d vertex sets, %d meshes, %d materials, %d textures, %d nodes\n", len(m.VertexSets), len(m.Meshes), len(m.Materials), len(m.Textures), len(m.Nodes))
+			}
+
+			if file.Type == "aqn" {
+				s, err := afp.NewSkeleton(file.Data)
+				ragequit(file.Name, err)
+
+				fmt.Printf("\t\t%d nodes, %d attachment nodes\n", len(s.Nodes), len(s.NodeOs))
+			}
+
+			if file.Type == "aqm" {
+				m, err := afp.NewMotion(file.Data)
+				ragequit(file.Name, err)
+
+				for _, segment := range m.Segments {
+					fmt.Printf("\t\t%s (node %d):\t%d tracks\n", segment.Name, segment.NodeID, len(segment.Tracks))
+				}
+
+				fmt.Printf("\t\tframes %d-%d at %g fps, %d segments\n", m.StartFrame, m.EndFrame, m.FrameSpeed, len(m.Segments))
 			}
 		}
 	}
@@ -74,19 +155,192 @@ func main() {
 			file := a.Entry(i)
 			fmt.Println("Extracting", file.Name, "...")
 
-			f, err := os.Create(path.Join(flagExtract, file.Name));
+			var v *afp.VTBF
+			name := file.Name
+			if flagJSON && isVTBF(file.Data) {
+				v, err = afp.NewVTBF(file.Data)
+				ragequit(file.Name, err)
+				name += ".json"
+			}
+
+			f, err := os.Create(path.Join(flagExtract, name));
+			ragequit(name, err)
+
+			if v != nil {
+				encoder := json.NewEncoder(f)
+				encoder.SetIndent("", "\t")
+				err = encoder.Encode(v)
+			} else {
+				_, err = io.Copy(f, file.Data)
+			}
+			f.Close()
+			ragequit(name, err)
+		}
+	}
+
+	if flagGLTF != "" {
+		// Motions and skeletons in the archive apply to all of its models
+		var animations []afp.Animation
+		var skeleton *afp.Skeleton
+		for i := 0; i < a.EntryCount(); i++ {
+			file := a.Entry(i)
+
+			switch file.Type {
+				case "aqm":
+					m, err := afp.NewMotion(file.Data)
+					ragequit(file.Name, err)
+					animations = append(animations, afp.Animation{ Name: strings.TrimSuffix(file.Name, path.Ext(file.Name)), Motion: m })
+				case "aqn":
+					if skeleton == nil {
+						skeleton, err = afp.NewSkeleton(file.Data)
+						ragequit(file.Name, err)
+					}
+			}
+		}
+
+		exported := 0
+		for i := 0; i < a.EntryCount(); i++ {
+			file := a.Entry(i)
+			if file.Type != "aqo" && file.Type != "aqp" {
+				continue
+			}
+
+			m, err := afp.NewModel(file.Data)
 			ragequit(file.Name, err)
 
-			io.Copy(f, file.Data)
+			if len(m.Nodes) == 0 && skeleton != nil {
+				m.Nodes, m.NodeOs = skeleton.Nodes, skeleton.NodeOs
+			}
+
+			gpath := flagGLTF
+			if exported > 0 {
+				gpath = strings.TrimSuffix(flagGLTF, path.Ext(flagGLTF)) + "." + file.Name + ".gltf"
+			}
+
+			fmt.Fprintf(os.Stderr, "Exporting %s to `%s`...\n", file.Name, gpath)
+			f, err := os.Create(gpath)
+			ragequit(gpath, err)
+
+			writer := bufio.NewWriter(f)
+			err = m.WriteGLTF(writer, animations...)
+			if err == nil {
+				err = writer.Flush()
+			}
 			f.Close()
+			ragequit(gpath, err)
+
+			exported++
+		}
+
+		if exported == 0 {
+			ragequit(apath, errors.New("no models found"))
 		}
 	}
 
 	if flagWrite != "" {
-		ofile, err := os.Create(flagWrite)
+		if len(flagTextures) > 0 {
+			for i := 0; i < a.EntryCount(); i++ {
+				file := a.Entry(i)
+				if file.Type != "aqo" && file.Type != "aqp" {
+					continue
+				}
+
+				m, err := afp.NewModel(file.Data)
+				ragequit(file.Name, err)
+
+				renamed := false
+				for t, name := range m.Textures {
+					if newname, ok := flagTextures[name]; ok {
+						m.Textures[t] = newname
+						renamed = true
+					}
+				}
+
+				for t := range m.TextureStates {
+					if newname, ok := flagTextures[m.TextureStates[t].Name]; ok {
+						m.TextureStates[t].Name = newname
+						renamed = true
+					}
+				}
+
+				if renamed {
+					var buffer bytes.Buffer
+					ragequit(file.Name, m.Write(&buffer))
+					a.ReplaceEntry(file, bytes.NewReader(buffer.Bytes()), uint32(buffer.Len()))
+				}
+			}
+		}
+
+		for name, newpath := range flagReplace {
+			var entry *afp.Entry
+			for i := 0; i < a.EntryCount(); i++ {
+				if e := a.Entry(i); e.Name == name {
+					entry = &e
+					break
+				}
+			}
+
+			if newpath == "" {
+				if entry != nil {
+					a.RemoveEntry(*entry)
+				}
+				continue
+			}
+
+			newfile, err := os.Open(newpath)
+			ragequit(newpath, err)
+
+			st, err := newfile.Stat()
+			ragequit(newpath, err)
+
+			var data io.ReadSeeker = newfile
+			size := st.Size()
+
+			if path.Ext(newpath) == ".json" && path.Ext(name) != ".json" {
+				var v afp.VTBF
+				ragequit(newpath, json.NewDecoder(newfile).Decode(&v))
+
+				var buffer bytes.Buffer
+				ragequit(newpath, v.Write(&buffer))
+				data, size = bytes.NewReader(buffer.Bytes()), int64(buffer.Len())
+			}
+
+			if size > int64(^uint32(0)) {
+				ragequit(newpath, errors.New("file too large"))
+			}
+
+			if entry != nil {
+				a.ReplaceEntry(*entry, data, uint32(size))
+			} else {
+				a.AddEntry(name, strings.TrimPrefix(path.Ext(name), "."), data, uint32(size))
+			}
+		}
+
+		// Entries are still read from the input while writing, so it can only be replaced once the write is done
+		ofile, err := ioutil.TempFile(path.Dir(flagWrite), path.Base(flagWrite))
 		ragequit(flagWrite, err)
 
+		if st, err := os.Stat(flagWrite); err == nil {
+			ofile.Chmod(st.Mode())
+		} else {
+			ofile.Chmod(0644)
+		}
+
 		fmt.Fprintf(os.Stderr, "Writing to archive `%s`...\n", flagWrite)
-		a.Write(ofile)
+		writer := bufio.NewWriter(ofile)
+		err = a.Write(writer)
+		if err == nil {
+			err = writer.Flush()
+		}
+		if cerr := ofile.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(ofile.Name(), flagWrite)
+		}
+		if err != nil {
+			os.Remove(ofile.Name())
+		}
+		ragequit(flagWrite, err)
 	}
 }
@@ -12,6 +12,7 @@ import (
 	"aaronlindsay.com/go/pkg/pso2/trans"
 	transcmd "aaronlindsay.com/go/pkg/pso2/trans/cmd"
 	"aaronlindsay.com/go/pkg/pso2/download"
+	"aaronlindsay.com/go/pkg/pso2/names"
 	"aaronlindsay.com/go/pkg/pso2/download/cmd"
 )
 
@@ -49,7 +50,7 @@ func ragequit(apath string, err error) {
 
 func main() {
 	var flagPrint, flagAll, flagCheck, flagHash, flagDownload, flagUpdate, flagGarbage, flagLaunch, flagItemTranslation, flagBackup bool
-	var flagTranslate, flagPublicKey, flagDumpPublicKey string
+	var flagTranslate, flagPublicKey, flagDumpPublicKey, flagFiles string
 	var flagParallel int
 
 	flag.Usage = usage
@@ -66,6 +67,7 @@ func main() {
 	flag.BoolVar(&flagLaunch, "l", false, "launch the game")
 	flag.StringVar(&flagTranslate, "t", "", "use the translation with the specified comma-separated names (example: eng,story-eng)")
 	flag.StringVar(&flagPublicKey, "pubkey", "", "inject a public key (path relative to pso2_bin)")
+	flag.StringVar(&flagFiles, "f", "", "only update the specified comma-separated files, by hash or logical name")
 	flag.StringVar(&flagDumpPublicKey, "dumppubkey", "", "dump the PSO2 public key (path relative to pso2_bin)")
 	flag.Parse()
 
@@ -97,6 +99,9 @@ func main() {
 		flagTranslations = nil
 	}
 
+	dict, err := cmd.LoadNames(pso2path)
+	complain(cmd.PathNames, err)
+
 	fmt.Fprintln(os.Stderr, "Checking for updates...")
 	netVersion, err := cmd.DownloadProductionVersion()
 	complain(download.ProductionVersion, err)
@@ -135,6 +140,18 @@ func main() {
 
 	patchdiff := patchlist.Diff(installedPatchlist)
 
+	if flagFiles != "" {
+		files := make(map[names.Hash]bool)
+		for _, name := range strings.Split(flagFiles, ",") {
+			files[names.Resolve(name)] = true
+		}
+
+		patchdiff = patchdiff.Filter(func(e *download.PatchEntry) bool {
+			h, err := names.ParseHash(path.Base(download.RemoveExtension(e.Path)))
+			return err == nil && files[h]
+		})
+	}
+
 	if installedPatchlist == nil {
 		flagCheck = true
 	}
@@ -143,7 +160,7 @@ func main() {
 
 	if flagPrint {
 		for _, e := range patchdiff.Entries {
-			fmt.Fprintf(os.Stderr, "\t%s (0x%08x): %x\n", download.RemoveExtension(e.Path), e.Size, e.MD5)
+			fmt.Fprintf(os.Stderr, "\t%s (0x%08x): %x\n", cmd.DisplayPath(dict, e.Path), e.Size, e.MD5)
 		}
 	}
 
@@ -153,7 +170,7 @@ func main() {
 		changes, err = cmd.CheckFiles(pso2path, flagHash, patchdiff)
 		ragequit("", err)
 
-		if len(changes) == 0 {
+		if len(changes) == 0 && flagFiles == "" {
 			cmd.CommitInstalled(pso2path, patchlist)
 		}
 	} else {
@@ -171,7 +188,7 @@ func main() {
 
 	if flagPrint {
 		for _, e := range changes {
-			fmt.Fprintf(os.Stderr, "\t%s (0x%08x): %x\n", download.RemoveExtension(e.Path), e.Size, e.MD5)
+			fmt.Fprintf(os.Stderr, "\t%s (0x%08x): %x\n", cmd.DisplayPath(dict, e.Path), e.Size, e.MD5)
 		}
 	}
 
@@ -185,7 +202,9 @@ func main() {
 			fmt.Fprintln(os.Stderr, "Update unsuccessful, errors encountered")
 		} else {
 			fmt.Fprintln(os.Stderr, "Update complete!")
-			cmd.CommitInstalled(pso2path, patchlist)
+			if flagFiles == "" {
+				cmd.CommitInstalled(pso2path, patchlist)
+			}
 			needsTranslation = true
 		}
 	}
@@ -214,7 +233,7 @@ func main() {
 			var errs []error
 			for _, translation := range flagTranslations {
 				win32 := path.Join(pso2path, "data/win32")
-				errs = append(errs, transcmd.PatchFiles(db, win32, translation, backupPath, win32, runtime.NumCPU() + 1)...)
+				errs = append(errs, transcmd.PatchFiles(db, win32, translation, backupPath, win32, runtime.NumCPU() + 1, transcmd.StaleWarn)...)
 			}
 
 			for _, err := range errs {
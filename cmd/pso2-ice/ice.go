@@ -9,12 +9,21 @@ import (
 	"strings"
 	"errors"
 	"path"
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"encoding/json"
 	"aaronlindsay.com/go/pkg/pso2/ice"
+	"aaronlindsay.com/go/pkg/pso2/texture"
+	"aaronlindsay.com/go/pkg/pso2/format"
+	"aaronlindsay.com/go/pkg/pso2/names"
 	"aaronlindsay.com/go/pkg/pso2/util"
 )
 
 func usage() {
 	fmt.Fprintln(os.Stderr, "usage: pso2-ice [flags] archive.ice")
+	fmt.Fprintln(os.Stderr, "       pso2-ice [flags] -c folder archive.ice")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -63,17 +72,252 @@ func (f *flagReplaceType) Set(value string) error {
 	return nil
 }
 
+const manifestName = "manifest.json"
+
+// Textures converted by -png keep their original name, plus this suffix
+const pngSuffix = ".png"
+
+func isTexture(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".dds")
+}
+
+// archiveManifest is the manifest written by -x, along with how any textures were converted by -png
+type archiveManifest struct {
+	*ice.Manifest
+
+	// The original headers of textures extracted as PNG, by their path within the folder (eg. "1/file.dds")
+	Textures map[string]*texture.Header `json:",omitempty"`
+}
+
+func textureHeader(data io.ReadSeeker) (*texture.Header, error) {
+	if _, err := data.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	h, err := texture.ReadHeader(data)
+	data.Seek(0, 0)
+	return h, err
+}
+
+// extractPNG converts a DDS texture to PNG, returning the texture's header so that it can be converted back
+func extractPNG(data io.ReadSeeker, ppath string) (*texture.Header, error) {
+	h, err := textureHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := texture.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(ppath)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(f)
+	err = png.Encode(writer, img)
+	if err == nil {
+		err = writer.Flush()
+	}
+	f.Close()
+
+	return h, err
+}
+
+// encodePNG converts a PNG to DDS, matching the format, pixel layout and mipmaps of the texture it replaces if there
+// is one. New textures are stored uncompressed.
+func encodePNG(ppath string, original *texture.Header) (*bytes.Reader, error) {
+	options := &texture.Options{ Format: texture.FormatRGB }
+	if original != nil {
+		options.Format, options.MipMaps, options.MipMapCount = original.Format, original.MipMapCount > 1, original.MipMapCount
+		options.BitCount = original.BitCount
+		options.RMask, options.GMask, options.BMask, options.AMask = original.RMask, original.GMask, original.BMask, original.AMask
+	}
+
+	f, err := os.Open(ppath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	if err = texture.Encode(&buffer, img, options); err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(buffer.Bytes()), nil
+}
+
+func storedGroupName(i int) string {
+	return fmt.Sprintf("%d.prs", i)
+}
+
+func extractManifest(a *ice.Archive, dir string, textures map[string]*texture.Header) error {
+	m, err := a.Manifest()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < a.GroupCount(); i++ {
+		group := a.Group(i)
+		if !group.Compressed {
+			continue
+		}
+
+		f, err := os.Create(path.Join(dir, storedGroupName(i)))
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(f, group.Stored())
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(archiveManifest{ m, textures }, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(dir, manifestName), data, 0666)
+}
+
+func createArchive(dir string) (a *ice.Archive, files []*os.File, err error) {
+	var manifest *ice.Manifest
+	var textures map[string]*texture.Header
+
+	data, err := ioutil.ReadFile(path.Join(dir, manifestName))
+	if err == nil {
+		m := archiveManifest{ Manifest: &ice.Manifest{} }
+		if err = json.Unmarshal(data, &m); err != nil {
+			return
+		}
+		manifest, textures = m.Manifest, m.Textures
+
+		stored := make([]io.ReadSeeker, len(manifest.Groups))
+		for i := range stored {
+			if f, err := os.Open(path.Join(dir, storedGroupName(i))); err == nil {
+				files = append(files, f)
+				stored[i] = f
+			}
+		}
+
+		if a, err = ice.CreateArchiveManifest(manifest, stored); err != nil {
+			return
+		}
+	} else if os.IsNotExist(err) {
+		a = ice.CreateArchive()
+		err = nil
+	} else {
+		return
+	}
+
+	for i := 0; i < a.GroupCount(); i++ {
+		gpath := path.Join(dir, fmt.Sprintf("%d", i))
+
+		// Files listed in the manifest keep their original order, anything new goes on the end
+		var names []string
+		listed := make(map[string]bool)
+		if manifest != nil {
+			for _, file := range manifest.Groups[i].Files {
+				names = append(names, file.Name)
+				listed[file.Name] = true
+			}
+		}
+
+		var infos []os.FileInfo
+		infos, err = ioutil.ReadDir(gpath)
+		if os.IsNotExist(err) {
+			err = nil
+		} else if err != nil {
+			return
+		}
+
+		for _, info := range infos {
+			name := info.Name()
+			if dds := strings.TrimSuffix(name, pngSuffix); dds != name && isTexture(dds) {
+				name = dds
+			}
+
+			if !info.IsDir() && !listed[name] {
+				names = append(names, name)
+				listed[name] = true
+			}
+		}
+
+		for _, name := range names {
+			fileType := strings.TrimPrefix(path.Ext(name), ".")
+
+			var f *os.File
+			f, err = os.Open(path.Join(gpath, name))
+			if os.IsNotExist(err) && isTexture(name) {
+				// Converted by -png
+				var data *bytes.Reader
+				data, err = encodePNG(path.Join(gpath, name + pngSuffix), textures[path.Join(fmt.Sprintf("%d", i), name)])
+				if os.IsNotExist(err) {
+					err = nil
+					continue
+				} else if err != nil {
+					return a, files, fmt.Errorf("%s: %v", name + pngSuffix, err)
+				}
+
+				a.AddFile(i, name, fileType, data, uint32(data.Len()))
+				continue
+			} else if os.IsNotExist(err) {
+				err = nil
+				continue
+			} else if err != nil {
+				return
+			}
+			files = append(files, f)
+
+			var st os.FileInfo
+			if st, err = f.Stat(); err != nil {
+				return
+			}
+
+			if st.Size() > int64(^uint32(0)) {
+				return a, files, errors.New(name + ": file too large")
+			}
+
+			a.AddFile(i, name, fileType, f, uint32(st.Size()))
+		}
+	}
+
+	return
+}
+
 func main() {
 	var flagPrint bool
+	var flagCreate string
 	var flagExtract string
 	var flagWrite string
 	var flagReplace flagReplaceType
+	var flagLevel int
+	var flagEncrypt, flagDecrypt bool
+	var flagNames string
+	var flagPNG bool
 
 	flag.Usage = usage
 	flag.BoolVar(&flagPrint, "p", false, "print details about the archive")
 	flag.StringVar(&flagExtract, "x", "", "extract the archive to a folder")
+	flag.StringVar(&flagCreate, "c", "", "create a new archive from a folder laid out like -x output (including its manifest, if present)")
 	flag.StringVar(&flagWrite, "w", "", "write a repacked archive")
-	flag.Var(&flagReplace, "r", `replace a file while repacking, use with -w (comma-separated, entry format is "filename:path". an empty path deletes the file from the archive)`)
+	flag.IntVar(&flagLevel, "z", ice.CompressionDefault, "compression level used when repacking (0 = default, 1 = fast, 2 = best)")
+	flag.BoolVar(&flagEncrypt, "e", false, "encrypt the repacked archive")
+	flag.BoolVar(&flagDecrypt, "d", false, "decrypt the repacked archive")
+	flag.BoolVar(&flagPNG, "png", false, "convert DDS textures to PNG when extracting, use with -x (files are named file.dds.png, and are converted back by -c)")
+	flag.StringVar(&flagNames, "n", "", "dictionary of data/win32 names, allowing the archive to be specified by its logical name")
+	flag.Var(&flagReplace, "r", `replace a file while repacking, use with -w (comma-separated, entry format is "filename:path". an empty path deletes the file from the archive, and a PNG replacing a DDS texture is converted to the texture's format)`)
 	flag.Parse()
 
 	if flag.NArg() != 1 {
@@ -83,7 +327,60 @@ func main() {
 	}
 
 	apath := flag.Arg(0)
-	fmt.Fprintf(os.Stderr, "Opening archive `%s`...\n", apath)
+
+	if flagCreate != "" {
+		fmt.Fprintf(os.Stderr, "Creating archive `%s` from `%s`...\n", apath, flagCreate)
+		a, files, err := createArchive(flagCreate)
+		ragequit(flagCreate, err)
+
+		a.CompressionLevel = flagLevel
+		if flagEncrypt {
+			a.Encrypted = true
+		} else if flagDecrypt {
+			a.Encrypted = false
+		}
+
+		ofile, err := os.Create(apath)
+		ragequit(apath, err)
+
+		writer := bufio.NewWriter(ofile)
+		err = a.Write(writer)
+		if err == nil {
+			err = writer.Flush()
+		}
+		ofile.Close()
+		ragequit(apath, err)
+
+		for _, f := range files {
+			f.Close()
+		}
+
+		return
+	}
+
+	var dict *names.Dictionary
+	if flagNames != "" {
+		var err error
+		dict, err = names.LoadDictionaryFile(flagNames)
+		if os.IsNotExist(err) {
+			dict = names.NewDictionary()
+		} else {
+			ragequit(flagNames, err)
+		}
+	}
+
+	if _, err := os.Stat(apath); os.IsNotExist(err) && dict != nil {
+		// Look the archive up by its logical name and remember it for next time
+		if hpath := names.ResolvePath(apath); hpath != apath {
+			if _, err := os.Stat(hpath); err == nil {
+				dict.Add(path.Base(apath))
+				ragequit(flagNames, dict.WriteFile(flagNames))
+				apath = hpath
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Opening archive `%s` (%s)...\n", apath, dict.DisplayString(path.Base(apath)))
 	f, err := os.OpenFile(apath, os.O_RDONLY, 0);
 	ragequit(apath, err)
 
@@ -91,17 +388,28 @@ func main() {
 	ragequit(apath, err)
 
 	if flagPrint {
+		if a.Encrypted {
+			fmt.Println("Encrypted archive")
+		}
+
 		for i := 0; i < a.GroupCount(); i++ {
 			group := a.Group(i)
 
 			fmt.Printf("Archive Group %d (0x%04x files)\n", i, len(group.Files))
 			for _, file := range group.Files {
 				fmt.Printf("\t%s (%s):\t0x%08x\n", file.Name, file.Type, file.Size);
+
+				if info, err := format.Sniff(file.Data); err != nil {
+					fmt.Fprintln(os.Stderr, file.Name, err)
+				} else if info != nil {
+					fmt.Printf("\t\t%s\n", info)
+				}
 			}
 		}
 	}
 
 	if flagExtract != "" {
+		textures := make(map[string]*texture.Header)
 		for i := 0; i < a.GroupCount(); i++ {
 			extPath := path.Join(flagExtract, fmt.Sprintf("%d", i))
 			os.MkdirAll(extPath, 0777);
@@ -111,6 +419,19 @@ func main() {
 			for _, file := range group.Files {
 				fmt.Println("Extracting", file.Name, "...")
 
+				if flagPNG && isTexture(file.Name) {
+					h, err := extractPNG(file.Data, path.Join(extPath, file.Name + pngSuffix))
+					if err == nil {
+						textures[path.Join(fmt.Sprintf("%d", i), file.Name)] = h
+						continue
+					}
+
+					// Unsupported textures are left as they are
+					fmt.Fprintf(os.Stderr, "%s: %v, extracting as DDS\n", file.Name, err)
+					os.Remove(path.Join(extPath, file.Name + pngSuffix))
+					file.Data.Seek(0, 0)
+				}
+
 				f, err := os.Create(path.Join(extPath, file.Name));
 				ragequit(file.Name, err)
 
@@ -118,6 +439,9 @@ func main() {
 				f.Close()
 			}
 		}
+
+		err = extractManifest(a, flagExtract, textures)
+		ragequit(flagExtract, err)
 	}
 
 	if flagWrite != "" {
@@ -131,6 +455,14 @@ func main() {
 				if newpath, ok := flagReplace[file.Name]; ok {
 					if newpath == "" {
 						a.ReplaceFile(&file, nil, 0)
+					} else if isTexture(file.Name) && strings.EqualFold(path.Ext(newpath), pngSuffix) {
+						h, err := textureHeader(file.Data)
+						ragequit(file.Name, err)
+
+						data, err := encodePNG(newpath, h)
+						ragequit(newpath, err)
+
+						a.ReplaceFile(&file, data, uint32(data.Len()))
 					} else {
 						newfile, err := os.Open(newpath)
 						ragequit(newpath, err)
@@ -149,9 +481,18 @@ func main() {
 		}
 
 		fmt.Fprintf(os.Stderr, "Writing to archive `%s`...\n", flagWrite)
+		a.CompressionLevel = flagLevel
+		if flagEncrypt {
+			a.Encrypted = true
+		} else if flagDecrypt {
+			a.Encrypted = false
+		}
 		writer := bufio.NewWriter(ofile)
-		a.Write(writer)
-		writer.Flush()
+		err = a.Write(writer)
+		if err == nil {
+			err = writer.Flush()
+		}
 		ofile.Close()
+		ragequit(flagWrite, err)
 	}
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"flag"
+	"path"
+	"runtime"
+	"aaronlindsay.com/go/pkg/pso2/index"
+	"aaronlindsay.com/go/pkg/pso2/names"
+	"aaronlindsay.com/go/pkg/pso2/download/cmd"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: pso2-index [flags] catalog.db update pso2_bin")
+	fmt.Fprintln(os.Stderr, "       pso2-index [flags] catalog.db find pattern")
+	fmt.Fprintln(os.Stderr, "       pso2-index [flags] catalog.db type filetype")
+	fmt.Fprintln(os.Stderr, "       pso2-index [flags] catalog.db archive name")
+	fmt.Fprintln(os.Stderr, "       pso2-index [flags] catalog.db hash md5")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func ragequit(apath string, err error) {
+	if err != nil {
+		if apath != "" {
+			fmt.Fprintf(os.Stderr, "error with file `%s`\n", apath)
+		}
+		fmt.Fprintln(os.Stderr, err);
+		os.Exit(1)
+	}
+}
+
+func main() {
+	var flagNames string
+	var flagParallel int
+
+	flag.Usage = usage
+	flag.IntVar(&flagParallel, "p", runtime.NumCPU() + 1, "max parallel tasks")
+	flag.StringVar(&flagNames, "n", "", "dictionary of data/win32 names, used to display and look up archives by their logical names")
+	flag.Parse()
+
+	if flag.NArg() != 3 {
+		flag.Usage()
+	}
+
+	var dict *names.Dictionary
+	if flagNames != "" {
+		var err error
+		dict, err = names.LoadDictionaryFile(flagNames)
+		ragequit(flagNames, err)
+	}
+
+	dbpath := flag.Arg(0)
+	fmt.Fprintf(os.Stderr, "Opening catalog `%s`...\n", dbpath)
+	db, err := index.NewDatabase(dbpath)
+	ragequit(dbpath, err)
+	defer db.Close()
+
+	var files []index.File
+
+	arg := flag.Arg(2)
+	switch flag.Arg(1) {
+		case "update":
+			patchlist, _ := cmd.LoadPatchlistFile(path.Join(cmd.PathScratch(arg), cmd.PathPatchlistInstalled), "")
+			if patchlist == nil {
+				fmt.Fprintln(os.Stderr, "No installed patchlist found, checking archives by size and modification time only...")
+			}
+
+			win32 := path.Join(arg, "data/win32")
+			fmt.Fprintf(os.Stderr, "Scanning `%s`...\n", win32)
+			updated, errs := index.Update(db, win32, patchlist, flagParallel)
+			for _, err := range errs {
+				fmt.Fprintln(os.Stderr, err)
+			}
+
+			fmt.Fprintln(os.Stderr, updated, "archive(s) updated")
+			if len(errs) > 0 {
+				os.Exit(1)
+			}
+			return
+		case "find":
+			files, err = db.QueryFilesName(arg)
+		case "type":
+			files, err = db.QueryFilesType(arg)
+		case "archive":
+			files, err = db.QueryFilesArchive(names.Resolve(arg))
+		case "hash":
+			var h names.Hash
+			h, err = names.ParseHash(arg)
+			if err == nil {
+				files, err = db.QueryFilesMD5(h)
+			}
+		default:
+			flag.Usage()
+	}
+	ragequit("", err)
+
+	for _, f := range files {
+		fmt.Printf("%s\t%d\t%s\t%s\t0x%08x\t%x\n", dict.Display(f.Archive), f.Group, f.Name, f.Type, f.Size, f.MD5)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"path"
+	"sort"
+	"sync"
+	"errors"
+	"strconv"
+	"runtime"
+	"encoding/csv"
+	"aaronlindsay.com/go/pkg/pso2/text"
+	"aaronlindsay.com/go/pkg/pso2/names"
+)
+
+// A diffJob compares one archive between versions. A missing side has an empty path.
+type diffJob struct {
+	name string
+	old, new string
+}
+
+type fileChanges struct {
+	archive, file string
+	changes []text.Change
+}
+
+type diffResult struct {
+	index int
+	files []fileChanges
+	errs []error
+}
+
+// The columns of the -o file, which pso2-trans can import
+var diffHeader = []string{ "archive", "file", "identifier", "collision", "change", "old", "new" }
+
+// archiveRecords reads the records of every text file in an archive. Files that can't be parsed are marked in
+// failed and reported in fileErrs, while err is for the archive as a whole.
+func archiveRecords(filename string, failed map[string]bool) (files map[string][]text.Record, order []string, fileErrs []error, err error) {
+	files = make(map[string][]text.Record)
+	if filename == "" {
+		return
+	}
+
+	err = eachTextFile(filename, func(name string, t *text.TextFile, err error) {
+		if err != nil {
+			failed[name] = true
+			fileErrs = append(fileErrs, &os.PathError{Op: "diff", Path: filename, Err: fmt.Errorf("%s: %v", name, err)})
+			return
+		}
+
+		if _, ok := files[name]; !ok {
+			order = append(order, name)
+		}
+		files[name] = t.Records()
+	})
+
+	if err != nil {
+		err = &os.PathError{Op: "diff", Path: filename, Err: err}
+	}
+	return
+}
+
+// diffArchive compares the text files of an archive. Files that can't be parsed on either side are left out of
+// the changes, rather than showing up as removed or added.
+func diffArchive(job diffJob) (files []fileChanges, errs []error) {
+	failed := make(map[string]bool)
+
+	oldFiles, oldOrder, oldErrs, err := archiveRecords(job.old, failed)
+	if errs = oldErrs; err != nil {
+		return nil, append(errs, err)
+	}
+
+	newFiles, newOrder, newErrs, err := archiveRecords(job.new, failed)
+	if errs = append(errs, newErrs...); err != nil {
+		return nil, append(errs, err)
+	}
+
+	for _, name := range oldOrder {
+		if failed[name] {
+			continue
+		}
+
+		if changes := text.Diff(oldFiles[name], newFiles[name]); len(changes) > 0 {
+			files = append(files, fileChanges{ job.name, name, changes })
+		}
+	}
+
+	for _, name := range newOrder {
+		if _, ok := oldFiles[name]; !ok && !failed[name] {
+			files = append(files, fileChanges{ job.name, name, text.Diff(nil, newFiles[name]) })
+		}
+	}
+
+	return
+}
+
+// diffJobs pairs up the archives of two data/win32 folders by name, or two archive files. The archive column of the
+// csv file has to be a data/win32 hash for pso2-trans to find the archive, so single archives have to be named by
+// their hash on at least one side.
+func diffJobs(old, new string) (jobs []diffJob) {
+	oldInfo, err := os.Stat(old)
+	ragequit(old, err)
+	newInfo, err := os.Stat(new)
+	ragequit(new, err)
+
+	if oldInfo.IsDir() != newInfo.IsDir() {
+		ragequit("", errors.New("diff needs two folders or two archives"))
+	}
+
+	if !newInfo.IsDir() {
+		name := path.Base(new)
+		if _, err := names.ParseHash(name); err != nil {
+			name = path.Base(old)
+			if _, err = names.ParseHash(name); err != nil {
+				ragequit("", fmt.Errorf("`%s` and `%s` aren't named by a data/win32 hash", old, new))
+			}
+		}
+
+		return []diffJob{ { name, old, new } }
+	}
+
+	oldNames, err := archiveNames(old)
+	ragequit(old, err)
+	newNames, err := archiveNames(new)
+	ragequit(new, err)
+
+	byName := make(map[string]*diffJob)
+	for _, name := range oldNames {
+		byName[name] = &diffJob{ name: name, old: path.Join(old, name) }
+	}
+
+	for _, name := range newNames {
+		if job := byName[name]; job != nil {
+			job.new = path.Join(new, name)
+		} else {
+			byName[name] = &diffJob{ name: name, new: path.Join(new, name) }
+		}
+	}
+
+	for _, job := range byName {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].name < jobs[j].name })
+
+	return
+}
+
+func diff(args []string) {
+	var flagOutput string
+	var flagParallel int
+
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: text.go diff [flags] old/data/win32 new/data/win32")
+		fmt.Fprintln(os.Stderr, "       text.go diff [flags] old-archive new-archive")
+		fmt.Fprintln(os.Stderr, "archives are named by their data/win32 hash, which -o writes for pso2-trans")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.StringVar(&flagOutput, "o", "", "also write the changes as a csv file, for pso2-trans to import")
+	flags.IntVar(&flagParallel, "j", runtime.NumCPU(), "number of archives to compare in parallel")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+	}
+
+	jobs := diffJobs(flags.Arg(0), flags.Arg(1))
+
+	if flagParallel <= 0 {
+		flagParallel = 1
+	}
+
+	queue := make(chan int)
+	results := make(chan diffResult)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < flagParallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range queue {
+				files, errs := diffArchive(jobs[index])
+				results <- diffResult{ index, files, errs }
+			}
+		}()
+	}
+
+	go func() {
+		for i := range jobs {
+			queue <- i
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results are kept in archive order, so the report is the same from run to run
+	files := make([][]fileChanges, len(jobs))
+	failed := false
+	for r := range results {
+		files[r.index] = r.files
+
+		for _, err := range r.errs {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+
+	var w *csv.Writer
+	if flagOutput != "" {
+		ofile, err := os.Create(flagOutput)
+		ragequit(flagOutput, err)
+		defer ofile.Close()
+
+		w = csv.NewWriter(ofile)
+		w.Write(diffHeader)
+	}
+
+	counts := make(map[text.ChangeKind]int)
+	for _, archive := range files {
+		for _, f := range archive {
+			for _, c := range f.changes {
+				counts[c.Kind]++
+
+				switch c.Kind {
+					case text.ChangeAdded:
+						fmt.Printf("+ %s/%s %s[%d]: %q\n", f.archive, f.file, c.Identifier, c.Collision, c.New)
+					case text.ChangeChanged:
+						fmt.Printf("~ %s/%s %s[%d]: %q -> %q\n", f.archive, f.file, c.Identifier, c.Collision, c.Old, c.New)
+					case text.ChangeRemoved:
+						fmt.Printf("- %s/%s %s[%d]: %q\n", f.archive, f.file, c.Identifier, c.Collision, c.Old)
+				}
+
+				if w != nil {
+					w.Write([]string{ f.archive, f.file, c.Identifier, strconv.Itoa(c.Collision), c.Kind.String(), c.Old, c.New })
+				}
+			}
+		}
+	}
+
+	if w != nil {
+		w.Flush()
+		ragequit(flagOutput, w.Error())
+	}
+
+	fmt.Fprintf(os.Stderr, "%d added, %d changed, %d removed\n", counts[text.ChangeAdded], counts[text.ChangeChanged], counts[text.ChangeRemoved])
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"path"
+	"sync"
+	"regexp"
+	"runtime"
+	"io/ioutil"
+	"aaronlindsay.com/go/pkg/pso2/ice"
+	"aaronlindsay.com/go/pkg/pso2/text"
+	"aaronlindsay.com/go/pkg/pso2/util"
+	"aaronlindsay.com/go/pkg/pso2/names"
+)
+
+type grepMatch struct {
+	archive, file string
+	pair text.TextPair
+}
+
+type grepResult struct {
+	matches []grepMatch
+	errs []error
+}
+
+// eachTextFile parses every text file in an ICE archive. A file that can't be parsed is passed to fn along with
+// its error, and the rest of the archive is still read.
+func eachTextFile(filename string, fn func(name string, t *text.TextFile, err error)) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	archive, err := ice.NewArchive(util.BufReader(f))
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < archive.GroupCount(); i++ {
+		for _, file := range archive.Group(i).Files {
+			if file.Type != "text" {
+				continue
+			}
+
+			if _, err = file.Data.Seek(0, 0); err != nil {
+				fn(file.Name, nil, err)
+				continue
+			}
+
+			t, err := text.NewTextFile(file.Data)
+			fn(file.Name, t, err)
+		}
+	}
+
+	return nil
+}
+
+// grepArchive searches the pairs of every text file in an ICE archive, returning any errors along with the
+// matches from the files that could be read
+func grepArchive(filename string, re *regexp.Regexp, identifiers bool) (matches []grepMatch, errs []error) {
+	name := path.Base(filename)
+	err := eachTextFile(filename, func(file string, t *text.TextFile, err error) {
+		if err != nil {
+			errs = append(errs, &os.PathError{Op: "grep", Path: filename, Err: fmt.Errorf("%s: %v", file, err)})
+			return
+		}
+
+		for _, p := range t.Pairs {
+			value := p.String
+			if identifiers {
+				value = p.Identifier
+			}
+
+			if re.MatchString(value) {
+				matches = append(matches, grepMatch{ name, file, p })
+			}
+		}
+	})
+
+	if err != nil {
+		errs = append(errs, &os.PathError{Op: "grep", Path: filename, Err: err})
+	}
+
+	return
+}
+
+// archiveNames lists the archives in a data/win32 folder. They are named by their hash, so anything else is
+// skipped.
+func archiveNames(dir string) (archives []string, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, info := range infos {
+		if _, err := names.ParseHash(info.Name()); err == nil && !info.IsDir() {
+			archives = append(archives, info.Name())
+		}
+	}
+
+	return
+}
+
+func grepArchives(paths []string) (archives []string) {
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		ragequit(p, err)
+
+		if !info.IsDir() {
+			archives = append(archives, p)
+			continue
+		}
+
+		dir, err := archiveNames(p)
+		ragequit(p, err)
+
+		for _, name := range dir {
+			archives = append(archives, path.Join(p, name))
+		}
+	}
+
+	return
+}
+
+func grep(args []string) {
+	var flagIdentifier, flagFixed, flagIgnoreCase bool
+	var flagParallel int
+
+	flags := flag.NewFlagSet("grep", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: text.go grep [flags] pattern data/win32|archive...")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	flags.BoolVar(&flagIdentifier, "id", false, "match identifiers instead of strings")
+	flags.BoolVar(&flagFixed, "F", false, "match the pattern as a fixed string instead of a regular expression")
+	flags.BoolVar(&flagIgnoreCase, "i", false, "ignore case")
+	flags.IntVar(&flagParallel, "j", runtime.NumCPU(), "number of archives to search in parallel")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+	}
+
+	pattern := flags.Arg(0)
+	if flagFixed {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if flagIgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	ragequit("", err)
+
+	archives := grepArchives(flags.Args()[1:])
+
+	if flagParallel <= 0 {
+		flagParallel = 1
+	}
+
+	jobs := make(chan string)
+	results := make(chan grepResult)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < flagParallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for filename := range jobs {
+				matches, errs := grepArchive(filename, re, flagIdentifier)
+				results <- grepResult{ matches, errs }
+			}
+		}()
+	}
+
+	go func() {
+		for _, filename := range archives {
+			jobs <- filename
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Archives are searched in parallel, but only printed from here so lines don't interleave
+	failed := false
+	for r := range results {
+		for _, m := range r.matches {
+			fmt.Printf("%s\t%s\t%s\t%q\n", m.archive, m.file, m.pair.Identifier, m.pair.String)
+		}
+
+		for _, err := range r.errs {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"os"
 	"flag"
+	"errors"
 	"aaronlindsay.com/go/pkg/pso2/text"
 )
 
 func usage() {
 	fmt.Fprintln(os.Stderr, "usage: text.go [flags] file.text")
+	fmt.Fprintln(os.Stderr, "       text.go grep [flags] pattern data/win32|archive...")
+	fmt.Fprintln(os.Stderr, "       text.go diff [flags] old/data/win32|archive new/data/win32|archive")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -26,18 +29,36 @@ func ragequit(apath string, err error) {
 func main() {
 	var flagPrint bool
 	var flagWrite string
+	var flagExport, flagImport string
 
 	flag.Usage = usage
 	flag.BoolVar(&flagPrint, "p", false, "print details about the file")
 	flag.StringVar(&flagWrite, "w", "", "write a repacked file")
+	flag.StringVar(&flagExport, "export", "", "print the strings as csv, json or po")
+	flag.StringVar(&flagImport, "import", "", "apply strings from an exported .csv, .json or .po file, use with -w")
 	flag.Parse()
 
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+			case "grep":
+				grep(flag.Args()[1:])
+				return
+			case "diff":
+				diff(flag.Args()[1:])
+				return
+		}
+	}
+
 	if flag.NArg() != 1 {
 		fmt.Fprintln(os.Stderr, "no filename provided")
 		flag.Usage()
 		flag.PrintDefaults()
 	}
 
+	if flagImport != "" && flagWrite == "" {
+		ragequit("", errors.New("-import needs -w to write the result to"))
+	}
+
 	tpath := flag.Arg(0)
 	fmt.Fprintf(os.Stderr, "Opening file `%s`...\n", tpath)
 	f, err := os.OpenFile(tpath, os.O_RDONLY, 0);
@@ -47,17 +68,45 @@ func main() {
 	ragequit(tpath, err)
 
 	if flagPrint {
-		for i, entry := range t.Entries {
+		for _, entry := range t.Entries {
 			fmt.Printf("%08x: %s\n", entry.Value, entry.Text)
-
-			if entry.TextStatus == text.TextEntryString {
-				t.Entries[i].Text = "LOLOLOL"
-			}
 		}
 
 		for _, p := range t.Pairs {
 			fmt.Printf("%s: %s\n", p.Identifier, p.String)
 		}
+
+		table, err := text.NewTable(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unrecognized table layout: %v\n", err)
+		} else {
+			for _, c := range table.Categories {
+				fmt.Printf("category %s:", c.Name)
+				for _, g := range c.Groups {
+					fmt.Printf(" %d", len(g.Pairs))
+				}
+				fmt.Println(" pairs")
+			}
+		}
+	}
+
+	if flagExport != "" {
+		err = text.Export(os.Stdout, flagExport, t.Records())
+		ragequit("", err)
+	}
+
+	if flagImport != "" {
+		fmt.Fprintf(os.Stderr, "Importing `%s`...\n", flagImport)
+		ifile, err := os.Open(flagImport)
+		ragequit(flagImport, err)
+
+		records, err := text.Import(ifile, text.FormatFromPath(flagImport))
+		ifile.Close()
+		ragequit(flagImport, err)
+
+		changed, err := t.Apply(records)
+		ragequit(flagImport, err)
+		fmt.Fprintf(os.Stderr, "%d of %d strings changed\n", changed, len(records))
 	}
 
 	if flagWrite != "" {
@@ -65,6 +114,8 @@ func main() {
 		ragequit(flagWrite, err)
 
 		fmt.Fprintf(os.Stderr, "Writing to `%s`...\n", flagWrite)
-		t.Write(ofile)
+		err = t.Write(ofile)
+		ofile.Close()
+		ragequit(flagWrite, err)
 	}
 }
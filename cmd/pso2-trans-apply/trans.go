@@ -38,7 +38,7 @@ func main() {
 		}
 	}()
 
-	var flagTrans, flagBackup, flagOutput string
+	var flagTrans, flagBackup, flagOutput, flagStale string
 	var flagParallel int
 
 	flag.Usage = usage
@@ -46,6 +46,7 @@ func main() {
 	flag.StringVar(&flagTrans, "t", "", "translation name")
 	flag.StringVar(&flagBackup, "b", "", "backup files to this path before modifying them")
 	flag.StringVar(&flagOutput, "o", "", "alternate output directory")
+	flag.StringVar(&flagStale, "stale", "warn", "what to do with stale translations (apply, warn or skip)")
 	flag.Parse()
 
 	if flag.NArg() < 1 {
@@ -59,6 +60,9 @@ func main() {
 		runtime.GOMAXPROCS(flagParallel)
 	}
 
+	staleMode, err := cmd.ParseStaleMode(flagStale)
+	ragequit("", err)
+
 	dbpath := flag.Arg(0)
 	db, err := trans.NewDatabase(dbpath)
 	ragequit(dbpath, err)
@@ -82,7 +86,7 @@ func main() {
 			ragequit(flagOutput, err)
 		}
 
-		errs := cmd.PatchFiles(db, pso2dir, flagTrans, flagBackup, flagOutput, flagParallel)
+		errs := cmd.PatchFiles(db, pso2dir, flagTrans, flagBackup, flagOutput, flagParallel, staleMode)
 
 		for _, err := range errs {
 			complain("", err)
@@ -11,6 +11,7 @@ import (
 	"runtime"
 	"encoding/csv"
 	"aaronlindsay.com/go/pkg/pso2/ice"
+	"aaronlindsay.com/go/pkg/pso2/names"
 	"aaronlindsay.com/go/pkg/pso2/text"
 	"aaronlindsay.com/go/pkg/pso2/util"
 	"aaronlindsay.com/go/pkg/pso2/trans"
@@ -44,6 +45,9 @@ func ragequit(apath string, err error) {
 func main() {
 	var flagTrans, flagBackup, flagOutput, flagStrip string
 	var flagAidaSkits, flagAidaStrings string
+	var flagNames string
+	var flagDiff, flagStale string
+	var flagListStale bool
 	var flagImport, flagParallel int
 
 	flag.Usage = usage
@@ -51,10 +55,14 @@ func main() {
 	flag.IntVar(&flagParallel, "p", runtime.NumCPU() + 1, "max parallel tasks")
 	flag.StringVar(&flagTrans, "t", "", "translation name (eng, story-eng, etc.)")
 	flag.StringVar(&flagBackup, "b", "", "backup files to this path before modifying them")
-	flag.StringVar(&flagStrip, "s", "", "write out a stripped database")
+	flag.StringVar(&flagStrip, "s", "", "write out a stripped database, without source strings or their history. Translations can't become stale until the strings are imported again, and changes made before then go unnoticed")
 	flag.StringVar(&flagOutput, "o", "", "alternate output directory for repacked files")
 	flag.StringVar(&flagAidaSkits, "aidaskits", "", "skit list file")
 	flag.StringVar(&flagAidaStrings, "aidastrings", "", "translation csv file")
+	flag.StringVar(&flagNames, "n", "", "dictionary of data/win32 names, allowing archives to be specified by their logical names")
+	flag.StringVar(&flagDiff, "diff", "", "mark translations stale from a csv file written by pso2-text diff -o")
+	flag.StringVar(&flagStale, "stale", "warn", "what to do with stale translations when patching (apply, warn or skip)")
+	flag.BoolVar(&flagListStale, "liststale", false, "list the stale translations of -t")
 	flag.Parse()
 
 	if flag.NArg() < 1 {
@@ -68,11 +76,30 @@ func main() {
 		runtime.GOMAXPROCS(flagParallel)
 	}
 
+	var dict *names.Dictionary
+	if flagNames != "" {
+		var err error
+		dict, err = names.LoadDictionaryFile(flagNames)
+		ragequit(flagNames, err)
+	}
+
 	dbpath := flag.Arg(0)
 	fmt.Fprintf(os.Stderr, "Opening database `%s`...\n", dbpath)
 	db, err := trans.NewDatabase(dbpath)
 	ragequit(dbpath, err)
 
+	staleMode, err := cmd.ParseStaleMode(flagStale)
+	ragequit("", err)
+
+	if flagDiff != "" {
+		fmt.Fprintf(os.Stderr, "Marking stale translations from `%s`...\n", flagDiff)
+		marked, errs := cmd.MarkStale(db, flagDiff, dict)
+		for _, err := range errs {
+			complain(flagDiff, err)
+		}
+		fmt.Fprintf(os.Stderr, "%d changed strings found\n", marked)
+	}
+
 	if flagImport != 0 {
 		if flagAidaSkits != "" || flagAidaStrings != "" {
 			if flagAidaSkits == "" || flagAidaStrings == "" || flagTrans == "" {
@@ -94,12 +121,12 @@ func main() {
 					continue
 				}
 
-				aname, err := trans.ArchiveNameFromString(scanArchive)
-				if complain(scanArchive, err) {
+				hash, err := dict.Lookup(scanArchive)
+				if complain(flagAidaSkits, err) {
 					continue
 				}
 
-				archiveMap[scanName] = *aname
+				archiveMap[scanName] = trans.ArchiveName(hash)
 			}
 
 			sf.Close()
@@ -199,12 +226,17 @@ func main() {
 		} else {
 			for i := 1; i < flag.NArg(); i++ {
 				name := flag.Arg(i)
-				aname, err := trans.ArchiveNameFromString(path.Base(name))
+				hash, err := dict.Lookup(path.Base(name))
 				if complain(name, err) {
 					continue
 				}
 
-				fmt.Fprintf(os.Stderr, "Opening archive `%s`...\n", name)
+				if _, err := os.Stat(name); os.IsNotExist(err) {
+					name = path.Join(path.Dir(name), hash.String())
+				}
+				aname := (*trans.ArchiveName)(&hash)
+
+				fmt.Fprintf(os.Stderr, "Opening archive `%s` (%s)...\n", name, dict.Display(hash))
 				af, err := os.OpenFile(name, os.O_RDONLY, 0);
 				if complain(name, err) {
 					continue
@@ -315,6 +347,35 @@ func main() {
 				af.Close()
 			}
 		}
+	} else if flagListStale {
+		if flagTrans == "" {
+			ragequit("", errors.New("-liststale needs -t"))
+		}
+
+		translation, err := db.QueryTranslation(flagTrans)
+		if err == nil && translation == nil {
+			err = errors.New("translation not found")
+		}
+		ragequit(flagTrans, err)
+
+		stale, err := db.QueryStaleStrings(translation)
+		ragequit(flagTrans, err)
+
+		for i := range stale {
+			s := &stale[i]
+			ts, err := db.QueryTranslationString(translation, s)
+			if complain(s.Identifier, err) || ts == nil {
+				continue
+			}
+
+			fmt.Printf("%s[%d]: %q -> %q\n", s.Identifier, s.Collision, s.Value, ts.Translation)
+
+			history, err := db.QueryStringHistory(s)
+			if !complain(s.Identifier, err) && len(history) > 0 {
+				h := history[len(history) - 1]
+				fmt.Printf("\twas %q (version %d)\n", h.Value, h.Version)
+			}
+		}
 	} else if flagTrans != "" {
 		if flag.NArg() < 2 {
 			fmt.Fprintln(os.Stderr, "no pso2 dir provided")
@@ -334,7 +395,7 @@ func main() {
 			ragequit(flagOutput, err)
 		}
 
-		errs := cmd.PatchFiles(db, pso2dir, flagTrans, flagBackup, flagOutput, flagParallel)
+		errs := cmd.PatchFiles(db, pso2dir, flagTrans, flagBackup, flagOutput, flagParallel, staleMode)
 
 		for _, err := range errs {
 			complain("", err)
@@ -17,6 +17,7 @@ import (
 	"encoding/json"
 	"github.com/cheggaaa/pb"
 	"aaronlindsay.com/go/pkg/pso2/download"
+	"aaronlindsay.com/go/pkg/pso2/names"
 	"aaronlindsay.com/go/pkg/pso2/util"
 )
 
@@ -30,6 +31,7 @@ const (
 	PathTranslationBin = "translation.bin"
 	PathEnglishDb = "english.db"
 	PathTranslationCfg = "translation.cfg"
+	PathNames = "names.txt"
 	EnglishUpdateURL = "http://aaronlindsay.com/pso2/download.json"
 )
 
@@ -87,6 +89,22 @@ func LoadVersionFile(filename string) (s string, err error) {
 	return
 }
 
+// LoadNames reads the data/win32 name dictionary from the scratch folder, returning an empty one if it is missing
+func LoadNames(pso2path string) (*names.Dictionary, error) {
+	d, err := names.LoadDictionaryFile(path.Join(PathScratch(pso2path), PathNames))
+	if os.IsNotExist(err) {
+		return names.NewDictionary(), nil
+	}
+
+	return d, err
+}
+
+// DisplayPath replaces the hash in a patchlist path with its logical name, if known
+func DisplayPath(dict *names.Dictionary, p string) string {
+	p = download.RemoveExtension(p)
+	return path.Join(path.Dir(p), dict.DisplayString(path.Base(p)))
+}
+
 func LoadPatchlistFile(filename, urlStr string) (p *download.PatchList, err error) {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -83,6 +83,20 @@ func (p *PatchList) Diff(po *PatchList) (pn *PatchList) {
 	return
 }
 
+func (p *PatchList) Filter(keep func(e *PatchEntry) bool) (pn *PatchList) {
+	pn = &PatchList{URL: p.URL, EntryMap: make(map[string]*PatchEntry)}
+
+	for i := range p.Entries {
+		if keep(&p.Entries[i]) {
+			pn.Entries = append(pn.Entries, p.Entries[i])
+		}
+	}
+
+	pn.fillMap()
+
+	return
+}
+
 func (p *PatchList) MergeOld(po *PatchList) (pn *PatchList) {
 	if po == nil {
 		return p
@@ -0,0 +1,155 @@
+package format
+
+import (
+	"io"
+	"fmt"
+	"strings"
+	bin "encoding/binary"
+)
+
+func le32(header []uint8, offset int) (uint32, bool) {
+	if len(header) < offset + 4 {
+		return 0, false
+	}
+	return bin.LittleEndian.Uint32(header[offset:]), true
+}
+
+func be32(header []uint8, offset int) (uint32, bool) {
+	if len(header) < offset + 4 {
+		return 0, false
+	}
+	return bin.BigEndian.Uint32(header[offset:]), true
+}
+
+func tag(header []uint8, offset int) string {
+	if len(header) < offset + 4 {
+		return ""
+	}
+	return strings.TrimRight(string(header[offset:offset + 4]), "\x00 ")
+}
+
+func cString(reader io.ReaderAt, offset int64) string {
+	var data [0x40]uint8
+	n, _ := reader.ReadAt(data[:], offset)
+	value := string(data[:n])
+	if i := strings.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return value
+}
+
+func describeICE(info *Info, header []uint8, reader io.ReaderAt) {
+	if version, ok := le32(header, 0x08); ok {
+		info.Version = fmt.Sprint(version)
+	}
+	if flags, ok := le32(header, 0x18); ok && flags & 1 != 0 {
+		info.Summary = "encrypted"
+	}
+}
+
+func describeAFP(info *Info, header []uint8, reader io.ReaderAt) {
+	if count, ok := le32(header, 0x04); ok {
+		info.Summary = fmt.Sprintf("%d entries", count)
+	}
+}
+
+func describeNIFL(info *Info, header []uint8, reader io.ReaderAt) {
+	if version, ok := le32(header, 0x08); ok {
+		info.Version = fmt.Sprint(version)
+	}
+
+	offset, ok := le32(header, 0x0c)
+	size, ok2 := le32(header, 0x10)
+	if ok && ok2 {
+		var rel0 [0x0c]uint8
+		if n, _ := reader.ReadAt(rel0[:], int64(offset)); n == len(rel0) && string(rel0[:4]) == "REL0" {
+			info.Summary = fmt.Sprintf("REL0 0x%x bytes, root 0x%x", size, bin.LittleEndian.Uint32(rel0[8:]))
+		}
+	}
+}
+
+func describeVTBF(info *Info, header []uint8, reader io.ReaderAt) {
+	size, ok := le32(header, 0x04)
+	if !ok {
+		return
+	}
+	info.Summary = tag(header, 0x08)
+
+	// Walk the tag list to count sections
+	var entry [0x0c]uint8
+	count := 0
+	for offset := int64(size); ; count++ {
+		if n, _ := reader.ReadAt(entry[:], offset); n != len(entry) {
+			break
+		}
+		offset += 8 + int64(bin.LittleEndian.Uint32(entry[4:]))
+	}
+	info.Summary += fmt.Sprintf(", %d sections", count)
+}
+
+func describeDDS(info *Info, header []uint8, reader io.ReaderAt) {
+	height, ok := le32(header, 0x0c)
+	width, _ := le32(header, 0x10)
+	mips, _ := le32(header, 0x1c)
+	flags, _ := le32(header, 0x50)
+	if !ok {
+		return
+	}
+
+	pixels := tag(header, 0x54)
+	if flags & 0x04 == 0 {
+		bits, _ := le32(header, 0x58)
+		pixels = fmt.Sprintf("%d-bit", bits)
+	}
+
+	info.Summary = fmt.Sprintf("%dx%d %s", width, height, pixels)
+	if mips > 1 {
+		info.Summary += fmt.Sprintf(", %d mipmaps", mips)
+	}
+}
+
+func describePNG(info *Info, header []uint8, reader io.ReaderAt) {
+	width, ok := be32(header, 0x10)
+	height, _ := be32(header, 0x14)
+	if ok {
+		info.Summary = fmt.Sprintf("%dx%d", width, height)
+	}
+}
+
+// CRI @UTF tables, used on their own for ACB files and inside CPK archives
+func describeUTF(info *Info, header []uint8, reader io.ReaderAt) {
+	stringOffset, ok := be32(header, 0x0c)
+	name, _ := be32(header, 0x14)
+	rows, _ := be32(header, 0x1c)
+	if ok {
+		info.Summary = fmt.Sprintf("table `%s`, %d rows", cString(reader, 8 + int64(stringOffset) + int64(name)), rows)
+	}
+}
+
+func describeCPK(info *Info, header []uint8, reader io.ReaderAt) {
+	if len(header) >= 0x14 && string(header[0x10:0x14]) == "@UTF" {
+		describeUTF(info, header[0x10:], io.NewSectionReader(reader, 0x10, 1 << 32))
+	}
+}
+
+func describeLua(info *Info, header []uint8, reader io.ReaderAt) {
+	if len(header) > 4 {
+		info.Version = fmt.Sprintf("%d.%d", header[4] >> 4, header[4] & 0x0f)
+	}
+}
+
+func init() {
+	RegisterMagic("ICE", "ICE\x00", describeICE)
+	RegisterMagic("AFP", "afp\x00", describeAFP)
+	RegisterMagic("NIFL", "NIFL", describeNIFL)
+	RegisterMagic("VTBF", "VTBF", describeVTBF)
+	RegisterMagic("DDS", "DDS ", describeDDS)
+	RegisterMagic("PNG", "\x89PNG\r\n\x1a\n", describePNG)
+	RegisterMagic("CRI @UTF", "@UTF", describeUTF)
+	RegisterMagic("CRI CPK", "CPK ", describeCPK)
+	RegisterMagic("CRI AWB", "AFS2", nil)
+	RegisterMagic("CRI USM", "CRID", nil)
+	RegisterMagic("Lua bytecode", "\x1bLua", describeLua)
+	RegisterMagic("Ogg", "OggS", nil)
+	RegisterMagic("RIFF", "RIFF", nil)
+}
@@ -0,0 +1,103 @@
+package format
+
+import (
+	"io"
+	"sync"
+	"aaronlindsay.com/go/pkg/pso2/util"
+)
+
+// How much of a file is handed to each Sniffer
+const HeaderSize = 0x100
+
+type Info struct {
+	Format string
+	Version string
+	Summary string
+}
+
+func (i *Info) String() (value string) {
+	value = i.Format
+	if i.Version != "" {
+		value += " v" + i.Version
+	}
+	if i.Summary != "" {
+		value += ": " + i.Summary
+	}
+	return
+}
+
+// A Sniffer identifies a file format. The header holds up to HeaderSize bytes from the start of the file, and the
+// reader may be used to look further in. Sniff returns nil for files it does not recognise.
+type Sniffer interface {
+	Sniff(header []uint8, reader io.ReaderAt) *Info
+}
+
+// Magic is a Sniffer that matches a fixed byte string at the start of a file. Describe may be nil, otherwise it
+// fills in the version and summary.
+type Magic struct {
+	Format, Magic string
+	Describe func(info *Info, header []uint8, reader io.ReaderAt)
+}
+
+func (m *Magic) Sniff(header []uint8, reader io.ReaderAt) *Info {
+	if len(header) < len(m.Magic) || string(header[:len(m.Magic)]) != m.Magic {
+		return nil
+	}
+
+	info := &Info{Format: m.Format}
+	if m.Describe != nil {
+		m.Describe(info, header, reader)
+	}
+	return info
+}
+
+var registry struct {
+	sync.RWMutex
+	sniffers []Sniffer
+}
+
+// Register adds a Sniffer to the registry. Sniffers registered later take priority, so a package can override
+// the built in description of a format.
+func Register(s Sniffer) {
+	registry.Lock()
+	registry.sniffers = append(registry.sniffers, s)
+	registry.Unlock()
+}
+
+func RegisterMagic(format, magic string, describe func(info *Info, header []uint8, reader io.ReaderAt)) {
+	Register(&Magic{format, magic, describe})
+}
+
+// Sniff identifies the format of a file, returning nil if nothing recognises it. The position of a seekable reader
+// is left where it was.
+func Sniff(reader io.Reader) (*Info, error) {
+	if seeker, ok := reader.(io.Seeker); ok {
+		if _, ok := reader.(io.ReaderAt); !ok {
+			pos, err := seeker.Seek(0, 1)
+			if err != nil {
+				return nil, err
+			}
+			defer seeker.Seek(pos, 0)
+		}
+	}
+
+	r := util.ReaderAt(reader)
+
+	header := make([]uint8, HeaderSize)
+	n, err := r.ReadAt(header, 0)
+	if n == 0 && err != nil && err != io.EOF {
+		return nil, err
+	}
+	header = header[:n]
+
+	registry.RLock()
+	defer registry.RUnlock()
+
+	for i := len(registry.sniffers) - 1; i >= 0; i-- {
+		if info := registry.sniffers[i].Sniff(header, r); info != nil {
+			return info, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,137 @@
+package format
+
+import (
+	"io"
+	"bytes"
+	"strings"
+	"testing"
+	bin "encoding/binary"
+)
+
+// testHeader lays out values at offsets, little endian unless they're big endian uint32s, in a buffer of size bytes
+func testHeader(size int, values ...interface{}) []uint8 {
+	data := make([]uint8, size)
+	for i := 0; i < len(values); i += 2 {
+		offset := values[i].(int)
+		switch v := values[i + 1].(type) {
+			case string:
+				copy(data[offset:], v)
+			case uint32:
+				bin.LittleEndian.PutUint32(data[offset:], v)
+			case []uint32:
+				for j, value := range v {
+					bin.BigEndian.PutUint32(data[offset + j * 4:], value)
+				}
+		}
+	}
+
+	return data
+}
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name string
+		data []uint8
+		expected string
+	}{
+		{ "ice", testHeader(0x20, 0, "ICE\x00", 0x08, uint32(4), 0x18, uint32(1)), "ICE v4: encrypted" },
+		{ "ice unencrypted", testHeader(0x20, 0, "ICE\x00", 0x08, uint32(3)), "ICE v3" },
+		{ "ice truncated", []uint8("ICE\x00"), "ICE" },
+		{ "afp", testHeader(0x10, 0, "afp\x00", 0x04, uint32(2)), "AFP: 2 entries" },
+		{ "nifl", testHeader(0x30, 0, "NIFL", 0x08, uint32(1), 0x0c, uint32(0x20), 0x10, uint32(0x30), 0x20, "REL0", 0x28, uint32(0x38)),
+			"NIFL v1: REL0 0x30 bytes, root 0x38" },
+		{ "nifl without REL0", testHeader(0x20, 0, "NIFL", 0x08, uint32(1), 0x0c, uint32(0x20)), "NIFL v1" },
+		{ "vtbf", testHeader(0x2c, 0, "VTBF", 0x04, uint32(0x10), 0x08, "AQO", 0x10, "vtc0", 0x14, uint32(0x04), 0x20, "vtc0", 0x24, uint32(0)),
+			"VTBF: AQO, 2 sections" },
+		{ "dds compressed", testHeader(0x80, 0, "DDS ", 0x0c, uint32(4), 0x10, uint32(8), 0x1c, uint32(3), 0x50, uint32(0x04), 0x54, "DXT1"),
+			"DDS: 8x4 DXT1, 3 mipmaps" },
+		{ "dds uncompressed", testHeader(0x80, 0, "DDS ", 0x0c, uint32(4), 0x10, uint32(8), 0x1c, uint32(1), 0x50, uint32(0x40), 0x58, uint32(32)),
+			"DDS: 8x4 32-bit" },
+		{ "png", testHeader(0x20, 0, "\x89PNG\r\n\x1a\n", 0x0c, "IHDR", 0x10, []uint32{ 16, 9 }), "PNG: 16x9" },
+		{ "utf", testHeader(0x40, 0, "@UTF", 0x0c, []uint32{ 0x20 }, 0x1c, []uint32{ 1 }, 0x28, "Header"), "CRI @UTF: table `Header`, 1 rows" },
+		{ "cpk", testHeader(0x50, 0, "CPK ", 0x10, "@UTF", 0x1c, []uint32{ 0x20 }, 0x2c, []uint32{ 2 }, 0x38, "CpkHeader"),
+			"CRI CPK: table `CpkHeader`, 2 rows" },
+		{ "awb", []uint8("AFS2\x01\x04"), "CRI AWB" },
+		{ "usm", []uint8("CRID\x00\x00"), "CRI USM" },
+		{ "lua", []uint8("\x1bLua\x51\x00"), "Lua bytecode v5.1" },
+		{ "ogg", []uint8("OggS\x00\x02"), "Ogg" },
+		{ "riff", []uint8("RIFF\x24\x00\x00\x00WAVE"), "RIFF" },
+		{ "unknown", []uint8("text file\n"), "" },
+		{ "short", []uint8("IC"), "" },
+		{ "empty", nil, "" },
+	}
+
+	for _, test := range tests {
+		info, err := Sniff(bytes.NewReader(test.data))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		value := ""
+		if info != nil {
+			value = info.String()
+		}
+
+		if value != test.expected {
+			t.Errorf("%s: sniffed as %q, expected %q", test.name, value, test.expected)
+		}
+	}
+}
+
+// Every built in magic is distinct, and none is a prefix of another that would then never match
+func TestBuiltinOrder(t *testing.T) {
+	registry.RLock()
+	sniffers := append([]Sniffer(nil), registry.sniffers...)
+	registry.RUnlock()
+
+	var formats []string
+	for i, s := range sniffers {
+		m, ok := s.(*Magic)
+		if !ok {
+			t.Fatalf("sniffer %d is not a Magic", i)
+		}
+		formats = append(formats, m.Format)
+
+		for _, other := range sniffers[:i] {
+			if o := other.(*Magic); strings.HasPrefix(o.Magic, m.Magic) || strings.HasPrefix(m.Magic, o.Magic) {
+				t.Errorf("%s and %s overlap", o.Format, m.Format)
+			}
+		}
+	}
+
+	expected := "ICE, AFP, NIFL, VTBF, DDS, PNG, CRI @UTF, CRI CPK, CRI AWB, CRI USM, Lua bytecode, Ogg, RIFF"
+	if value := strings.Join(formats, ", "); value != expected {
+		t.Errorf("built in formats %s", value)
+	}
+}
+
+// A reader that can seek but not ReadAt, to check that Sniff puts it back
+type seekOnlyReader struct {
+	io.ReadSeeker
+}
+
+func TestSniffOverride(t *testing.T) {
+	data := testHeader(0x10, 0, "afp\x00", 0x04, uint32(2))
+
+	RegisterMagic("AFP override", "afp\x00", func(info *Info, header []uint8, reader io.ReaderAt) {
+		info.Summary = "overridden"
+	})
+	defer func() {
+		registry.Lock()
+		registry.sniffers = registry.sniffers[:len(registry.sniffers) - 1]
+		registry.Unlock()
+	}()
+
+	reader := seekOnlyReader{ bytes.NewReader(data) }
+	reader.Seek(3, 0)
+
+	info, err := Sniff(reader)
+	if err != nil || info == nil || info.String() != "AFP override: overridden" {
+		t.Errorf("sniffed as %+v, %v", info, err)
+	}
+
+	if pos, _ := reader.Seek(0, 1); pos != 3 {
+		t.Errorf("reader left at %d", pos)
+	}
+}
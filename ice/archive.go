@@ -0,0 +1,502 @@
+package ice
+
+import (
+	"io"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"aaronlindsay.com/go/pkg/pso2/util"
+	bin "encoding/binary"
+)
+
+const (
+	HeaderMagic uint32 = 0x00454349 // little endian "ICE\0"
+
+	// Stored in the reserved field of the group table by Write when files have been replaced
+	ModifiedMagic uint32 = 0x444f4d47 // little endian "GMOD"
+)
+
+const (
+	groupCount = 2
+	groupXorKey = 0x95
+	fileHeaderSize = 0x40
+	fileAlignment = 0x10
+)
+
+type archiveHeader struct {
+	Magic, Reserved, Version, Unk80, UnkFF, CRC, Flags, Size uint32
+}
+
+type groupHeader struct {
+	Size, CompressedSize, FileCount, CRC uint32
+}
+
+type groupTable struct {
+	Groups [groupCount]groupHeader
+	StoredSize [groupCount]uint32
+	Key, Reserved uint32
+}
+
+type fileHeader struct {
+	Type [4]uint8
+	EntrySize, DataSize, HeaderSize, NameSize uint32
+	Unk [0x2c]uint8
+}
+
+type Archive struct {
+	CompressionLevel int
+	Encrypted bool
+
+	reader io.ReadSeeker
+	header archiveHeader
+	keys []uint8
+	table groupTable
+	groups [groupCount]Group
+}
+
+type Group struct {
+	Files []File
+	Compressed bool
+
+	header groupHeader
+	stored io.ReadSeeker
+	dirty bool
+
+	// Reused instead of recompressing when the rebuilt contents still match a manifest
+	original *ManifestGroup
+	originalStored io.ReadSeeker
+}
+
+type File struct {
+	Type, Name string
+	Size uint32
+	Data io.ReadSeeker
+
+	header fileHeader
+	nameData []uint8
+	group int
+}
+
+func NewArchive(reader io.ReadSeeker) (*Archive, error) {
+	a := &Archive{ reader: reader }
+	return a, a.parse()
+}
+
+// CreateArchive returns an empty archive with compressed groups, ready for AddFile
+func CreateArchive() *Archive {
+	a := &Archive{}
+	a.header = archiveHeader{ Magic: HeaderMagic, Version: 4, Unk80: 0x80, UnkFF: 0xff }
+	a.keys = make([]uint8, 0x100)
+
+	for i := range a.groups {
+		g := &a.groups[i]
+		g.Compressed = true
+		g.stored = bytes.NewReader(nil)
+		g.dirty = true
+	}
+
+	return a
+}
+
+func (h *archiveHeader) Validate() error {
+	if h.Magic != HeaderMagic {
+		return errors.New("not an ICE archive")
+	}
+
+	if h.Version != 3 && h.Version != 4 {
+		return errors.New("unsupported ICE version")
+	}
+
+	return nil
+}
+
+func (h *fileHeader) Validate() error {
+	if h.HeaderSize < fileHeaderSize || h.NameSize > h.HeaderSize - fileHeaderSize {
+		return errors.New("file header format error (invalid header size)")
+	}
+
+	if h.EntrySize < h.HeaderSize + h.DataSize {
+		return errors.New("file header format error (invalid entry size)")
+	}
+
+	return nil
+}
+
+func (h *groupHeader) storedSize() uint32 {
+	if h.CompressedSize != 0 {
+		return h.CompressedSize
+	}
+
+	return h.Size
+}
+
+func (a *Archive) parse() (err error) {
+	end := bin.LittleEndian
+
+	if err = bin.Read(a.reader, end, &a.header); err != nil {
+		return
+	}
+
+	if err = a.header.Validate(); err != nil {
+		return
+	}
+
+	offset := int64(0x20)
+
+	if a.header.Version >= 4 {
+		a.keys = make([]uint8, 0x100)
+		if _, err = io.ReadFull(a.reader, a.keys); err != nil {
+			return
+		}
+		offset += int64(len(a.keys))
+	}
+
+	a.Encrypted = a.header.Flags & archiveFlagEncrypted != 0
+	keys := newArchiveKeys(&a.header, a.keys)
+
+	table := make([]uint8, 0x30)
+	if _, err = io.ReadFull(a.reader, table); err != nil {
+		return
+	}
+	offset += int64(len(table))
+
+	if a.Encrypted {
+		blowfishCrypt(keys.table, table, true)
+	}
+
+	if err = bin.Read(bytes.NewReader(table), end, &a.table); err != nil {
+		return
+	}
+
+	for i := range a.groups {
+		group := &a.groups[i]
+		group.header = a.table.Groups[i]
+		group.Compressed = group.header.CompressedSize != 0
+
+		size := int64(group.header.storedSize())
+		group.stored = io.NewSectionReader(util.ReaderAt(a.reader), offset, size)
+		offset += size
+
+		if a.Encrypted {
+			data := make([]uint8, size)
+			if _, err = io.ReadFull(group.stored, data); err != nil {
+				return
+			}
+
+			keys.decryptGroup(i, data)
+			group.stored = bytes.NewReader(data)
+		}
+
+		if err = group.parse(i); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (g *Group) data() io.ReadSeeker {
+	if g.header.CompressedSize != 0 {
+		return newPrsReader(xorReader(g.Stored(), groupXorKey), int64(g.header.Size))
+	}
+
+	return g.Stored()
+}
+
+func (g *Group) parse(index int) (err error) {
+	if g.header.FileCount == 0 {
+		return nil
+	}
+
+	data := g.data()
+	reader := util.ReaderAt(data)
+
+	g.Files = make([]File, g.header.FileCount)
+
+	offset := int64(0)
+	for i := range g.Files {
+		file := &g.Files[i]
+		file.group = index
+
+		if _, err = data.Seek(offset, 0); err != nil {
+			return
+		}
+
+		if err = bin.Read(data, bin.LittleEndian, &file.header); err != nil {
+			return
+		}
+
+		if err = file.header.Validate(); err != nil {
+			return
+		}
+
+		file.nameData = make([]uint8, file.header.HeaderSize - fileHeaderSize)
+		if _, err = io.ReadFull(data, file.nameData); err != nil {
+			return
+		}
+
+		file.Type = cString(file.header.Type[:])
+		file.Name = cString(file.nameData[:file.header.NameSize])
+		file.Size = file.header.DataSize
+		file.Data = io.NewSectionReader(reader, offset + int64(file.header.HeaderSize), int64(file.header.DataSize))
+
+		offset += int64(file.header.EntrySize)
+	}
+
+	return
+}
+
+// ReadAt provides random access to the file's contents, decompressing only what is necessary
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	return util.ReaderAt(f.Data).ReadAt(p, off)
+}
+
+func cString(data []uint8) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+
+	return string(data)
+}
+
+func (a *Archive) GroupCount() int {
+	return len(a.groups)
+}
+
+func (a *Archive) Group(i int) *Group {
+	return &a.groups[i]
+}
+
+// FindFile searches the specified group for a file, or all groups if group is -1
+func (a *Archive) FindFile(group int, name string) *File {
+	for i := range a.groups {
+		if group >= 0 && group != i {
+			continue
+		}
+
+		g := &a.groups[i]
+		for f := range g.Files {
+			if g.Files[f].Name == name {
+				return &g.Files[f]
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReplaceFile swaps the contents of a file with size bytes from data. A nil data removes the file from the archive.
+func (a *Archive) ReplaceFile(file *File, data io.ReadSeeker, size uint32) {
+	g := &a.groups[file.group]
+
+	for i := range g.Files {
+		f := &g.Files[i]
+		if f.Name != file.Name {
+			continue
+		}
+
+		if data == nil {
+			// Copy so that anyone iterating over the old slice isn't disrupted
+			g.Files = append(g.Files[:i:i], g.Files[i + 1:]...)
+		} else {
+			f.Data = data
+			f.Size = size
+		}
+
+		g.dirty = true
+		break
+	}
+}
+
+// AddFile appends a new file to the end of a group
+func (a *Archive) AddFile(group int, name, fileType string, data io.ReadSeeker, size uint32) *File {
+	g := &a.groups[group]
+
+	file := File{ Type: fileType, Name: name, Size: size, Data: data, group: group }
+
+	nameSize := uint32(len(name) + 1)
+	file.nameData = make([]uint8, (nameSize + fileAlignment - 1) / fileAlignment * fileAlignment)
+	copy(file.nameData, name)
+
+	copy(file.header.Type[:], fileType)
+	file.header.NameSize = nameSize
+	file.header.HeaderSize = fileHeaderSize + uint32(len(file.nameData))
+
+	if original := g.original.findFile(name); original != nil {
+		file.Type = original.Type
+		file.header = original.fileHeader()
+		file.nameData = append([]uint8(nil), original.NameData...)
+	}
+
+	g.Files = append(g.Files, file)
+	g.dirty = true
+
+	return &g.Files[len(g.Files) - 1]
+}
+
+// IsModified reports whether the archive was previously written with replaced files
+func (a *Archive) IsModified() bool {
+	return a.table.Reserved == ModifiedMagic
+}
+
+func (g *Group) modified() bool {
+	return g.dirty || g.Compressed != (g.header.CompressedSize != 0)
+}
+
+// write returns the group as it will be stored, and whether it differs from what the archive's table describes
+func (g *Group) write(level int) (data []uint8, changed bool, err error) {
+	if !g.modified() {
+		data = make([]uint8, g.header.storedSize())
+		if _, err = g.stored.Seek(0, 0); err == nil {
+			_, err = io.ReadFull(g.stored, data)
+		}
+		return
+	}
+
+	end := bin.LittleEndian
+	buffer := &bytes.Buffer{}
+
+	for i := range g.Files {
+		file := &g.Files[i]
+
+		header := file.header
+		if header.DataSize != file.Size || header.EntrySize < header.HeaderSize + file.Size {
+			header.DataSize = file.Size
+			header.EntrySize = (header.HeaderSize + header.DataSize + fileAlignment - 1) / fileAlignment * fileAlignment
+		}
+
+		bin.Write(buffer, end, &header)
+		buffer.Write(file.nameData)
+
+		if _, err = file.Data.Seek(0, 0); err != nil {
+			return
+		}
+
+		if _, err = io.CopyN(buffer, file.Data, int64(file.Size)); err != nil {
+			return
+		}
+
+		buffer.Write(make([]uint8, header.EntrySize - header.HeaderSize - header.DataSize))
+	}
+
+	g.header.FileCount = uint32(len(g.Files))
+	g.header.Size = uint32(buffer.Len())
+	data = buffer.Bytes()
+
+	if g.matchesOriginal(data) {
+		if g.Compressed {
+			data = make([]uint8, g.original.CompressedSize)
+			if _, err = g.originalStored.Seek(0, 0); err == nil {
+				_, err = io.ReadFull(g.originalStored, data)
+			}
+		}
+
+		g.header = g.original.header()
+		g.stored = bytes.NewReader(data)
+		g.dirty = false
+		return
+	}
+
+	// The stored data no longer matches the header, so later writes have to rebuild the group too
+	g.dirty = true
+	changed = true
+
+	if g.Compressed && len(data) > 0 {
+		compressed := &bytes.Buffer{}
+		writer := newPrsWriterLevel(compressed, level)
+		if _, err = writer.Write(data); err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			return
+		}
+
+		data = compressed.Bytes()
+		xorBytes(data, groupXorKey)
+		g.header.CompressedSize = uint32(len(data))
+	} else {
+		g.header.CompressedSize = 0
+	}
+
+	g.header.CRC = crc32.ChecksumIEEE(data)
+
+	return
+}
+
+func (a *Archive) Write(writer io.Writer) error {
+	end := bin.LittleEndian
+
+	header := a.header
+	table := a.table
+
+	var groups [groupCount][]uint8
+	dirty := false
+	for i := range a.groups {
+		g := &a.groups[i]
+
+		data, changed, err := g.write(a.CompressionLevel)
+		if err != nil {
+			return err
+		}
+
+		groups[i] = data
+		table.Groups[i] = g.header
+		if changed {
+			table.StoredSize[i] = uint32(len(data))
+			dirty = true
+		}
+	}
+
+	if dirty {
+		// Archives created from scratch have no original to be restored from
+		if a.reader != nil {
+			table.Reserved = ModifiedMagic
+		}
+
+		crc := crc32.NewIEEE()
+		size := 0x20 + len(a.keys) + 0x30
+		for _, data := range groups {
+			crc.Write(data)
+			size += len(data)
+		}
+		header.CRC = crc.Sum32()
+		header.Size = uint32(size)
+	}
+
+	tableData := &bytes.Buffer{}
+	bin.Write(tableData, end, &table)
+
+	if a.Encrypted {
+		header.Flags |= archiveFlagEncrypted
+
+		keys := newArchiveKeys(&header, a.keys)
+		blowfishCrypt(keys.table, tableData.Bytes(), false)
+		for i, data := range groups {
+			// Encryption happens in place, and data may be shared with the group's stored reader
+			groups[i] = append([]uint8(nil), data...)
+			keys.encryptGroup(i, groups[i])
+		}
+	} else {
+		header.Flags &^= archiveFlagEncrypted
+	}
+
+	if err := bin.Write(writer, end, &header); err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(a.keys); err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(tableData.Bytes()); err != nil {
+		return err
+	}
+
+	for _, data := range groups {
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package ice
+
+import (
+	"io"
+	"bytes"
+	"testing"
+)
+
+// Changing only whether a group is compressed rebuilds it, and the header and table follow
+func TestToggleCompression(t *testing.T) {
+	original := manifestTestArchive(t, false)
+
+	for i := 0; i < groupCount; i++ {
+		a, err := NewArchive(bytes.NewReader(original))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed := !a.Group(i).Compressed
+		a.Group(i).Compressed = compressed
+
+		var first, second bytes.Buffer
+		if err := a.Write(&first); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Write(&second); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Errorf("group %d: second write differs", i)
+		}
+
+		b, err := NewArchive(bytes.NewReader(first.Bytes()))
+		if err != nil {
+			t.Fatalf("group %d: %v", i, err)
+		}
+		if b.Group(i).Compressed != compressed {
+			t.Errorf("group %d: compressed %v, expected %v", i, b.Group(i).Compressed, compressed)
+		}
+		if int(b.header.Size) != first.Len() {
+			t.Errorf("group %d: header size 0x%x, wrote 0x%x", i, b.header.Size, first.Len())
+		}
+
+		for _, f := range manifestTestFiles() {
+			file := b.FindFile(f.group, f.name)
+			if file == nil {
+				t.Fatalf("group %d: %s missing", i, f.name)
+			}
+
+			data := make([]uint8, file.Size)
+			if _, err := file.ReadAt(data, 0); err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, f.data) {
+				t.Errorf("group %d: %s differs", i, f.name)
+			}
+		}
+	}
+}
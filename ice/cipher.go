@@ -0,0 +1,109 @@
+package ice
+
+import (
+	"hash/crc32"
+	"golang.org/x/crypto/blowfish"
+	bin "encoding/binary"
+)
+
+// Reference: ZamboniLib's IceV3File/IceV4File key schedule
+
+const (
+	archiveFlagEncrypted = 0x01
+
+	cipherKeySalt = 0x4352f5c2
+	cipherKeyRoundsSalt = 0x8e02c25c
+	cipherKeyRoundsXor = 0xcd50379e
+	cipherDoublePassLimit = 0x19000
+)
+
+type archiveKeys struct {
+	table uint32
+	groups [groupCount][2]uint32
+	doublePass bool
+}
+
+func rotateLeft(v uint32, n uint) uint32 {
+	return v << n | v >> (32 - n)
+}
+
+func rotateByte(v uint8, n uint) uint8 {
+	return v << n | v >> (8 - n)
+}
+
+// GetKey in the reference: picks four bytes out of the v4 key block, offsets and rotates them
+func cipherKey(keys []uint8, seed uint32) uint32 {
+	a := keys[uint8(seed)] + 0x5d
+	b := keys[uint8(seed >> 8)] + 0x3f
+	c := keys[uint8(seed >> 16)] + 0x45
+	d := keys[uint8(seed >> 24)] - 0x3a
+
+	return uint32(rotateByte(b, 7)) << 24 | uint32(rotateByte(d, 6)) << 16 | uint32(rotateByte(a, 5)) << 8 | uint32(rotateByte(c, 4))
+}
+
+// CalcBlowfishKeys in the reference: iterates cipherKey 2 to 8 times, depending on the seed
+func cipherKeyRounds(keys []uint8, seed uint32) uint32 {
+	key := seed ^ cipherKeyRoundsSalt
+	for i := key % 7 + 2; i > 0; i-- {
+		key = cipherKey(keys, key)
+	}
+
+	return key ^ cipherKeySalt ^ cipherKeyRoundsXor
+}
+
+// The keys depend on the final file size, so header.Size (and CRC for v3) must be filled in beforehand
+func newArchiveKeys(header *archiveHeader, keys []uint8) (k archiveKeys) {
+	var key, key2 uint32
+
+	if header.Version >= 4 {
+		seed := crc32.ChecksumIEEE(keys[0x7c:0xdc]) ^ bin.LittleEndian.Uint32(keys[0x6c:]) ^ header.Size ^ cipherKeySalt
+		key = cipherKeyRounds(keys, cipherKey(keys, seed))
+		key2 = cipherKey(keys, key)
+		k.doublePass = true
+	} else {
+		key = header.CRC ^ header.Size ^ cipherKeySalt
+		key2 = key
+	}
+
+	k.groups[0] = [2]uint32{key, key2}
+	k.groups[1] = [2]uint32{rotateLeft(key, 15), rotateLeft(key2, 15)}
+	k.table = rotateLeft(key, 13)
+
+	return
+}
+
+func blowfishCrypt(key uint32, data []uint8, decrypt bool) {
+	var k [4]uint8
+	bin.LittleEndian.PutUint32(k[:], key)
+	c, _ := blowfish.NewCipher(k[:])
+
+	// Blocks are treated as two little endian words, trailing bytes are left alone
+	var block [8]uint8
+	for i := 0; i + len(block) <= len(data); i += len(block) {
+		bin.BigEndian.PutUint32(block[:4], bin.LittleEndian.Uint32(data[i:]))
+		bin.BigEndian.PutUint32(block[4:], bin.LittleEndian.Uint32(data[i + 4:]))
+
+		if decrypt {
+			c.Decrypt(block[:], block[:])
+		} else {
+			c.Encrypt(block[:], block[:])
+		}
+
+		bin.LittleEndian.PutUint32(data[i:], bin.BigEndian.Uint32(block[:4]))
+		bin.LittleEndian.PutUint32(data[i + 4:], bin.BigEndian.Uint32(block[4:]))
+	}
+}
+
+func (k *archiveKeys) decryptGroup(i int, data []uint8) {
+	blowfishCrypt(k.groups[i][0], data, true)
+	if k.doublePass && len(data) <= cipherDoublePassLimit {
+		blowfishCrypt(k.groups[i][1], data, true)
+	}
+}
+
+func (k *archiveKeys) encryptGroup(i int, data []uint8) {
+	if k.doublePass && len(data) <= cipherDoublePassLimit {
+		blowfishCrypt(k.groups[i][1], data, false)
+	}
+	blowfishCrypt(k.groups[i][0], data, false)
+}
@@ -0,0 +1,126 @@
+package ice
+
+import (
+	"io"
+	"os"
+	"bytes"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+func testKeyBlock() []uint8 {
+	keys := make([]uint8, 0x100)
+	for i := range keys {
+		keys[i] = uint8(i * 0x3b + 7)
+	}
+
+	return keys
+}
+
+// Expected values were computed with a transcription of ZamboniLib's GetKey and CalcBlowfishKeys
+func TestArchiveKeysV4(t *testing.T) {
+	header := archiveHeader{ Version: 4, Size: 0x12345 }
+	k := newArchiveKeys(&header, testKeyBlock())
+
+	if !k.doublePass {
+		t.Error("v4 archives use two passes")
+	}
+
+	expected := [groupCount][2]uint32{
+		{ 0x672246d9, 0x34a2ec22 },
+		{ 0x236cb391, 0x76111a51 },
+	}
+	if k.groups != expected {
+		t.Errorf("group keys %08x, expected %08x", k.groups, expected)
+	}
+
+	if k.table != 0x48db2ce4 {
+		t.Errorf("table key %08x, expected 48db2ce4", k.table)
+	}
+}
+
+func TestCipherKey(t *testing.T) {
+	keys := testKeyBlock()
+
+	// The offsets apply to the bytes looked up, not to their indices
+	if key := cipherKey(keys, 0); key != 0x23738cc4 {
+		t.Errorf("cipherKey(0) = %08x", key)
+	}
+}
+
+func TestGroupCipherRoundTrip(t *testing.T) {
+	header := archiveHeader{ Version: 4, Size: 0x12345 }
+	k := newArchiveKeys(&header, testKeyBlock())
+
+	for _, size := range []int{ 0, 7, 0x100, 0x19001 } {
+		data := make([]uint8, size)
+		for i := range data {
+			data[i] = uint8(i * 13)
+		}
+		original := append([]uint8(nil), data...)
+
+		k.encryptGroup(1, data)
+		if size >= 8 && bytes.Equal(data, original) {
+			t.Errorf("%d bytes: not encrypted", size)
+		}
+
+		k.decryptGroup(1, data)
+		if !bytes.Equal(data, original) {
+			t.Errorf("%d bytes: round trip mismatch", size)
+		}
+	}
+}
+
+// The vectors above only check the key schedule against another transcription of it. With PSO2_WIN32 set to a
+// data/win32 folder, encrypted archives from the game are decrypted too: a wrong key leaves the group headers and
+// file headers as garbage, which fails to parse.
+func TestGameArchives(t *testing.T) {
+	dir := os.Getenv("PSO2_WIN32")
+	if dir == "" {
+		t.Skip("PSO2_WIN32 not set")
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checked := 0
+	for _, info := range infos {
+		if info.IsDir() || checked >= 50 {
+			continue
+		}
+
+		f, err := os.Open(filepath.Join(dir, info.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var magic [4]uint8
+		if _, err := io.ReadFull(f, magic[:]); err != nil || string(magic[:]) != "ICE\x00" {
+			f.Close()
+			continue
+		}
+		f.Seek(0, 0)
+
+		a, err := NewArchive(f)
+		if err != nil {
+			t.Errorf("%s: %v", info.Name(), err)
+		} else if a.Encrypted {
+			for i := 0; i < a.GroupCount(); i++ {
+				for _, file := range a.Group(i).Files {
+					if n, err := io.Copy(ioutil.Discard, io.NewSectionReader(&file, 0, int64(file.Size))); err != nil || n != int64(file.Size) {
+						t.Errorf("%s: %s: read %d of %d bytes, %v", info.Name(), file.Name, n, file.Size, err)
+					}
+				}
+			}
+			checked++
+		}
+		f.Close()
+	}
+
+	if checked == 0 {
+		t.Error("no encrypted archives found")
+	}
+}
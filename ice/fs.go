@@ -0,0 +1,27 @@
+package ice
+
+import (
+	"fmt"
+	"io/fs"
+	"aaronlindsay.com/go/pkg/pso2/util"
+)
+
+// FS exposes the archive's current contents with each group as a numbered top-level directory.
+// File info Sys() values are the corresponding *File.
+func (a *Archive) FS() fs.FS {
+	var entries []util.FSEntry
+
+	for i := range a.groups {
+		g := &a.groups[i]
+		dir := fmt.Sprintf("%d", i)
+
+		entries = append(entries, util.FSEntry{ Path: dir })
+
+		for f := range g.Files {
+			file := &g.Files[f]
+			entries = append(entries, util.FSEntry{ Path: dir + "/" + file.Name, Size: int64(file.Size), Data: util.ReaderAt(file.Data), Sys: file })
+		}
+	}
+
+	return util.NewFS(entries)
+}
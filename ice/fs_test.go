@@ -0,0 +1,27 @@
+package ice
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestArchiveFS(t *testing.T) {
+	a, err := NewArchive(bytes.NewReader(manifestTestArchive(t, true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := a.FS()
+	if err := fstest.TestFS(fsys, "0/a.text", "0/b.lua", "1/c.dds", "1/d.text"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range manifestTestFiles() {
+		name := []string{ "0", "1" }[f.group] + "/" + f.name
+		if data, err := fs.ReadFile(fsys, name); err != nil || !bytes.Equal(data, f.data) {
+			t.Errorf("%s: %d bytes, %v", name, len(data), err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package ice
+
+import (
+	"io"
+	"errors"
+	"hash/crc32"
+	"aaronlindsay.com/go/pkg/pso2/util"
+)
+
+// Manifest records everything about an archive besides file contents, so that it can be rebuilt byte for byte
+type Manifest struct {
+	Version, Reserved, Unk80, UnkFF, CRC, Flags, Size uint32
+	Keys []uint8
+	TableKey, TableReserved uint32
+
+	Groups []ManifestGroup
+}
+
+type ManifestGroup struct {
+	Compressed bool
+	Size, CompressedSize, FileCount, CRC, StoredSize uint32
+
+	// CRC of the uncompressed group contents
+	DataCRC uint32
+
+	Files []ManifestFile
+}
+
+type ManifestFile struct {
+	Name, Type string
+	EntrySize, DataSize, HeaderSize, NameSize uint32
+	Header []uint8
+	NameData []uint8
+}
+
+func (m *ManifestGroup) header() groupHeader {
+	return groupHeader{m.Size, m.CompressedSize, m.FileCount, m.CRC}
+}
+
+func (m *ManifestFile) fileHeader() (h fileHeader) {
+	copy(h.Type[:], m.Type)
+	h.EntrySize = m.EntrySize
+	h.DataSize = m.DataSize
+	h.HeaderSize = m.HeaderSize
+	h.NameSize = m.NameSize
+	copy(h.Unk[:], m.Header)
+	return
+}
+
+func (g *Group) matchesOriginal(data []uint8) bool {
+	if g.original == nil || g.Compressed != (g.original.CompressedSize != 0) {
+		return false
+	}
+
+	if g.Compressed && g.originalStored == nil {
+		return false
+	}
+
+	return uint32(len(data)) == g.original.Size && crc32.ChecksumIEEE(data) == g.original.DataCRC
+}
+
+func (a *Archive) Manifest() (*Manifest, error) {
+	m := &Manifest{
+		Version: a.header.Version,
+		Reserved: a.header.Reserved,
+		Unk80: a.header.Unk80,
+		UnkFF: a.header.UnkFF,
+		CRC: a.header.CRC,
+		Flags: a.header.Flags,
+		Size: a.header.Size,
+		Keys: a.keys,
+		TableKey: a.table.Key,
+		TableReserved: a.table.Reserved,
+		Groups: make([]ManifestGroup, len(a.groups)),
+	}
+
+	for i := range a.groups {
+		g := &a.groups[i]
+		mg := &m.Groups[i]
+
+		mg.Compressed = g.Compressed
+		mg.Size = g.header.Size
+		mg.CompressedSize = g.header.CompressedSize
+		mg.FileCount = g.header.FileCount
+		mg.CRC = g.header.CRC
+		mg.StoredSize = a.table.StoredSize[i]
+
+		crc := crc32.NewIEEE()
+		if g.header.Size > 0 {
+			data := g.data()
+			if _, err := data.Seek(0, 0); err != nil {
+				return nil, err
+			}
+
+			if _, err := io.CopyN(crc, data, int64(g.header.Size)); err != nil {
+				return nil, err
+			}
+		}
+		mg.DataCRC = crc.Sum32()
+
+		mg.Files = make([]ManifestFile, len(g.Files))
+		for f := range g.Files {
+			file := &g.Files[f]
+			h := &file.header
+
+			mg.Files[f] = ManifestFile{
+				Name: file.Name,
+				Type: file.Type,
+				EntrySize: h.EntrySize,
+				DataSize: h.DataSize,
+				HeaderSize: h.HeaderSize,
+				NameSize: h.NameSize,
+				Header: append([]uint8(nil), h.Unk[:]...),
+				NameData: file.nameData,
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// Stored provides the group as it appears in the archive, after decryption but before decompression
+func (g *Group) Stored() io.ReadSeeker {
+	return io.NewSectionReader(util.ReaderAt(g.stored), 0, int64(g.header.storedSize()))
+}
+
+// CreateArchiveManifest returns an empty archive laid out as the manifest describes. Files added with the
+// names listed in the manifest reuse their original headers, and a group whose rebuilt contents are unchanged
+// is written using the corresponding stored data (see Group.Stored) rather than being recompressed.
+func CreateArchiveManifest(m *Manifest, stored []io.ReadSeeker) (*Archive, error) {
+	if len(m.Groups) != groupCount {
+		return nil, errors.New("manifest group count mismatch")
+	}
+
+	a := CreateArchive()
+	a.header = archiveHeader{HeaderMagic, m.Reserved, m.Version, m.Unk80, m.UnkFF, m.CRC, m.Flags, m.Size}
+	a.Encrypted = m.Flags & archiveFlagEncrypted != 0
+	a.table.Key = m.TableKey
+	a.table.Reserved = m.TableReserved
+
+	if err := a.header.Validate(); err != nil {
+		return nil, err
+	}
+
+	if m.Version >= 4 {
+		if len(m.Keys) != len(a.keys) {
+			return nil, errors.New("manifest key block size mismatch")
+		}
+		copy(a.keys, m.Keys)
+	} else {
+		a.keys = nil
+	}
+
+	for i := range a.groups {
+		g := &a.groups[i]
+		mg := &m.Groups[i]
+
+		g.Compressed = mg.Compressed
+		g.original = mg
+		a.table.StoredSize[i] = mg.StoredSize
+
+		if i < len(stored) {
+			g.originalStored = stored[i]
+		}
+	}
+
+	return a, nil
+}
+
+func (m *ManifestGroup) findFile(name string) *ManifestFile {
+	if m == nil {
+		return nil
+	}
+
+	for i := range m.Files {
+		if m.Files[i].Name == name {
+			return &m.Files[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package ice
+
+import (
+	"io"
+	"bytes"
+	"testing"
+	"io/ioutil"
+	"math/rand"
+)
+
+type manifestTestFile struct {
+	group int
+	name, fileType string
+	data []uint8
+}
+
+func manifestTestFiles() []manifestTestFile {
+	random := make([]uint8, 0x9000)
+	rand.New(rand.NewSource(2)).Read(random)
+
+	return []manifestTestFile{
+		{ 0, "a.text", "text", []uint8("first group") },
+		{ 0, "b.lua", "lua", bytes.Repeat([]uint8("print(1)\n"), 40) },
+		{ 1, "c.dds", "dds", random },
+		{ 1, "d.text", "text", bytes.Repeat([]uint8{ 0x11, 0x22 }, 0x800) },
+	}
+}
+
+// Builds an archive with an uncompressed first group and a compressed second group
+func manifestTestArchive(t *testing.T, encrypted bool) []uint8 {
+	a := CreateArchive()
+	a.groups[0].Compressed = false
+	for i := range a.keys {
+		a.keys[i] = uint8(i * 0x3b + 7)
+	}
+	a.Encrypted = encrypted
+
+	for _, f := range manifestTestFiles() {
+		a.AddFile(f.group, f.name, f.fileType, bytes.NewReader(f.data), uint32(len(f.data)))
+	}
+
+	var out bytes.Buffer
+	if err := a.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.Bytes()
+}
+
+// Rebuilds an archive from its manifest, stored groups and extracted files, as pso2-ice -x and -c do
+func rebuildFromManifest(t *testing.T, a *Archive) *Archive {
+	m, err := a.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := make([]io.ReadSeeker, a.GroupCount())
+	for i := range stored {
+		if a.Group(i).Compressed {
+			data, err := ioutil.ReadAll(a.Group(i).Stored())
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored[i] = bytes.NewReader(data)
+		}
+	}
+
+	rebuilt, err := CreateArchiveManifest(m, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < a.GroupCount(); i++ {
+		for _, file := range a.Group(i).Files {
+			data := make([]uint8, file.Size)
+			if _, err := file.ReadAt(data, 0); err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+
+			rebuilt.AddFile(i, file.Name, file.Type, bytes.NewReader(data), file.Size)
+		}
+	}
+
+	return rebuilt
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	for _, encrypted := range []bool{ false, true } {
+		original := manifestTestArchive(t, encrypted)
+
+		a, err := NewArchive(bytes.NewReader(original))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Encrypted != encrypted {
+			t.Fatalf("encrypted %v: flag not preserved", encrypted)
+		}
+
+		rebuilt := rebuildFromManifest(t, a)
+
+		// The second write covers groups whose stored data was set by the first
+		for pass := 1; pass <= 2; pass++ {
+			var out bytes.Buffer
+			if err := rebuilt.Write(&out); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(out.Bytes(), original) {
+				t.Errorf("encrypted %v, write %d: rebuilt archive differs", encrypted, pass)
+			}
+		}
+	}
+}
+
+func TestEncryptedRewrite(t *testing.T) {
+	original := manifestTestArchive(t, true)
+
+	a, err := NewArchive(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for pass := 1; pass <= 2; pass++ {
+		var out bytes.Buffer
+		if err := a.Write(&out); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out.Bytes(), original) {
+			t.Errorf("write %d: unmodified archive differs", pass)
+		}
+	}
+}
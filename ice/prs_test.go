@@ -0,0 +1,196 @@
+package ice
+
+import (
+	"io"
+	"sync"
+	"bytes"
+	"testing"
+	"math/rand"
+)
+
+var prsTestLevels = []int{ CompressionFast, CompressionDefault, CompressionBest }
+
+// Compresses data in chunks of the given size and checks that prsReader gives it back
+func prsRoundTrip(t *testing.T, data []uint8, level, chunk int) {
+	var compressed bytes.Buffer
+	w := newPrsWriterLevel(&compressed, level)
+	for i := 0; i < len(data); i += chunk {
+		end := i + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+
+		if _, err := w.Write(data[i:end]); err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("level %d: %v", level, err)
+	}
+
+	r := newPrsReader(bytes.NewReader(compressed.Bytes()), int64(len(data)))
+	out := make([]uint8, len(data))
+	if _, err := io.ReadFull(r, out); err != nil {
+		t.Fatalf("level %d, %d bytes: %v", level, len(data), err)
+	}
+
+	if !bytes.Equal(out, data) {
+		for i := range out {
+			if out[i] != data[i] {
+				t.Fatalf("level %d, %d bytes in chunks of %d: mismatch at 0x%x", level, len(data), chunk, i)
+			}
+		}
+	}
+}
+
+func prsInputs() map[string][]uint8 {
+	rng := rand.New(rand.NewSource(1))
+
+	random := make([]uint8, 0x10000)
+	rng.Read(random)
+
+	// Repeated words, with the occasional random byte to break up matches
+	words := []string{ "the ", "quick ", "brown ", "fox ", "\x00\x00\x00\x01", "NIFL", "REL0" }
+	var text []uint8
+	for len(text) < 0x30000 {
+		text = append(text, words[rng.Intn(len(words))]...)
+		if rng.Intn(50) == 0 {
+			text = append(text, uint8(rng.Intn(0x100)))
+		}
+	}
+
+	lowEntropy := make([]uint8, 0x20000)
+	for i := range lowEntropy {
+		lowEntropy[i] = uint8(rng.Intn(4)) * 0x40
+	}
+
+	return map[string][]uint8{
+		"empty": {},
+		"one byte": { 0x42 },
+		"long run": bytes.Repeat([]uint8{ 0xaa }, 0x10000),
+		"random": random,
+		"text": text,
+		"low entropy": lowEntropy,
+	}
+}
+
+func TestPrsRoundTrip(t *testing.T) {
+	for name, data := range prsInputs() {
+		for _, level := range prsTestLevels {
+			t.Run(name, func(t *testing.T) {
+				prsRoundTrip(t, data, level, len(data) + 1)
+				prsRoundTrip(t, data, level, 0x1000)
+			})
+		}
+	}
+}
+
+// Small writes split matches across calls, and the inputs are longer than the 0x2000 window
+func TestPrsChunkedWrites(t *testing.T) {
+	inputs := prsInputs()
+	for _, name := range []string{ "long run", "text", "low entropy" } {
+		data := inputs[name][:0x6000]
+		if len(data) <= prsBufferLookbehind {
+			t.Fatalf("%s: input shorter than the window", name)
+		}
+
+		for _, level := range prsTestLevels {
+			prsRoundTrip(t, data, level, 7)
+		}
+	}
+}
+
+// A long run is only encoded compactly if the long copy form is used
+func TestPrsLongCopy(t *testing.T) {
+	data := bytes.Repeat([]uint8{ 0x01, 0x02, 0x03 }, 0x4000)
+
+	for _, level := range prsTestLevels {
+		var compressed bytes.Buffer
+		w := newPrsWriterLevel(&compressed, level)
+		w.Write(data)
+		w.Close()
+
+		if compressed.Len() > len(data) / 32 {
+			t.Errorf("level %d: %d bytes compressed to %d", level, len(data), compressed.Len())
+		}
+
+		prsRoundTrip(t, data, level, len(data))
+	}
+}
+
+// Reads from random offsets, backwards and forwards, over more output than the buffer holds, so that seeks resume
+// from several checkpoints
+func TestPrsRandomAccess(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	data := make([]uint8, prsBufferSize + prsCheckpointInterval * 2 + 0x123)
+	for i := range data {
+		data[i] = uint8(rng.Intn(8)) + uint8(i >> 12)
+	}
+
+	var compressed bytes.Buffer
+	w := newPrsWriter(&compressed)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newPrsReader(bytes.NewReader(compressed.Bytes()), int64(len(data)))
+	buffer := make([]uint8, 0x3000)
+	for i := 0; i < 200; i++ {
+		offset := rng.Int63n(int64(len(data)))
+
+		var err error
+		var position int64
+		switch i % 3 {
+			case 0:
+				position, err = r.Seek(offset, 0)
+			case 1:
+				position, err = r.Seek(offset - r.position, 1)
+			case 2:
+				position, err = r.Seek(offset - int64(len(data)), 2)
+		}
+		if err != nil || position != offset {
+			t.Fatalf("seek to 0x%x: at 0x%x, %v", offset, position, err)
+		}
+
+		n, err := io.ReadFull(r, buffer)
+		if err != nil && !(err == io.ErrUnexpectedEOF && offset + int64(n) == int64(len(data))) {
+			t.Fatalf("read at 0x%x: %v", offset, err)
+		}
+
+		if !bytes.Equal(buffer[:n], data[offset:offset + int64(n)]) {
+			t.Fatalf("read at 0x%x: mismatch", offset)
+		}
+	}
+
+	if len(r.checkpoints) < 3 {
+		t.Errorf("%d checkpoints", len(r.checkpoints))
+	}
+
+	if _, err := r.Seek(0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Read(buffer); n != 0 || err != io.EOF {
+		t.Errorf("read at the end: %d, %v", n, err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+
+			rng := rand.New(rand.NewSource(seed))
+			p := make([]uint8, 0x800)
+			for i := 0; i < 50; i++ {
+				offset := rng.Int63n(int64(len(data) - len(p)))
+				if n, err := r.ReadAt(p, offset); err != nil || n != len(p) || !bytes.Equal(p, data[offset:offset + int64(len(p))]) {
+					t.Errorf("ReadAt 0x%x: %d bytes, %v", offset, n, err)
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+}
@@ -2,7 +2,10 @@ package ice
 
 import (
 	"io"
+	"sort"
+	"sync"
 	"errors"
+	"aaronlindsay.com/go/pkg/pso2/util"
 )
 
 // Reference: https://github.com/Grumbel/rfactortools/blob/master/other/quickbms/src/compression/prs.cpp
@@ -11,9 +14,17 @@ import (
 const prsBufferSize = 0x80000
 const prsBufferThreshold = prsBufferSize - 0x400
 const prsBufferLookbehind = 0x2000
+const prsCheckpointInterval = 0x20000
 
 var prsEOF = errors.New("prsEOF")
 
+// Decompressor state that a backwards seek can resume from
+type prsCheckpoint struct {
+	position, input int64
+	controlPos, controlByte uint8
+	window []uint8
+}
+
 type prsReader struct {
 	reader io.ReadSeeker
 	controlPos, controlByte uint8
@@ -22,13 +33,17 @@ type prsReader struct {
 	byteBuffer [1]uint8
 	outputBufferPosition int
 	outputPosition int
-	size, position int64
+	size, position, input int64
 	err error
+
+	checkpoints []prsCheckpoint
+	lock sync.Mutex
 }
 
 func (s *prsReader) readByte() (ret uint8, err error) {
 	n, err := s.reader.Read(s.byteBuffer[:])
 	ret = s.byteBuffer[0]
+	s.input += int64(n)
 	if n == 1 {
 		err = nil
 	}
@@ -143,16 +158,52 @@ func (s *prsReader) flushOutput() {
 	}
 }
 
-func (s *prsReader) Read(p []byte) (n int, err error) {
-	err = s.err
+func (s *prsReader) checkpoint() {
+	last := &s.checkpoints[len(s.checkpoints) - 1]
+	if s.err != nil || s.position < last.position + prsCheckpointInterval {
+		return
+	}
+
+	start := s.outputBufferPosition - prsBufferLookbehind
+	if start < 0 {
+		start = 0
+	}
+
+	window := make([]uint8, s.outputBufferPosition - start)
+	copy(window, s.buffer[start:s.outputBufferPosition])
+
+	s.checkpoints = append(s.checkpoints, prsCheckpoint{s.position, s.input, s.controlPos, s.controlByte, window})
+}
+
+func (s *prsReader) restore(c *prsCheckpoint) (err error) {
+	if _, err = s.reader.Seek(c.input, 0); err != nil {
+		return
+	}
+
+	copy(s.buffer[:], c.window)
+	s.input = c.input
+	s.controlPos = c.controlPos
+	s.controlByte = c.controlByte
+	s.outputBufferPosition = len(c.window)
+	s.outputPosition = len(c.window)
+	s.position = c.position
+	s.err = nil
+
+	return
+}
 
+func (s *prsReader) Read(p []byte) (n int, err error) {
 	if s.position >= s.size {
-		err = io.EOF
+		return 0, io.EOF
 	}
 
+	err = s.err
+
 	// Buffer decompressed output
 	for len(p) > 0 && err == nil {
 		if s.outputBufferPosition <= s.outputPosition {
+			s.checkpoint()
+
 			if err = s.decompress(); err != nil {
 				break
 			}
@@ -210,14 +261,17 @@ func (s *prsReader) Seek(offset int64, whence int) (pos int64, err error) {
 			offset += s.Size()
 	}
 
-	// Rewind to beginning...
-	if offset < int64(s.position) {
-		s.reader.Seek(0, 0)
-		s.controlPos = 1
-		s.outputBufferPosition = 0
-		s.outputPosition = 0
-		s.position = 0
-		s.err = nil
+	// Resume from the closest checkpoint if it saves us from rewinding or skipping ahead
+	i := sort.Search(len(s.checkpoints), func(i int) bool {
+		return s.checkpoints[i].position > offset
+	})
+	if i > 0 {
+		c := &s.checkpoints[i - 1]
+		if offset < s.position || c.position > s.position {
+			if err = s.restore(c); err != nil {
+				return s.position, err
+			}
+		}
 	}
 
 	// Then read forward until we reach our destination
@@ -236,10 +290,29 @@ func (s *prsReader) Seek(offset int64, whence int) (pos int64, err error) {
 	return
 }
 
+// ReadAt is safe for concurrent use, but moves the position used by Read
+func (s *prsReader) ReadAt(p []byte, off int64) (n int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err = s.Seek(off, 0); err != nil {
+		return
+	}
+
+	n, err = io.ReadFull(s, p)
+	if n < len(p) && off + int64(n) >= s.size {
+		err = io.EOF
+	}
+
+	return
+}
+
 func (s *prsReader) Size() int64 {
 	return s.size
 }
 
 func newPrsReader(reader io.ReadSeeker, size int64) *prsReader {
-	return &prsReader{reader, 1, 0, [prsBufferSize]uint8{}, [1]uint8{}, 0, 0, size, 0, nil}
+	s := &prsReader{ reader: util.BufReader(reader), controlPos: 1, size: size }
+	s.checkpoints = []prsCheckpoint{{0, 0, 1, 0, nil}}
+	return s
 }
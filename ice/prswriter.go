@@ -2,69 +2,227 @@ package ice
 
 import "io"
 
-// TODO: This is incorrect. Need to buffer writes until the control byte is full
+const (
+	prsMaxShortCopy = 5
+	prsMaxShortOffset = 0x100
+	prsMaxLongCopy = 0xff + 10
+	prsMaxLongOffset = prsBufferLookbehind - 1 // A zero offset field marks the end of the stream
+	prsWindowMask = prsBufferLookbehind - 1
+	prsSlideThreshold = 0x10000
+)
+
+const (
+	CompressionDefault = iota
+	CompressionFast
+	CompressionBest
+)
+
+type prsLevel struct {
+	chain int
+	lazy bool
+}
+
+var prsLevels = [...]prsLevel {
+	CompressionDefault: {0x40, true},
+	CompressionFast: {0x04, false},
+	CompressionBest: {prsBufferLookbehind, true},
+}
 
 type prsWriter struct {
 	writer io.Writer
 	controlPos, controlByte uint8
-}
+	buffer []uint8
+	level prsLevel
 
-func (s *prsWriter) writeByte(b uint8) (err error) {
-	_, err = s.writer.Write([]uint8 { b })
-	return
+	// Lookbehind window followed by input that has not been encoded yet
+	data []uint8
+	base, position, inserted int
+
+	// Hash chains of 2-byte prefixes, storing absolute positions + 1
+	head [0x10000]int
+	prev [prsBufferLookbehind]int
+
+	cached bool
+	cachedLength, cachedDistance int
 }
 
-func (s *prsWriter) writeControlStream(b, save bool) error {
-	s.controlByte >>= 1
-	if b {
-		s.controlByte |= 0x80
+func (s *prsWriter) writeControlStream(b bool) (err error) {
+	if s.controlPos >= 8 {
+		err = s.saveControlStream()
 	}
-	s.controlPos++
 
-	if save {
-		return s.saveControlStream()
+	if b {
+		s.controlByte |= 1 << s.controlPos
 	}
+	s.controlPos++
 
-	return nil
+	return
 }
 
+// The control byte must precede any data bytes that were queued while it was being filled
 func (s *prsWriter) saveControlStream() (err error) {
-	if s.controlPos >= 8 {
-		err = s.writeByte(s.controlByte)
+	if s.controlPos > 0 {
+		if _, err = s.writer.Write([]uint8 { s.controlByte }); err == nil {
+			_, err = s.writer.Write(s.buffer)
+		}
 
 		s.controlPos = 0
 		s.controlByte = 0
+		s.buffer = s.buffer[:0]
 	}
 
 	return
 }
 
-func (s *prsWriter) Write(p []byte) (n int, err error) {
-	for _, b := range p {
-		s.writeControlStream(true, false)
-		err = s.writeByte(b)
-		if err == nil {
-			err = s.saveControlStream()
+func (s *prsWriter) writeLiteral(b uint8) (err error) {
+	err = s.writeControlStream(true)
+	s.buffer = append(s.buffer, b)
+	return
+}
+
+func (s *prsWriter) writeCopy(length, distance int) (err error) {
+	if length <= prsMaxShortCopy && distance <= prsMaxShortOffset {
+		size := length - 2
+		for _, b := range []bool { false, false, size & 2 != 0, size & 1 != 0 } {
+			if err = s.writeControlStream(b); err != nil {
+				return
+			}
 		}
 
-		if err != nil {
-			break
+		s.buffer = append(s.buffer, uint8(0x100 - distance))
+	} else {
+		if err = s.writeControlStream(false); err == nil {
+			err = s.writeControlStream(true)
+		}
+
+		offset := uint16(prsBufferLookbehind - distance) << 3
+		if length <= 9 {
+			offset |= uint16(length - 2)
+			s.buffer = append(s.buffer, uint8(offset), uint8(offset >> 8))
 		} else {
+			s.buffer = append(s.buffer, uint8(offset), uint8(offset >> 8), uint8(length - 10))
+		}
+	}
+
+	return
+}
+
+func (s *prsWriter) insert(pos int) {
+	i := pos - s.base
+	key := uint16(s.data[i]) << 8 | uint16(s.data[i + 1])
+
+	s.prev[pos & prsWindowMask] = s.head[key]
+	s.head[key] = pos + 1
+}
+
+// findMatch returns the longest encodable match for the input at pos
+func (s *prsWriter) findMatch(pos int) (length, distance int) {
+	for ; s.inserted < pos && s.inserted + 1 < s.base + len(s.data); s.inserted++ {
+		s.insert(s.inserted)
+	}
+
+	i := pos - s.base
+	max := len(s.data) - i
+	if max > prsMaxLongCopy {
+		max = prsMaxLongCopy
+	}
+
+	if max < 2 {
+		return
+	}
+
+	key := uint16(s.data[i]) << 8 | uint16(s.data[i + 1])
+	candidate := s.head[key] - 1
+	for chain := s.level.chain; candidate >= 0 && chain > 0; chain-- {
+		dist := pos - candidate
+		if dist > prsMaxLongOffset {
+			break
+		}
+
+		j := candidate - s.base
+		n := 2
+		for n < max && s.data[j + n] == s.data[i + n] {
 			n++
 		}
+
+		// Two byte copies are only worthwhile in the short form
+		if n > length && (n > 2 || dist <= prsMaxShortOffset) {
+			length, distance = n, dist
+
+			if n == max {
+				break
+			}
+		}
+
+		candidate = s.prev[candidate & prsWindowMask] - 1
+	}
+
+	return
+}
+
+func (s *prsWriter) compress(final bool) (err error) {
+	end := s.base + len(s.data)
+
+	for s.position < end && err == nil {
+		if !final && end - s.position <= prsMaxLongCopy {
+			break
+		}
+
+		length, distance := s.cachedLength, s.cachedDistance
+		if !s.cached {
+			length, distance = s.findMatch(s.position)
+		}
+		s.cached = false
+
+		if length >= 2 && length < prsMaxLongCopy && s.level.lazy {
+			// Defer to a longer match starting at the next byte
+			s.cachedLength, s.cachedDistance = s.findMatch(s.position + 1)
+			s.cached = true
+
+			if s.cachedLength > length {
+				length = 0
+			}
+		}
+
+		if length < 2 {
+			err = s.writeLiteral(s.data[s.position - s.base])
+			s.position++
+		} else {
+			err = s.writeCopy(length, distance)
+			s.position += length
+			s.cached = false
+		}
+	}
+
+	if drop := s.position - prsBufferLookbehind - s.base; drop > prsSlideThreshold {
+		s.data = s.data[:copy(s.data, s.data[drop:])]
+		s.base += drop
+	}
+
+	return
+}
+
+func (s *prsWriter) Write(p []byte) (n int, err error) {
+	s.data = append(s.data, p...)
+
+	if err = s.compress(false); err == nil {
+		n = len(p)
 	}
 
 	return
 }
 
 func (s *prsWriter) Close() (err error) {
-	err = s.writeControlStream(false, true)
-	err = s.writeControlStream(true, true)
-	if s.controlPos > 0 {
-		s.controlByte = (s.controlByte << s.controlPos) >> 8
+	if err = s.compress(true); err != nil {
+		return
 	}
-	err = s.writeByte(0)
-	err = s.writeByte(0)
+
+	// A long copy with a zero offset marks the end of the stream
+	s.writeControlStream(false)
+	s.writeControlStream(true)
+	s.buffer = append(s.buffer, 0, 0)
+
+	err = s.saveControlStream()
 
 	if c, ok := s.writer.(io.Closer); ok && err == nil {
 		err = c.Close()
@@ -73,6 +231,14 @@ func (s *prsWriter) Close() (err error) {
 	return
 }
 
+func newPrsWriterLevel(writer io.Writer, level int) *prsWriter {
+	if level < 0 || level >= len(prsLevels) {
+		level = CompressionDefault
+	}
+
+	return &prsWriter{ writer: writer, buffer: make([]uint8, 0, 0x20), level: prsLevels[level] }
+}
+
 func newPrsWriter(writer io.Writer) *prsWriter {
-	return &prsWriter{writer, 0, 0}
+	return newPrsWriterLevel(writer, CompressionDefault)
 }
@@ -0,0 +1,24 @@
+package ice
+
+import "io"
+
+type xorReaderWrapper struct {
+	io.ReadSeeker
+	key uint8
+}
+
+func (x xorReaderWrapper) Read(p []uint8) (n int, err error) {
+	n, err = x.ReadSeeker.Read(p)
+	xorBytes(p[:n], x.key)
+	return
+}
+
+func xorReader(reader io.ReadSeeker, key uint8) io.ReadSeeker {
+	return xorReaderWrapper{reader, key}
+}
+
+func xorBytes(data []uint8, key uint8) {
+	for i := range data {
+		data[i] ^= key
+	}
+}
@@ -0,0 +1,214 @@
+package index
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"aaronlindsay.com/go/pkg/pso2/names"
+)
+
+type Database struct {
+	db *sql.DB
+}
+
+const sqlCreateTables = `
+	CREATE TABLE IF NOT EXISTS archives (
+		archiveid INTEGER PRIMARY KEY NOT NULL,
+		name BLOB UNIQUE NOT NULL,
+		md5 BLOB NOT NULL,
+		size INTEGER NOT NULL,
+		mtime INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS files (
+		fileid INTEGER PRIMARY KEY NOT NULL,
+		archiveid INTEGER REFERENCES archives(archiveid) NOT NULL,
+		grp INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		md5 BLOB NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS files_archive ON files(archiveid);
+	CREATE INDEX IF NOT EXISTS files_name ON files(name);
+	CREATE INDEX IF NOT EXISTS files_type ON files(type);
+	CREATE INDEX IF NOT EXISTS files_md5 ON files(md5);
+`
+
+func NewDatabase(path string) (*Database, error){
+	db, err := sql.Open("sqlite3", path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(sqlCreateTables)
+
+	return &Database{db}, err
+}
+
+func (d *Database) Close() {
+	d.db.Close()
+}
+
+const sqlQueryArchive = "SELECT name, md5, size, mtime FROM archives "
+func (d *Database) queryArchive(rows *sql.Rows) (a *Archive, err error) {
+	a = &Archive{}
+	var name, md5 []uint8
+	err = rows.Scan(&name, &md5, &a.Size, &a.ModTime)
+	if err != nil {
+		a = nil
+	} else {
+		copy(a.Name[:], name)
+		copy(a.MD5[:], md5)
+	}
+
+	return
+}
+
+func (d *Database) QueryArchive(name names.Hash) (a *Archive, err error) {
+	rows, err := d.db.Query(sqlQueryArchive + "WHERE name = ?", name[:])
+	if err != nil {
+		return
+	}
+
+	if rows.Next() {
+		a, err = d.queryArchive(rows)
+	}
+
+	rows.Close()
+	return
+}
+
+func (d *Database) QueryArchives() (archives []Archive, err error) {
+	rows, err := d.db.Query(sqlQueryArchive)
+	if err != nil {
+		return
+	}
+
+	for rows.Next() {
+		var a *Archive
+		a, err = d.queryArchive(rows)
+		if err != nil {
+			break
+		}
+
+		archives = append(archives, *a)
+	}
+
+	rows.Close()
+	return
+}
+
+// UpdateArchive replaces everything recorded about an archive in a single transaction
+func (d *Database) UpdateArchive(a *Archive, files []File) (err error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return
+	}
+
+	if err = removeArchive(tx, a.Name); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	result, err := tx.Exec("INSERT INTO archives (name, md5, size, mtime) VALUES (?, ?, ?, ?)", a.Name[:], a.MD5[:], a.Size, a.ModTime)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO files (archiveid, grp, name, type, size, md5) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+
+	for _, f := range files {
+		if _, err = stmt.Exec(id, f.Group, f.Name, f.Type, f.Size, f.MD5[:]); err != nil {
+			break
+		}
+	}
+	stmt.Close()
+
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) RemoveArchive(name names.Hash) (err error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return
+	}
+
+	if err = removeArchive(tx, name); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	return tx.Commit()
+}
+
+func removeArchive(tx *sql.Tx, name names.Hash) (err error) {
+	_, err = tx.Exec("DELETE FROM files WHERE archiveid IN (SELECT archiveid FROM archives WHERE name = ?)", name[:])
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec("DELETE FROM archives WHERE name = ?", name[:])
+	return
+}
+
+const sqlQueryFile = `
+	SELECT a.name, f.grp, f.name, f.type, f.size, f.md5
+	FROM files AS f
+		JOIN archives AS a ON a.archiveid = f.archiveid `
+const sqlOrderFile = " ORDER BY a.name, f.grp, f.fileid"
+
+func (d *Database) queryFiles(query string, args ...interface{}) (files []File, err error) {
+	rows, err := d.db.Query(sqlQueryFile + query + sqlOrderFile, args...)
+	if err != nil {
+		return
+	}
+
+	for rows.Next() {
+		var f File
+		var archive, md5 []uint8
+		if err = rows.Scan(&archive, &f.Group, &f.Name, &f.Type, &f.Size, &md5); err != nil {
+			break
+		}
+
+		copy(f.Archive[:], archive)
+		copy(f.MD5[:], md5)
+		files = append(files, f)
+	}
+
+	rows.Close()
+	return
+}
+
+// QueryFilesName finds files with names matching a glob pattern (see SQLite's GLOB operator)
+func (d *Database) QueryFilesName(pattern string) ([]File, error) {
+	return d.queryFiles("WHERE f.name GLOB ?", pattern)
+}
+
+func (d *Database) QueryFilesType(fileType string) ([]File, error) {
+	return d.queryFiles("WHERE f.type = ?", fileType)
+}
+
+func (d *Database) QueryFilesArchive(name names.Hash) ([]File, error) {
+	return d.queryFiles("WHERE a.name = ?", name[:])
+}
+
+func (d *Database) QueryFilesMD5(md5 [0x10]uint8) ([]File, error) {
+	return d.queryFiles("WHERE f.md5 = ?", md5[:])
+}
@@ -0,0 +1,215 @@
+package index
+
+import (
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+	"crypto/md5"
+	"io/ioutil"
+	"github.com/cheggaaa/pb"
+	"aaronlindsay.com/go/pkg/pso2/ice"
+	"aaronlindsay.com/go/pkg/pso2/util"
+	"aaronlindsay.com/go/pkg/pso2/names"
+	"aaronlindsay.com/go/pkg/pso2/download"
+)
+
+type Archive struct {
+	Name names.Hash
+	MD5 [0x10]uint8
+	Size int64
+
+	// The modification time of the file when it was hashed, in Unix nanoseconds
+	ModTime int64
+}
+
+type File struct {
+	Archive names.Hash
+	Group int
+	Name, Type string
+	Size uint32
+	MD5 [0x10]uint8
+}
+
+// ScanArchive opens an ICE archive and hashes the contents of every file inside it
+func ScanArchive(filename string) (files []File, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	archive, err := ice.NewArchive(util.BufReader(f))
+	if err != nil {
+		return
+	}
+
+	name := names.Resolve(path.Base(filename))
+
+	for i := 0; i < archive.GroupCount(); i++ {
+		for _, file := range archive.Group(i).Files {
+			h := md5.New()
+			if _, err = file.Data.Seek(0, 0); err != nil {
+				return
+			}
+
+			if _, err = io.CopyN(h, file.Data, int64(file.Size)); err != nil {
+				return
+			}
+
+			entry := File{Archive: name, Group: i, Name: file.Name, Type: file.Type, Size: file.Size}
+			copy(entry.MD5[:], h.Sum(nil))
+			files = append(files, entry)
+		}
+	}
+
+	return
+}
+
+func fileMD5(filename string) (sum [0x10]uint8, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+
+	h := md5.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+
+	copy(sum[:], h.Sum(nil))
+	return
+}
+
+type scanResult struct {
+	archive Archive
+	files []File
+	scanned bool
+	err error
+}
+
+// Update brings the catalog in line with the archives in a data/win32 folder. Archives are skipped without being
+// hashed if their size and modification time match the catalog, and the patchlist (which may be nil) has no entry
+// for them with a different MD5. Anything else is hashed, and scanned again if the MD5 changed. Archives that no
+// longer exist are removed from the catalog.
+func Update(db *Database, dir string, patchlist *download.PatchList, parallel int) (updated int, errs []error) {
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, []error{err}
+	}
+
+	known := make(map[names.Hash]*download.PatchEntry)
+	if patchlist != nil {
+		for i := range patchlist.Entries {
+			e := &patchlist.Entries[i]
+			if h, err := names.ParseHash(download.RemoveExtension(e.BaseName())); err == nil {
+				known[h] = e
+			}
+		}
+	}
+
+	archives, err := db.QueryArchives()
+	if err != nil {
+		return 0, []error{err}
+	}
+
+	catalog := make(map[names.Hash]Archive)
+	stale := make(map[names.Hash]bool)
+	for _, a := range archives {
+		catalog[a.Name] = a
+		stale[a.Name] = true
+	}
+
+	var queue []Archive
+	for _, info := range infos {
+		h, err := names.ParseHash(info.Name())
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		delete(stale, h)
+		queue = append(queue, Archive{Name: h, Size: info.Size(), ModTime: info.ModTime().UnixNano()})
+	}
+
+	for h := range stale {
+		if err := db.RemoveArchive(h); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	pbar := pb.New(len(queue))
+	pbar.SetRefreshRate(time.Second / 10)
+	pbar.Start()
+
+	jobs := make(chan Archive)
+	results := make(chan scanResult)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for a := range jobs {
+				filename := path.Join(dir, a.Name.String())
+
+				old, ok := catalog[a.Name]
+				if ok && old.Size == a.Size && old.ModTime == a.ModTime {
+					if e := known[a.Name]; e == nil || e.MD5 == old.MD5 {
+						results <- scanResult{archive: old}
+						continue
+					}
+				}
+
+				var err error
+				if a.MD5, err = fileMD5(filename); err != nil {
+					results <- scanResult{err: err}
+					continue
+				}
+
+				if ok && old == a {
+					results <- scanResult{archive: a}
+					continue
+				}
+
+				files, err := ScanArchive(filename)
+				if err != nil {
+					err = &os.PathError{Op: "scan", Path: filename, Err: err}
+				}
+				results <- scanResult{a, files, true, err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, a := range queue {
+			jobs <- a
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Scanning happens in parallel, but the catalog is only written to from here
+	for r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+		} else if r.scanned {
+			if err := db.UpdateArchive(&r.archive, r.files); err != nil {
+				errs = append(errs, err)
+			} else {
+				updated++
+			}
+		}
+
+		pbar.Increment()
+	}
+
+	pbar.Finish()
+
+	return
+}
@@ -0,0 +1,161 @@
+package index
+
+import (
+	"os"
+	"bytes"
+	"testing"
+	"crypto/md5"
+	"path/filepath"
+	"aaronlindsay.com/go/pkg/pso2/ice"
+	"aaronlindsay.com/go/pkg/pso2/names"
+	"aaronlindsay.com/go/pkg/pso2/download"
+)
+
+func testDatabase(t *testing.T) *Database {
+	d, err := NewDatabase(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+// Writes an archive of one file to dir, named by the hash of name
+func testArchive(t *testing.T, dir, name, file string, data []uint8) (names.Hash, string) {
+	a := ice.CreateArchive()
+	a.AddFile(1, file, filepath.Ext(file)[1:], bytes.NewReader(data), uint32(len(data)))
+
+	var out bytes.Buffer
+	if err := a.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	h := names.Resolve(name)
+	filename := filepath.Join(dir, h.String())
+	if err := os.WriteFile(filename, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return h, filename
+}
+
+func TestScanArchive(t *testing.T) {
+	h, filename := testArchive(t, t.TempDir(), "a", "a.text", []uint8("contents"))
+
+	files, err := ScanArchive(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := md5.Sum([]uint8("contents"))
+	if len(files) != 1 || files[0] != (File{ h, 1, "a.text", "text", 8, sum }) {
+		t.Errorf("scanned %+v", files)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	d := testDatabase(t)
+	defer d.Close()
+
+	a := Archive{ Name: names.Resolve("a"), Size: 0x100, ModTime: 12345 }
+	a.MD5[0] = 1
+	files := []File{
+		{ Archive: a.Name, Group: 0, Name: "x.text", Type: "text", Size: 4 },
+		{ Archive: a.Name, Group: 1, Name: "y.dds", Type: "dds", Size: 8 },
+	}
+	files[1].MD5[0] = 2
+	if err := d.UpdateArchive(&a, files); err != nil {
+		t.Fatal(err)
+	}
+
+	b := Archive{ Name: names.Resolve("b"), Size: 0x200 }
+	if err := d.UpdateArchive(&b, files[1:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	if read, err := d.QueryArchive(a.Name); err != nil || read == nil || *read != a {
+		t.Errorf("archive %+v, %v", read, err)
+	}
+
+	if archives, err := d.QueryArchives(); err != nil || len(archives) != 2 {
+		t.Errorf("archives %+v, %v", archives, err)
+	}
+
+	if found, err := d.QueryFilesName("*.text"); err != nil || len(found) != 1 || found[0] != files[0] {
+		t.Errorf("name query %+v, %v", found, err)
+	}
+
+	if found, err := d.QueryFilesMD5(files[1].MD5); err != nil || len(found) != 2 || found[0].Archive != a.Name {
+		t.Errorf("md5 query %+v, %v", found, err)
+	}
+
+	// Updating replaces the files rather than adding to them
+	if err := d.UpdateArchive(&a, files[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := d.QueryFilesArchive(a.Name); err != nil || len(found) != 1 {
+		t.Errorf("archive files %+v, %v", found, err)
+	}
+
+	if err := d.RemoveArchive(a.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := d.QueryFilesType("text"); err != nil || len(found) != 0 {
+		t.Errorf("files of a removed archive %+v, %v", found, err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	d := testDatabase(t)
+	defer d.Close()
+
+	dir := t.TempDir()
+	a, filename := testArchive(t, dir, "a", "a.text", []uint8("first"))
+	b, _ := testArchive(t, dir, "b", "b.lua", []uint8("print(1)"))
+
+	if updated, errs := Update(d, dir, nil, 2); updated != 2 || len(errs) != 0 {
+		t.Fatalf("first update: %d, %v", updated, errs)
+	}
+
+	if updated, errs := Update(d, dir, nil, 2); updated != 0 || len(errs) != 0 {
+		t.Errorf("unchanged update: %d, %v", updated, errs)
+	}
+
+	// Same size, but a new modification time, even though the patchlist still lists the old MD5
+	info, _ := os.Stat(filename)
+	old, _ := d.QueryArchive(a)
+	testArchive(t, dir, "a", "a.text", []uint8("other"))
+	if err := os.Chtimes(filename, info.ModTime(), info.ModTime().Add(2e9)); err != nil {
+		t.Fatal(err)
+	}
+
+	patchlist := &download.PatchList{ Entries: []download.PatchEntry{ { Path: "data/win32/" + a.String() + ".pat", Size: old.Size, MD5: old.MD5 } } }
+	if updated, errs := Update(d, dir, patchlist, 2); updated != 1 || len(errs) != 0 {
+		t.Errorf("modified update: %d, %v", updated, errs)
+	}
+
+	if files, err := d.QueryFilesName("a.text"); err != nil || len(files) != 1 || files[0].MD5 == old.MD5 {
+		t.Errorf("modified archive not scanned again: %+v, %v", files, err)
+	}
+
+	// An unchanged file is still hashed if the patchlist disagrees with the catalog
+	testArchive(t, dir, "a", "a.text", []uint8("third"))
+	if err := os.Chtimes(filename, info.ModTime(), info.ModTime().Add(2e9)); err != nil {
+		t.Fatal(err)
+	}
+
+	if updated, errs := Update(d, dir, patchlist, 2); updated != 1 || len(errs) != 0 {
+		t.Errorf("patchlist mismatch update: %d, %v", updated, errs)
+	}
+
+	os.Remove(filename)
+	if updated, errs := Update(d, dir, nil, 2); updated != 0 || len(errs) != 0 {
+		t.Errorf("removal update: %d, %v", updated, errs)
+	}
+
+	if archives, err := d.QueryArchives(); err != nil || len(archives) != 1 || archives[0].Name != b {
+		t.Errorf("archives after removal %+v, %v", archives, err)
+	}
+}
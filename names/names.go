@@ -0,0 +1,184 @@
+package names
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"path"
+	"sort"
+	"bufio"
+	"errors"
+	"strings"
+	"crypto/md5"
+	"encoding/hex"
+)
+
+// Hash is the name of a file in data/win32: the MD5 of its logical path
+type Hash [0x10]uint8
+
+func HashName(name string) Hash {
+	return Hash(md5.Sum([]uint8(name)))
+}
+
+func (h Hash) String() string {
+	return fmt.Sprintf("%x", h[:])
+}
+
+// ParseHash accepts exactly 32 hex digits
+func ParseHash(value string) (h Hash, err error) {
+	if len(value) != len(h) * 2 {
+		return h, errors.New("invalid hash format")
+	}
+
+	if _, err = hex.Decode(h[:], []uint8(value)); err != nil {
+		return h, errors.New("invalid hash format")
+	}
+
+	return
+}
+
+type Dictionary struct {
+	names map[Hash]string
+}
+
+func NewDictionary() *Dictionary {
+	return &Dictionary{ make(map[Hash]string) }
+}
+
+// LoadDictionary reads lines of "hash<tab>name". Lines containing only a name have their hash computed.
+func LoadDictionary(reader io.Reader) (*Dictionary, error) {
+	d := NewDictionary()
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) == 1 {
+			d.Add(fields[0])
+			continue
+		}
+
+		h, err := ParseHash(fields[0])
+		if err != nil {
+			return d, errors.New(line + ": " + err.Error())
+		}
+
+		if h != HashName(fields[1]) {
+			return d, errors.New(line + ": hash does not match name")
+		}
+
+		d.names[h] = fields[1]
+	}
+
+	return d, scanner.Err()
+}
+
+func (d *Dictionary) Write(writer io.Writer) error {
+	names := make([]string, 0, len(d.names))
+	for _, name := range d.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := bufio.NewWriter(writer)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", HashName(name), name)
+	}
+
+	return w.Flush()
+}
+
+func (d *Dictionary) Add(name string) Hash {
+	h := HashName(name)
+	d.names[h] = name
+	return h
+}
+
+func (d *Dictionary) Len() int {
+	return len(d.names)
+}
+
+func (d *Dictionary) Name(h Hash) (name string, ok bool) {
+	if d == nil {
+		return
+	}
+
+	name, ok = d.names[h]
+	return
+}
+
+// Display returns the logical name of a hash if it is known, or the hash itself otherwise
+func (d *Dictionary) Display(h Hash) string {
+	if name, ok := d.Name(h); ok {
+		return name
+	}
+
+	return h.String()
+}
+
+// DisplayString is like Display but for file names that may or may not be hashes
+func (d *Dictionary) DisplayString(value string) string {
+	if h, err := ParseHash(value); err == nil {
+		return d.Display(h)
+	}
+
+	return value
+}
+
+// Resolve accepts either a hex hash or a logical name
+func Resolve(value string) Hash {
+	if h, err := ParseHash(value); err == nil {
+		return h
+	}
+
+	return HashName(value)
+}
+
+// Lookup is like Resolve, but only accepts logical names listed in the dictionary, so that a mistyped name isn't
+// taken for the hash of a file that doesn't exist
+func (d *Dictionary) Lookup(value string) (Hash, error) {
+	if h, err := ParseHash(value); err == nil {
+		return h, nil
+	}
+
+	h := HashName(value)
+	if _, ok := d.Name(h); !ok {
+		return h, fmt.Errorf("unknown archive name `%s`", value)
+	}
+
+	return h, nil
+}
+
+func LoadDictionaryFile(filename string) (d *Dictionary, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+
+	d, err = LoadDictionary(f)
+	f.Close()
+	return
+}
+
+func (d *Dictionary) WriteFile(filename string) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return
+	}
+
+	err = d.Write(f)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+
+	return
+}
+
+// ResolvePath replaces a logical name at the end of a data/win32 path with its hash
+func ResolvePath(p string) string {
+	return path.Join(path.Dir(p), Resolve(path.Base(p)).String())
+}
@@ -0,0 +1,58 @@
+package names
+
+import (
+	"testing"
+)
+
+func TestParseHash(t *testing.T) {
+	h := HashName("test")
+	if parsed, err := ParseHash(h.String()); err != nil || parsed != h {
+		t.Errorf("%s: parsed as %s, %v", h, parsed, err)
+	}
+
+	for _, value := range []string{
+		"",
+		h.String()[:30],
+		h.String() + "00",
+		h.String()[:31] + "g",
+		h.String()[:30] + " 1",
+		"0x" + h.String()[:30],
+	} {
+		if _, err := ParseHash(value); err == nil {
+			t.Errorf("%q parsed as a hash", value)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	h := HashName("test")
+	if r := Resolve(h.String()); r != h {
+		t.Errorf("hash resolved to %s", r)
+	}
+
+	if r := Resolve("test"); r != h {
+		t.Errorf("name resolved to %s", r)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	d := NewDictionary()
+	h := d.Add("test")
+
+	if r, err := d.Lookup("test"); err != nil || r != h {
+		t.Errorf("name looked up as %s, %v", r, err)
+	}
+
+	if r, err := d.Lookup(h.String()); err != nil || r != h {
+		t.Errorf("hash looked up as %s, %v", r, err)
+	}
+
+	if _, err := d.Lookup("tset"); err == nil {
+		t.Error("unknown name accepted")
+	}
+
+	var none *Dictionary
+	if _, err := none.Lookup("test"); err == nil {
+		t.Error("name accepted without a dictionary")
+	}
+}
@@ -0,0 +1,317 @@
+package nifl
+
+import (
+	"io"
+	"fmt"
+	"bytes"
+	"errors"
+	bin "encoding/binary"
+)
+
+// A NIFL file is a header followed by three chunks:
+//
+//	NIFL: the header, locating REL0 and NOF0
+//	REL0: the offset of the root structure, followed by the data. Pointers are offsets from the start of REL0.
+//	NOF0: the offsets of every pointer in REL0, so that they can be relocated when loaded
+//	NEND: marks the end of the file
+//
+// Each chunk is a tag and size followed by its contents, padded out to 0x10 bytes.
+const (
+	headerSize = 0x20
+	chunkHeaderSize = 0x08
+	chunkAlignment = 0x10
+
+	// Where the data starts in REL0, and the lowest offset a pointer can hold
+	DataOffset = 0x10
+)
+
+const (
+	tagNIFL = "NIFL"
+	tagREL0 = "REL0"
+	tagNOF0 = "NOF0"
+	tagNEND = "NEND"
+)
+
+type File struct {
+	Version uint32
+
+	// Offset of the root structure, relative to REL0 like pointers
+	Root uint32
+
+	// The contents of REL0, starting at DataOffset
+	Data []uint8
+
+	// Offsets of the pointers in Data
+	Relocations []uint32
+
+	rel0Unk uint32
+	nof0Extra []uint8
+	niflUnk uint32
+}
+
+type header struct {
+	Tag [4]uint8
+	Size uint32
+	Version uint32
+	OffsetREL0, SizeREL0 uint32
+	OffsetNOF0, SizeNOF0 uint32
+	Unk uint32
+}
+
+type chunkHeader struct {
+	Tag [4]uint8
+	Size uint32
+}
+
+func align(offset uint32) uint32 {
+	return (offset + chunkAlignment - 1) / chunkAlignment * chunkAlignment
+}
+
+func NewFile(reader io.ReadSeeker) (*File, error) {
+	f := &File{ Version: 1 }
+	return f, f.parse(reader)
+}
+
+// readChunk reads the contents of a chunk, which must fit within the length of the stream
+func readChunk(reader io.ReadSeeker, length, offset int64, tag string) (data []uint8, err error) {
+	if _, err = reader.Seek(offset, 0); err != nil {
+		return
+	}
+
+	var h chunkHeader
+	if err = bin.Read(reader, bin.LittleEndian, &h); err != nil {
+		return
+	}
+
+	if string(h.Tag[:]) != tag {
+		return nil, fmt.Errorf("%s tag expected", tag)
+	}
+
+	if int64(h.Size) > length - offset - chunkHeaderSize {
+		return nil, fmt.Errorf("%s chunk size 0x%x out of range", tag, h.Size)
+	}
+
+	data = make([]uint8, h.Size)
+	_, err = io.ReadFull(reader, data)
+	return
+}
+
+func (f *File) parse(reader io.ReadSeeker) (err error) {
+	length, err := reader.Seek(0, 2)
+	if err != nil {
+		return
+	}
+
+	if _, err = reader.Seek(0, 0); err != nil {
+		return
+	}
+
+	var h header
+	if err = bin.Read(reader, bin.LittleEndian, &h); err != nil {
+		return
+	}
+
+	if string(h.Tag[:]) != tagNIFL {
+		return errors.New("NIFL tag expected")
+	}
+
+	if h.Size != headerSize - chunkHeaderSize {
+		return errors.New("NIFL header size mismatch")
+	}
+
+	f.Version, f.niflUnk = h.Version, h.Unk
+
+	rel0, err := readChunk(reader, length, int64(h.OffsetREL0), tagREL0)
+	if err != nil {
+		return
+	}
+
+	if len(rel0) < DataOffset - chunkHeaderSize {
+		return errors.New("REL0 chunk too small")
+	}
+
+	f.Root = bin.LittleEndian.Uint32(rel0)
+	f.rel0Unk = bin.LittleEndian.Uint32(rel0[4:])
+	f.Data = rel0[DataOffset - chunkHeaderSize:]
+
+	nof0, err := readChunk(reader, length, int64(h.OffsetNOF0), tagNOF0)
+	if err != nil {
+		return
+	}
+
+	if len(nof0) < 4 {
+		return errors.New("NOF0 chunk too small")
+	}
+
+	count := bin.LittleEndian.Uint32(nof0)
+	if uint64(count) * 4 > uint64(len(nof0) - 4) {
+		return errors.New("NOF0 count out of range")
+	}
+
+	f.Relocations = make([]uint32, count)
+	for i := range f.Relocations {
+		offset := bin.LittleEndian.Uint32(nof0[4 + i * 4:])
+		if !f.pointerInRange(offset) {
+			return fmt.Errorf("NOF0 relocation 0x%x out of range", offset)
+		}
+		f.Relocations[i] = offset
+	}
+	f.nof0Extra = nof0[4 + count * 4:]
+
+	_, err = readChunk(reader, length, int64(h.OffsetNOF0) + int64(align(chunkHeaderSize + uint32(len(nof0)))), tagNEND)
+	return
+}
+
+// The size of REL0, and so the limit of any offset into it
+func (f *File) size() uint32 {
+	return uint32(DataOffset + len(f.Data))
+}
+
+// Whether a whole pointer fits at offset
+func (f *File) pointerInRange(offset uint32) bool {
+	return offset >= DataOffset && uint64(offset) + 4 <= uint64(f.size())
+}
+
+func writeChunk(writer io.Writer, tag string, data ...interface{}) error {
+	var buffer bytes.Buffer
+	for _, v := range data {
+		bin.Write(&buffer, bin.LittleEndian, v)
+	}
+
+	h := chunkHeader{ Size: uint32(buffer.Len()) }
+	copy(h.Tag[:], tag)
+
+	if err := bin.Write(writer, bin.LittleEndian, &h); err != nil {
+		return err
+	}
+
+	buffer.Write(make([]uint8, align(chunkHeaderSize + h.Size) - chunkHeaderSize - h.Size))
+	_, err := buffer.WriteTo(writer)
+	return err
+}
+
+// Write encodes the file, laying out the chunks around the current data and relocations
+func (f *File) Write(writer io.Writer) (err error) {
+	for _, offset := range f.Relocations {
+		if !f.pointerInRange(offset) {
+			return fmt.Errorf("relocation 0x%x out of range", offset)
+		}
+	}
+
+	sizeREL0 := align(f.size())
+	sizeNOF0 := align(chunkHeaderSize + uint32(4 + len(f.Relocations) * 4 + len(f.nof0Extra)))
+
+	h := header{
+		Size: headerSize - chunkHeaderSize,
+		Version: f.Version,
+		OffsetREL0: headerSize,
+		SizeREL0: sizeREL0,
+		OffsetNOF0: headerSize + sizeREL0,
+		SizeNOF0: sizeNOF0,
+		Unk: f.niflUnk,
+	}
+	copy(h.Tag[:], tagNIFL)
+
+	if err = bin.Write(writer, bin.LittleEndian, &h); err != nil {
+		return
+	}
+
+	if err = writeChunk(writer, tagREL0, f.Root, f.rel0Unk, f.Data); err != nil {
+		return
+	}
+
+	if err = writeChunk(writer, tagNOF0, uint32(len(f.Relocations)), f.Relocations, f.nof0Extra); err != nil {
+		return
+	}
+
+	return writeChunk(writer, tagNEND, make([]uint8, chunkHeaderSize))
+}
+
+// ReadAt reads from REL0, using the same offsets as pointers
+func (f *File) ReadAt(p []uint8, offset int64) (n int, err error) {
+	if offset < DataOffset {
+		return 0, fmt.Errorf("offset 0x%x is before the start of the data", offset)
+	}
+
+	return bytes.NewReader(f.Data).ReadAt(p, offset - DataOffset)
+}
+
+// Reader returns the data, seeked to an offset
+func (f *File) Reader(offset uint32) (io.ReadSeeker, error) {
+	if offset < DataOffset || offset > f.size() {
+		return nil, fmt.Errorf("offset 0x%x out of range", offset)
+	}
+
+	return io.NewSectionReader(f, int64(offset), int64(f.size() - offset)), nil
+}
+
+func (f *File) Uint32(offset uint32) (uint32, error) {
+	var value [4]uint8
+	if _, err := f.ReadAt(value[:], int64(offset)); err != nil {
+		return 0, err
+	}
+
+	return bin.LittleEndian.Uint32(value[:]), nil
+}
+
+// IsPointer reports whether NOF0 lists the value at offset as a pointer
+func (f *File) IsPointer(offset uint32) bool {
+	for _, r := range f.Relocations {
+		if r == offset {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Pointer reads the pointer at offset, failing if it is not relocated or points outside of the data. A null
+// pointer is returned as 0.
+func (f *File) Pointer(offset uint32) (uint32, error) {
+	value, err := f.Uint32(offset)
+	if err != nil {
+		return 0, err
+	}
+
+	if value == 0 {
+		return 0, nil
+	}
+
+	if !f.IsPointer(offset) {
+		return 0, fmt.Errorf("value at 0x%x is not a pointer", offset)
+	}
+
+	if value < DataOffset || value > f.size() {
+		return 0, fmt.Errorf("pointer at 0x%x out of range (0x%x)", offset, value)
+	}
+
+	return value, nil
+}
+
+// CString reads a null terminated string of single byte characters
+func (f *File) CString(offset uint32) (string, error) {
+	if offset < DataOffset || offset >= f.size() {
+		return "", fmt.Errorf("string offset 0x%x out of range", offset)
+	}
+
+	data := f.Data[offset - DataOffset:]
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return string(data[:i]), nil
+	}
+
+	return "", io.ErrUnexpectedEOF
+}
+
+// ReadUint32s returns the values of a block of data, for callers that want to handle pointers themselves
+func (f *File) ReadUint32s(offset, count uint32) ([]uint32, error) {
+	if offset < DataOffset || uint64(offset) + uint64(count) * 4 > uint64(f.size()) {
+		return nil, fmt.Errorf("block 0x%x (0x%x values) out of range", offset, count)
+	}
+
+	values := make([]uint32, count)
+	for i := range values {
+		values[i] = bin.LittleEndian.Uint32(f.Data[offset - DataOffset + uint32(i) * 4:])
+	}
+
+	return values, nil
+}
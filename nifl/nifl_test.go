@@ -0,0 +1,128 @@
+package nifl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	bin "encoding/binary"
+)
+
+type testChunk struct {
+	tag string
+	data []uint8
+}
+
+func (c testChunk) bytes() []uint8 {
+	var buffer bytes.Buffer
+	buffer.WriteString(c.tag)
+	bin.Write(&buffer, bin.LittleEndian, uint32(len(c.data)))
+	buffer.Write(c.data)
+	buffer.Write(make([]uint8, int(align(uint32(buffer.Len()))) - buffer.Len()))
+	return buffer.Bytes()
+}
+
+func testUint32s(values ...uint32) []uint8 {
+	var buffer bytes.Buffer
+	bin.Write(&buffer, bin.LittleEndian, values)
+	return buffer.Bytes()
+}
+
+// Lays out a file by hand, with unknown fields set so that their preservation can be checked
+func testFile(relocations []uint32, nend bool) []uint8 {
+	data := []uint8("\x20\x00\x00\x00\x00\x00\x00\x00root\x00\x00\x00\x00\x01\x02\x03\x04")
+
+	rel0 := testChunk{ tagREL0, append(testUint32s(0x10, 0xcafe), data...) }.bytes()
+	nof0 := testChunk{ tagNOF0, append(testUint32s(append([]uint32{ uint32(len(relocations)) }, relocations...)...), 0xaa, 0xbb) }.bytes()
+
+	var buffer bytes.Buffer
+	bin.Write(&buffer, bin.LittleEndian, &header{
+		Tag: [4]uint8{ 'N', 'I', 'F', 'L' },
+		Size: headerSize - chunkHeaderSize,
+		Version: 1,
+		OffsetREL0: headerSize,
+		SizeREL0: uint32(len(rel0)),
+		OffsetNOF0: headerSize + uint32(len(rel0)),
+		SizeNOF0: uint32(len(nof0)),
+		Unk: 0x1234,
+	})
+	buffer.Write(rel0)
+	buffer.Write(nof0)
+
+	if nend {
+		buffer.Write(testChunk{ tagNEND, make([]uint8, chunkHeaderSize) }.bytes())
+	}
+
+	return buffer.Bytes()
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	original := testFile([]uint32{ 0x10 }, true)
+
+	f, err := NewFile(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.rel0Unk != 0xcafe || f.niflUnk != 0x1234 || !bytes.Equal(f.nof0Extra, []uint8{ 0xaa, 0xbb }) {
+		t.Errorf("unknown fields not kept: 0x%x, 0x%x, %x", f.rel0Unk, f.niflUnk, f.nof0Extra)
+	}
+
+	if p, err := f.Pointer(0x10); err != nil || p != 0x20 {
+		t.Errorf("pointer 0x%x, %v", p, err)
+	}
+
+	var out bytes.Buffer
+	if err := f.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), original) {
+		t.Errorf("written file differs:\n%x\n%x", out.Bytes(), original)
+	}
+}
+
+func TestRelocationRange(t *testing.T) {
+	// The data ends at 0x24, so 0x20 is the last place a whole pointer fits
+	for _, offset := range []uint32{ 0, 0xc, 0x21, 0x24, 0xfffffffc, 0xffffffff } {
+		if _, err := NewFile(bytes.NewReader(testFile([]uint32{ offset }, true))); err == nil {
+			t.Errorf("parse accepted relocation 0x%x", offset)
+		}
+
+		f, err := NewFile(bytes.NewReader(testFile(nil, true)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f.Relocations = []uint32{ offset }
+		if err := f.Write(&bytes.Buffer{}); err == nil {
+			t.Errorf("write accepted relocation 0x%x", offset)
+		}
+	}
+
+	if _, err := NewFile(bytes.NewReader(testFile([]uint32{ 0x20 }, true))); err != nil {
+		t.Errorf("last pointer: %v", err)
+	}
+}
+
+func TestMissingNEND(t *testing.T) {
+	if _, err := NewFile(bytes.NewReader(testFile(nil, false))); err == nil {
+		t.Error("file without NEND accepted")
+	}
+
+	data := testFile(nil, true)
+	copy(data[len(data) - 0x10:], "NENX")
+	if _, err := NewFile(bytes.NewReader(data)); err == nil {
+		t.Error("bad NEND tag accepted")
+	}
+}
+
+// Chunk sizes are checked against the file before anything is allocated for them
+func TestChunkSize(t *testing.T) {
+	for _, size := range []uint32{ 0xffffffff, 0x7ffffff0, 0x1000 } {
+		data := testFile(nil, true)
+		bin.LittleEndian.PutUint32(data[headerSize + 4:], size)
+		if _, err := NewFile(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("REL0 size 0x%x: %v", size, err)
+		}
+	}
+}
@@ -0,0 +1,593 @@
+package nifl
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"errors"
+	"reflect"
+	"strings"
+	"strconv"
+	"unicode/utf16"
+	bin "encoding/binary"
+)
+
+// Structures in REL0 can be described with Go structs and read with Decode. Fields are laid out with the natural
+// alignment of a 32-bit C compiler, and are interpreted by type:
+//
+//	integers, float32, float64, arrays and structs are stored inline
+//	Pointer is an untyped pointer, kept as its offset, and has to be null to be encoded
+//	*T points to a T, and is nil for a null pointer; pointers to the same T decode to the same value
+//	string points to a null terminated string, of bytes or, with `nifl:"utf16"`, UTF-16 code units
+//	[]T points to a list of T, sized by another integer field of the struct named with `nifl:"count=Field"`
+//
+// Fields tagged `nifl:"-"` are ignored, as are unexported fields.
+type Pointer uint32
+
+var pointerType = reflect.TypeOf(Pointer(0))
+
+type fieldOptions struct {
+	skip bool
+	utf16 bool
+	count string
+}
+
+func parseOptions(tag reflect.StructTag) (o fieldOptions) {
+	for _, option := range strings.Split(tag.Get("nifl"), ",") {
+		switch {
+			case option == "-":
+				o.skip = true
+			case option == "utf16":
+				o.utf16 = true
+			case strings.HasPrefix(option, "count="):
+				o.count = strings.TrimPrefix(option, "count=")
+		}
+	}
+
+	return
+}
+
+// Unexported fields can't be set through reflection, so they are always skipped
+func fieldOptionsOf(field reflect.StructField) fieldOptions {
+	o := parseOptions(field.Tag)
+	if field.PkgPath != "" {
+		o.skip = true
+	}
+
+	return o
+}
+
+func alignTo(offset, alignment uint32) uint32 {
+	return (offset + alignment - 1) / alignment * alignment
+}
+
+// typeLayout returns the size and alignment of a type
+func typeLayout(t reflect.Type) (size, alignment uint32, err error) {
+	if t == pointerType {
+		return 4, 4, nil
+	}
+
+	switch t.Kind() {
+		case reflect.Uint8, reflect.Int8:
+			return 1, 1, nil
+		case reflect.Uint16, reflect.Int16:
+			return 2, 2, nil
+		case reflect.Uint32, reflect.Int32, reflect.Float32:
+			return 4, 4, nil
+		case reflect.Uint64, reflect.Int64, reflect.Float64:
+			return 8, 8, nil
+		case reflect.Ptr, reflect.String, reflect.Slice:
+			return 4, 4, nil
+
+		case reflect.Array:
+			if size, alignment, err = typeLayout(t.Elem()); err != nil {
+				return
+			}
+			return size * uint32(t.Len()), alignment, nil
+
+		case reflect.Struct:
+			alignment = 1
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				if fieldOptionsOf(field).skip {
+					continue
+				}
+
+				fieldSize, fieldAlignment, err := typeLayout(field.Type)
+				if err != nil {
+					return 0, 0, fmt.Errorf("%s.%s: %v", t.Name(), field.Name, err)
+				}
+
+				size = alignTo(size, fieldAlignment) + fieldSize
+				if fieldAlignment > alignment {
+					alignment = fieldAlignment
+				}
+			}
+			return alignTo(size, alignment), alignment, nil
+	}
+
+	return 0, 0, fmt.Errorf("unsupported type %s", t)
+}
+
+// Field offsets within a struct, with skipped fields left out
+func structOffsets(t reflect.Type) (offsets map[int]uint32) {
+	offsets = make(map[int]uint32)
+
+	offset := uint32(0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if fieldOptionsOf(field).skip {
+			continue
+		}
+
+		size, alignment, _ := typeLayout(field.Type)
+		offset = alignTo(offset, alignment)
+		offsets[i] = offset
+		offset += size
+	}
+
+	return
+}
+
+type decodedPointer struct {
+	offset uint32
+	t reflect.Type
+}
+
+type decoder struct {
+	f *File
+	pointers map[uint32]bool
+	values map[decodedPointer]reflect.Value
+}
+
+func (d *decoder) bytes(offset, size uint32) ([]uint8, error) {
+	if offset < DataOffset || uint64(offset) + uint64(size) > uint64(d.f.size()) {
+		return nil, fmt.Errorf("0x%x bytes at 0x%x out of range", size, offset)
+	}
+
+	return d.f.Data[offset - DataOffset:offset - DataOffset + size], nil
+}
+
+func (d *decoder) pointer(offset uint32) (uint32, error) {
+	data, err := d.bytes(offset, 4)
+	if err != nil {
+		return 0, err
+	}
+
+	value := bin.LittleEndian.Uint32(data)
+	if value == 0 {
+		return 0, nil
+	}
+
+	if !d.pointers[offset] {
+		return 0, fmt.Errorf("value at 0x%x is not a pointer", offset)
+	}
+
+	if value < DataOffset || value >= d.f.size() {
+		return 0, fmt.Errorf("pointer at 0x%x out of range (0x%x)", offset, value)
+	}
+
+	return value, nil
+}
+
+func (d *decoder) utf16(offset uint32) (string, error) {
+	var units []uint16
+	for ; ; offset += 2 {
+		data, err := d.bytes(offset, 2)
+		if err != nil {
+			return "", err
+		}
+
+		unit := bin.LittleEndian.Uint16(data)
+		if unit == 0 {
+			break
+		}
+		units = append(units, unit)
+	}
+
+	return string(utf16.Decode(units)), nil
+}
+
+func (d *decoder) decode(offset uint32, v reflect.Value, o fieldOptions) (err error) {
+	if v.Type() == pointerType {
+		p, err := d.pointer(offset)
+		v.SetUint(uint64(p))
+		return err
+	}
+
+	switch v.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+			size, _, _ := typeLayout(v.Type())
+			data, err := d.bytes(offset, size)
+			if err != nil {
+				return err
+			}
+
+			var bits uint64
+			for i := int(size) - 1; i >= 0; i-- {
+				bits = bits << 8 | uint64(data[i])
+			}
+
+			switch v.Kind() {
+				case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+					v.SetUint(bits)
+				case reflect.Int8:
+					v.SetInt(int64(int8(bits)))
+				case reflect.Int16:
+					v.SetInt(int64(int16(bits)))
+				case reflect.Int32:
+					v.SetInt(int64(int32(bits)))
+				case reflect.Int64:
+					v.SetInt(int64(bits))
+				case reflect.Float32:
+					v.SetFloat(float64(math.Float32frombits(uint32(bits))))
+				case reflect.Float64:
+					v.SetFloat(math.Float64frombits(bits))
+			}
+
+		case reflect.Array:
+			size, _, _ := typeLayout(v.Type().Elem())
+			for i := 0; i < v.Len(); i++ {
+				if err = d.decode(offset + uint32(i) * size, v.Index(i), fieldOptions{}); err != nil {
+					return
+				}
+			}
+
+		case reflect.Struct:
+			return d.decodeStruct(offset, v)
+
+		case reflect.Ptr:
+			p, err := d.pointer(offset)
+			if err != nil || p == 0 {
+				return err
+			}
+
+			// Values are recorded before they are read, so cycles end at the first repeated pointer
+			key := decodedPointer{ p, v.Type() }
+			if value, ok := d.values[key]; ok {
+				v.Set(value)
+				return nil
+			}
+
+			value := reflect.New(v.Type().Elem())
+			d.values[key] = value
+			v.Set(value)
+			return d.decode(p, value.Elem(), fieldOptions{})
+
+		case reflect.String:
+			p, err := d.pointer(offset)
+			if err != nil || p == 0 {
+				return err
+			}
+
+			var s string
+			if o.utf16 {
+				s, err = d.utf16(p)
+			} else {
+				s, err = d.f.CString(p)
+			}
+			v.SetString(s)
+			return err
+
+		default:
+			return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return
+}
+
+func countValue(v reflect.Value) (int, error) {
+	switch v.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return int(v.Uint()), nil
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.Int() < 0 {
+				return 0, errors.New("negative count")
+			}
+			return int(v.Int()), nil
+	}
+
+	return 0, fmt.Errorf("count field of type %s", v.Type())
+}
+
+func (d *decoder) decodeStruct(offset uint32, v reflect.Value) error {
+	t := v.Type()
+	offsets := structOffsets(t)
+
+	// Slices are read last, once their counts are known
+	var slices []int
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		o := fieldOptionsOf(field)
+		if o.skip {
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Slice {
+			slices = append(slices, i)
+			continue
+		}
+
+		if err := d.decode(offset + offsets[i], v.Field(i), o); err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), field.Name, err)
+		}
+	}
+
+	for _, i := range slices {
+		field := t.Field(i)
+		o := fieldOptionsOf(field)
+
+		countField := v.FieldByName(o.count)
+		if !countField.IsValid() {
+			return fmt.Errorf("%s.%s: count field `%s` not found", t.Name(), field.Name, o.count)
+		}
+
+		count, err := countValue(countField)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), field.Name, err)
+		}
+
+		p, err := d.pointer(offset + offsets[i])
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), field.Name, err)
+		}
+
+		if p == 0 || count == 0 {
+			continue
+		}
+
+		size, _, _ := typeLayout(field.Type.Elem())
+		if uint64(p) + uint64(count) * uint64(size) > uint64(d.f.size()) {
+			return fmt.Errorf("%s.%s: %d elements at 0x%x out of range", t.Name(), field.Name, count, p)
+		}
+
+		slice := reflect.MakeSlice(field.Type, count, count)
+		for e := 0; e < count; e++ {
+			if err = d.decode(p + uint32(e) * size, slice.Index(e), fieldOptions{}); err != nil {
+				return fmt.Errorf("%s.%s[%d]: %v", t.Name(), field.Name, e, err)
+			}
+		}
+		v.Field(i).Set(slice)
+	}
+
+	return nil
+}
+
+func checkTarget(v interface{}) (reflect.Value, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return value, errors.New("decode target must be a non-nil pointer")
+	}
+
+	if _, _, err := typeLayout(value.Elem().Type()); err != nil {
+		return value, err
+	}
+
+	return value.Elem(), nil
+}
+
+// DecodeAt reads the structure at an offset into the value v points to
+func (f *File) DecodeAt(offset uint32, v interface{}) error {
+	value, err := checkTarget(v)
+	if err != nil {
+		return err
+	}
+
+	d := &decoder{ f: f, pointers: make(map[uint32]bool), values: make(map[decodedPointer]reflect.Value) }
+	for _, r := range f.Relocations {
+		d.pointers[r] = true
+	}
+
+	return d.decode(offset, value, fieldOptions{})
+}
+
+// Decode reads the root structure into the value v points to
+func (f *File) Decode(v interface{}) error {
+	return f.DecodeAt(f.Root, v)
+}
+
+type encodedPointer struct {
+	address uintptr
+	t reflect.Type
+}
+
+type encoder struct {
+	data []uint8
+	relocations []uint32
+	strings map[string]uint32
+	values map[encodedPointer]uint32
+}
+
+// Reserves zeroed space at the end of the data, returning its offset
+func (e *encoder) alloc(size, alignment uint32) uint32 {
+	if alignment < 4 {
+		alignment = 4
+	}
+
+	offset := alignTo(uint32(len(e.data)), alignment)
+	e.data = append(e.data, make([]uint8, offset + size - uint32(len(e.data)))...)
+	return DataOffset + offset
+}
+
+func (e *encoder) put(offset uint32, bits uint64, size uint32) {
+	for i := uint32(0); i < size; i++ {
+		e.data[offset - DataOffset + i] = uint8(bits >> (i * 8))
+	}
+}
+
+func (e *encoder) putPointer(offset, target uint32) {
+	e.put(offset, uint64(target), 4)
+	if target != 0 {
+		e.relocations = append(e.relocations, offset)
+	}
+}
+
+func (e *encoder) string(s string, wide bool) uint32 {
+	key := strconv.FormatBool(wide) + s
+	if offset, ok := e.strings[key]; ok {
+		return offset
+	}
+
+	var offset uint32
+	if wide {
+		units := utf16.Encode([]rune(s))
+		offset = e.alloc(uint32(len(units) + 1) * 2, 4)
+		for i, unit := range units {
+			e.put(offset + uint32(i) * 2, uint64(unit), 2)
+		}
+	} else {
+		offset = e.alloc(uint32(len(s) + 1), 4)
+		copy(e.data[offset - DataOffset:], s)
+	}
+
+	e.strings[key] = offset
+	return offset
+}
+
+func (e *encoder) encode(offset uint32, v reflect.Value, o fieldOptions, count int) error {
+	if v.Type() == pointerType {
+		// Untyped pointers can't follow the data they point to into the new layout
+		if v.Uint() != 0 {
+			return fmt.Errorf("untyped pointer 0x%x can't be encoded", v.Uint())
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			size, _, _ := typeLayout(v.Type())
+			value := v.Uint()
+			if count >= 0 {
+				value = uint64(count)
+			}
+			e.put(offset, value, size)
+
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			size, _, _ := typeLayout(v.Type())
+			value := v.Int()
+			if count >= 0 {
+				value = int64(count)
+			}
+			e.put(offset, uint64(value), size)
+
+		case reflect.Float32:
+			e.put(offset, uint64(math.Float32bits(float32(v.Float()))), 4)
+
+		case reflect.Float64:
+			e.put(offset, math.Float64bits(v.Float()), 8)
+
+		case reflect.Array:
+			size, _, _ := typeLayout(v.Type().Elem())
+			for i := 0; i < v.Len(); i++ {
+				if err := e.encode(offset + uint32(i) * size, v.Index(i), fieldOptions{}, -1); err != nil {
+					return err
+				}
+			}
+
+		case reflect.Struct:
+			return e.encodeStruct(offset, v)
+
+		case reflect.Ptr:
+			if v.IsNil() {
+				return nil
+			}
+
+			key := encodedPointer{ v.Pointer(), v.Type() }
+			if target, ok := e.values[key]; ok {
+				e.putPointer(offset, target)
+				return nil
+			}
+
+			size, alignment, _ := typeLayout(v.Type().Elem())
+			target := e.alloc(size, alignment)
+			e.values[key] = target
+			e.putPointer(offset, target)
+			return e.encode(target, v.Elem(), fieldOptions{}, -1)
+
+		case reflect.String:
+			e.putPointer(offset, e.string(v.String(), o.utf16))
+
+		case reflect.Slice:
+			if v.Len() == 0 {
+				return nil
+			}
+
+			size, alignment, _ := typeLayout(v.Type().Elem())
+			target := e.alloc(size * uint32(v.Len()), alignment)
+			e.putPointer(offset, target)
+			for i := 0; i < v.Len(); i++ {
+				if err := e.encode(target + uint32(i) * size, v.Index(i), fieldOptions{}, -1); err != nil {
+					return err
+				}
+			}
+
+		default:
+			return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+func (e *encoder) encodeStruct(offset uint32, v reflect.Value) error {
+	t := v.Type()
+	offsets := structOffsets(t)
+
+	// Count fields are written from the length of their slices
+	counts := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		if o := fieldOptionsOf(t.Field(i)); !o.skip && o.count != "" {
+			counts[o.count] = v.Field(i).Len()
+		}
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		o := fieldOptionsOf(field)
+		if o.skip {
+			continue
+		}
+
+		count, ok := counts[field.Name]
+		if !ok {
+			count = -1
+		}
+
+		if err := e.encode(offset + offsets[i], v.Field(i), o, count); err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Encode replaces the file's data with a new layout of v as the root structure, rebuilding the NOF0 relocations.
+// Strings and values shared through pointers are written once. Slices are written once for every field holding
+// them, and untyped Pointer fields have to be null since the data they point to is not kept.
+func (f *File) Encode(v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	size, alignment, err := typeLayout(value.Type())
+	if err != nil {
+		return err
+	}
+
+	e := &encoder{ strings: make(map[string]uint32), values: make(map[encodedPointer]uint32) }
+	root := e.alloc(size, alignment)
+	if err = e.encode(root, value, fieldOptions{}, -1); err != nil {
+		return err
+	}
+
+	sort.Slice(e.relocations, func(i, j int) bool { return e.relocations[i] < e.relocations[j] })
+
+	f.Root, f.Data, f.Relocations = root, e.data, e.relocations
+	return nil
+}
+
+// Encode lays out v as the root structure of a new file
+func Encode(v interface{}) (*File, error) {
+	f := &File{ Version: 1 }
+	return f, f.Encode(v)
+}
@@ -0,0 +1,163 @@
+package nifl
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type unexportedTest struct {
+	A uint32
+	hidden uint16
+	B uint8
+	cache []uint32
+}
+
+func TestUnexportedFields(t *testing.T) {
+	size, _, err := typeLayout(reflect.TypeOf(unexportedTest{}))
+	if err != nil || size != 8 {
+		t.Errorf("size 0x%x, %v", size, err)
+	}
+
+	f := &File{}
+	if err := f.Encode(&unexportedTest{ A: 1, hidden: 2, B: 3, cache: []uint32{ 4 } }); err != nil {
+		t.Fatal(err)
+	}
+
+	var v unexportedTest
+	if err := f.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.A != 1 || v.B != 3 || v.hidden != 0 || v.cache != nil {
+		t.Errorf("decoded %+v", v)
+	}
+}
+
+type schemaItem struct {
+	ID uint16
+	Flag uint8
+	Name string
+	Label string `nifl:"utf16"`
+	Pos [3]float32
+	Next *schemaItem
+}
+
+type schemaRoot struct {
+	Count uint32
+	Items []schemaItem `nifl:"count=Count"`
+	Child *schemaItem
+	Other *schemaItem
+	Raw Pointer
+	Tail uint8
+}
+
+// schemaRoot with its pointers left untyped
+type schemaRawRoot struct {
+	Count uint32
+	Items, Child, Other, Raw Pointer
+	Tail uint8
+}
+
+type schemaMissingCount struct {
+	Count uint32
+	Items []schemaItem `nifl:"count=Total"`
+}
+
+func testSchemaRoot() *schemaRoot {
+	child := &schemaItem{ ID: 9, Name: "child", Label: "日本語😀" }
+	child.Next = child
+
+	return &schemaRoot{
+		Count: 2,
+		Items: []schemaItem{
+			{ 1, 2, "a", "wide", [3]float32{ 1, 2, 3 }, nil },
+			{ 4, 5, "a", "", [3]float32{}, child },
+		},
+		Child: child,
+		Other: child,
+		Tail: 0x7f,
+	}
+}
+
+// Encodes v and reads the file back through Write and NewFile
+func encodeSchema(t *testing.T, v interface{}) *File {
+	f, err := Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	if err := f.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = NewFile(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestSchemaRoundTrip(t *testing.T) {
+	r := testSchemaRoot()
+	f := encodeSchema(t, r)
+
+	var out schemaRoot
+	if err := f.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(r, &out) {
+		t.Errorf("decoded %+v, expected %+v", out, *r)
+	}
+
+	// Pointers to the same value decode to the same value, cycles included
+	if out.Child != out.Other || out.Child.Next != out.Child || out.Items[1].Next != out.Child {
+		t.Error("shared pointers decoded separately")
+	}
+
+	var raw schemaRawRoot
+	if err := f.Decode(&raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if raw.Child == 0 || raw.Child != raw.Other || raw.Raw != 0 || raw.Tail != 0x7f {
+		t.Errorf("untyped pointers %+v", raw)
+	}
+
+	var child schemaItem
+	if err := f.DecodeAt(uint32(raw.Child), &child); err != nil || child.Name != "child" {
+		t.Errorf("decoded %+v at 0x%x, %v", child, raw.Child, err)
+	}
+}
+
+func TestEncodeUntypedPointer(t *testing.T) {
+	if _, err := Encode(&schemaRawRoot{ Raw: 0x40 }); err == nil {
+		t.Error("non-null untyped pointer encoded")
+	}
+}
+
+func TestSchemaErrors(t *testing.T) {
+	f := encodeSchema(t, testSchemaRoot())
+
+	var out schemaRoot
+	var missing schemaMissingCount
+	if err := f.Decode(&missing); err == nil {
+		t.Error("missing count field accepted")
+	}
+
+	count := f.Data[f.Root - DataOffset:]
+	count[0], count[1] = 0xff, 0xff
+	if err := f.Decode(&out); err == nil {
+		t.Error("count out of range accepted")
+	}
+	count[0], count[1] = 2, 0
+
+	// A value not listed in NOF0 is not followed
+	f.Relocations = f.Relocations[1:]
+	if err := f.Decode(&out); err == nil {
+		t.Error("unrelocated pointer followed")
+	}
+}
@@ -0,0 +1,67 @@
+package text
+
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeChanged
+	ChangeRemoved
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+		case ChangeAdded:
+			return "added"
+		case ChangeChanged:
+			return "changed"
+		case ChangeRemoved:
+			return "removed"
+	}
+
+	return "unknown"
+}
+
+// A Change is a difference in one pair between two versions of a file. Old is empty for added pairs, and New
+// for removed ones.
+type Change struct {
+	Kind ChangeKind
+	Identifier string
+	Collision int
+	Old, New string
+}
+
+type recordKey struct {
+	identifier string
+	collision int
+}
+
+// Diff compares the records of two versions of a file, matching pairs by identifier and collision like the
+// trans database does. Changes and removals are listed in the old file's order, followed by additions in the
+// new file's order.
+func Diff(old, new []Record) (changes []Change) {
+	newRecords := make(map[recordKey]*Record)
+	for i := range new {
+		r := &new[i]
+		newRecords[recordKey{ r.Identifier, r.Collision }] = r
+	}
+
+	oldRecords := make(map[recordKey]bool)
+	for _, r := range old {
+		key := recordKey{ r.Identifier, r.Collision }
+		oldRecords[key] = true
+
+		if n, ok := newRecords[key]; !ok {
+			changes = append(changes, Change{ ChangeRemoved, r.Identifier, r.Collision, r.String, "" })
+		} else if n.String != r.String {
+			changes = append(changes, Change{ ChangeChanged, r.Identifier, r.Collision, r.String, n.String })
+		}
+	}
+
+	for _, r := range new {
+		if !oldRecords[recordKey{ r.Identifier, r.Collision }] {
+			changes = append(changes, Change{ ChangeAdded, r.Identifier, r.Collision, "", r.String })
+		}
+	}
+
+	return
+}
@@ -0,0 +1,47 @@
+package text
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := []Record{
+		{ Identifier: "a", String: "same" },
+		{ Identifier: "b", String: "first" },
+		{ Identifier: "b", Collision: 1, String: "second" },
+		{ Identifier: "c", String: "removed" },
+		{ Identifier: "d", Collision: 1, String: "removed collision" },
+		{ Identifier: "d", String: "kept" },
+	}
+
+	new := []Record{
+		{ Identifier: "e", String: "added" },
+		{ Identifier: "d", String: "kept" },
+		{ Identifier: "b", Collision: 1, String: "second, changed" },
+		{ Identifier: "b", String: "first" },
+		{ Identifier: "a", String: "same" },
+		{ Identifier: "b", Collision: 2, String: "added collision" },
+	}
+
+	// Collisions are matched by index, so reordering pairs isn't a change
+	expected := []Change{
+		{ ChangeChanged, "b", 1, "second", "second, changed" },
+		{ ChangeRemoved, "c", 0, "removed", "" },
+		{ ChangeRemoved, "d", 1, "removed collision", "" },
+		{ ChangeAdded, "e", 0, "", "added" },
+		{ ChangeAdded, "b", 2, "", "added collision" },
+	}
+
+	if changes := Diff(old, new); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes\n%+v\nexpected\n%+v", changes, expected)
+	}
+
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("unchanged records gave %+v", changes)
+	}
+
+	if changes := Diff(nil, old[:1]); len(changes) != 1 || changes[0].Kind != ChangeAdded || changes[0].Kind.String() != "added" {
+		t.Errorf("new file gave %+v", changes)
+	}
+}
@@ -0,0 +1,306 @@
+package text
+
+import (
+	"io"
+	"fmt"
+	"bufio"
+	"strconv"
+	"strings"
+	"encoding/csv"
+	"encoding/json"
+)
+
+// A Record is one identifier/string pair, in a form that can be exported and edited outside of a TextFile.
+// Identifiers may repeat within a file, so Collision counts the earlier pairs with the same identifier.
+type Record struct {
+	Identifier string `json:"identifier"`
+	Collision int `json:"collision"`
+	String string `json:"string"`
+
+	// Entry indices of the identifier and string, for reference
+	IdentifierEntry int `json:"identifier_entry"`
+	StringEntry int `json:"string_entry"`
+}
+
+// Export formats
+const (
+	FormatCSV = "csv"
+	FormatJSON = "json"
+	FormatPO = "po"
+)
+
+var csvHeader = []string{ "identifier", "collision", "string", "identifier_entry", "string_entry" }
+
+func (t *TextFile) Records() (records []Record) {
+	collisions := make(map[string]int)
+
+	for _, p := range t.Pairs {
+		collision := collisions[p.Identifier]
+		collisions[p.Identifier] = collision + 1
+
+		records = append(records, Record{ p.Identifier, collision, p.String, p.identifierIndex, p.stringIndex })
+	}
+
+	return
+}
+
+// Apply updates the strings of pairs matching each record's identifier and collision, returning how many changed
+func (t *TextFile) Apply(records []Record) (changed int, err error) {
+	pairs := make(map[recordKey]*TextPair)
+	collisions := make(map[string]int)
+	for i := range t.Pairs {
+		p := &t.Pairs[i]
+		collision := collisions[p.Identifier]
+		collisions[p.Identifier] = collision + 1
+		pairs[recordKey{ p.Identifier, collision }] = p
+	}
+
+	for _, r := range records {
+		p, ok := pairs[recordKey{ r.Identifier, r.Collision }]
+		if !ok {
+			return changed, fmt.Errorf("identifier `%s` (collision %d) not found", r.Identifier, r.Collision)
+		}
+
+		if p.String != r.String {
+			p.String = r.String
+			t.PairString(p).Text = r.String
+			changed++
+		}
+	}
+
+	return
+}
+
+// FormatFromPath guesses an export format from a file extension
+func FormatFromPath(p string) string {
+	if i := strings.LastIndex(p, "."); i >= 0 {
+		return strings.ToLower(p[i + 1:])
+	}
+
+	return ""
+}
+
+func Export(writer io.Writer, format string, records []Record) error {
+	switch format {
+		case FormatCSV:
+			return exportCSV(writer, records)
+		case FormatJSON:
+			data, err := json.MarshalIndent(records, "", "\t")
+			if err != nil {
+				return err
+			}
+			_, err = writer.Write(append(data, '\n'))
+			return err
+		case FormatPO:
+			return exportPO(writer, records)
+	}
+
+	return fmt.Errorf("unknown export format `%s`", format)
+}
+
+func Import(reader io.Reader, format string) (records []Record, err error) {
+	switch format {
+		case FormatCSV:
+			return importCSV(reader)
+		case FormatJSON:
+			err = json.NewDecoder(reader).Decode(&records)
+			return
+		case FormatPO:
+			return importPO(reader)
+	}
+
+	return nil, fmt.Errorf("unknown import format `%s`", format)
+}
+
+func exportCSV(writer io.Writer, records []Record) error {
+	w := csv.NewWriter(writer)
+	w.Write(csvHeader)
+
+	for _, r := range records {
+		w.Write([]string{ r.Identifier, strconv.Itoa(r.Collision), r.String, strconv.Itoa(r.IdentifierEntry), strconv.Itoa(r.StringEntry) })
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+func importCSV(reader io.Reader) (records []Record, err error) {
+	r := csv.NewReader(reader)
+
+	header, err := r.Read()
+	if err != nil {
+		return
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[name] = i
+	}
+
+	for _, name := range csvHeader[:3] {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV column `%s` missing", name)
+		}
+	}
+
+	for line := 2; ; line++ {
+		var fields []string
+		if fields, err = r.Read(); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return
+		}
+
+		record := Record{ Identifier: fields[columns["identifier"]], String: fields[columns["string"]] }
+		if record.Collision, err = strconv.Atoi(fields[columns["collision"]]); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		records = append(records, record)
+	}
+}
+
+// PO files use the identifier and collision as the context (eg. "identifier#1") and the original string as the
+// message id. Untranslated entries have an empty msgstr, and keep the original string when imported as fuzzy ones do.
+func poQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s)
+	return `"` + s + `"`
+}
+
+func poUnquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s) - 1] != '"' {
+		return "", fmt.Errorf("invalid PO string %s", s)
+	}
+
+	var value []uint8
+	for i := 1; i < len(s) - 1; i++ {
+		c := s[i]
+		if c == '\\' && i + 1 < len(s) - 1 {
+			i++
+			switch s[i] {
+				case 'n':
+					c = '\n'
+				case 'r':
+					c = '\r'
+				case 't':
+					c = '\t'
+				default:
+					c = s[i]
+			}
+		}
+		value = append(value, c)
+	}
+
+	return string(value), nil
+}
+
+func exportPO(writer io.Writer, records []Record) error {
+	w := bufio.NewWriter(writer)
+
+	fmt.Fprintf(w, "msgid \"\"\nmsgstr \"\"\n\"Content-Type: text/plain; charset=UTF-8\\n\"\n")
+	for _, r := range records {
+		fmt.Fprintf(w, "\n#. entries %d %d\n", r.IdentifierEntry, r.StringEntry)
+		fmt.Fprintf(w, "msgctxt %s\nmsgid %s\nmsgstr \"\"\n", poQuote(fmt.Sprintf("%s#%d", r.Identifier, r.Collision)), poQuote(r.String))
+	}
+
+	return w.Flush()
+}
+
+func importPO(reader io.Reader) (records []Record, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1 << 20)
+
+	var record Record
+	var fields map[string]string
+	var field string
+	var fuzzy bool
+
+	// The header has no context, and is skipped
+	flush := func() error {
+		if context, ok := fields["msgctxt"]; ok {
+			i := strings.LastIndexByte(context, '#')
+			if i < 0 {
+				return fmt.Errorf("context %q has no collision", context)
+			}
+
+			collision, err := strconv.Atoi(context[i + 1:])
+			if err != nil || collision < 0 {
+				return fmt.Errorf("context %q has an invalid collision", context)
+			}
+
+			record.Identifier, record.Collision, record.String = context[:i], collision, fields["msgstr"]
+			if record.String == "" || fuzzy {
+				record.String = fields["msgid"]
+			}
+			records = append(records, record)
+		}
+		record, fields, field, fuzzy = Record{}, nil, "", false
+		return nil
+	}
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		switch {
+			case text == "":
+				if err = flush(); err != nil {
+					return nil, fmt.Errorf("line %d: %v", line, err)
+				}
+
+			case strings.HasPrefix(text, "#."):
+				if fields != nil {
+					if err = flush(); err != nil {
+						return nil, fmt.Errorf("line %d: %v", line, err)
+					}
+				}
+				fmt.Sscanf(text, "#. entries %d %d", &record.IdentifierEntry, &record.StringEntry)
+
+			case strings.HasPrefix(text, "#,"):
+				for _, flag := range strings.Split(text[2:], ",") {
+					if strings.TrimSpace(flag) == "fuzzy" {
+						fuzzy = true
+					}
+				}
+
+			case strings.HasPrefix(text, "#"):
+
+			case strings.HasPrefix(text, `"`):
+				if field == "" {
+					return nil, fmt.Errorf("line %d: string continuation without a keyword", line)
+				}
+
+				value, err := poUnquote(text)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", line, err)
+				}
+				fields[field] += value
+
+			default:
+				i := strings.IndexByte(text, ' ')
+				if i < 0 {
+					return nil, fmt.Errorf("line %d: invalid PO line", line)
+				}
+
+				value, err := poUnquote(strings.TrimSpace(text[i + 1:]))
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", line, err)
+				}
+
+				field = text[:i]
+				if fields == nil {
+					fields = make(map[string]string)
+				}
+
+				if _, ok := fields[field]; ok {
+					return nil, fmt.Errorf("line %d: duplicate %s", line, field)
+				}
+				fields[field] = value
+		}
+	}
+	if err = flush(); err != nil {
+		return nil, err
+	}
+
+	err = scanner.Err()
+	return
+}
@@ -0,0 +1,94 @@
+package text
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testRecords() []Record {
+	return []Record{
+		{ "dup", 0, "first \"quoted\"\nline", 1, 2 },
+		{ "dup", 1, "", 3, 4 },
+		{ "name#1", 0, "tab\there", 5, 6 },
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	records := testRecords()
+
+	for _, format := range []string{ FormatCSV, FormatJSON, FormatPO } {
+		var buffer bytes.Buffer
+		if err := Export(&buffer, format, records); err != nil {
+			t.Fatal(err)
+		}
+
+		imported, err := Import(bytes.NewReader(buffer.Bytes()), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		if len(imported) != len(records) {
+			t.Fatalf("%s: %d records imported", format, len(imported))
+		}
+
+		// Entry indices are informational, and not every format reads them back
+		for i, r := range records {
+			if got := imported[i]; got.Identifier != r.Identifier || got.Collision != r.Collision || got.String != r.String {
+				t.Errorf("%s: imported %+v, expected %+v", format, got, r)
+			}
+		}
+	}
+}
+
+// The collision is part of the PO context, so that tools treat each pair as a separate message
+func TestPOContext(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := Export(&buffer, FormatPO, testRecords()); err != nil {
+		t.Fatal(err)
+	}
+
+	po := buffer.String()
+	for _, context := range []string{ `msgctxt "dup#0"`, `msgctxt "dup#1"`, `msgctxt "name#1#0"` } {
+		if !strings.Contains(po, context + "\n") {
+			t.Errorf("%s missing", context)
+		}
+	}
+
+	// Comments are only informational
+	po = strings.Replace(po, "#. entries 3 4", "#. collision 5", 1)
+	po = strings.Replace(po, "msgctxt \"dup#1\"\nmsgid \"\"\nmsgstr \"\"", "msgctxt \"dup#1\"\nmsgid \"\"\nmsgstr \"changed\"\n\"!\"", 1)
+
+	records, err := Import(strings.NewReader(po), FormatPO)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := records[1]; r.Identifier != "dup" || r.Collision != 1 || r.String != "changed!" {
+		t.Errorf("imported %+v", r)
+	}
+
+	for _, context := range []string{ `"dup"`, `"dup#"`, `"dup#-1"`, `"dup#x"` } {
+		bad := strings.Replace(buffer.String(), `"dup#0"`, context, 1)
+		if _, err := Import(strings.NewReader(bad), FormatPO); err == nil {
+			t.Errorf("context %s accepted", context)
+		}
+	}
+}
+
+// Fuzzy translations need review, so they keep the original string like untranslated ones
+func TestPOFuzzy(t *testing.T) {
+	po := "msgid \"\"\nmsgstr \"\"\n\n" +
+		"#, fuzzy\nmsgctxt \"a#0\"\nmsgid \"original\"\nmsgstr \"guess\"\n\n" +
+		"#. entries 1 2\n#, c-format, fuzzy\nmsgctxt \"b#0\"\nmsgid \"original\"\nmsgstr \"guess\"\n\n" +
+		"#, c-format\nmsgctxt \"c#0\"\nmsgid \"original\"\nmsgstr \"translated\"\n"
+
+	records, err := Import(strings.NewReader(po), FormatPO)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 3 || records[0].String != "original" || records[1].String != "original" || records[2].String != "translated" {
+		t.Errorf("imported %+v", records)
+	}
+}
@@ -0,0 +1,174 @@
+package text
+
+import (
+	"io"
+	"aaronlindsay.com/go/pkg/pso2/nifl"
+)
+
+// A Table is the structure behind the entries of a TextFile. The root lists named categories, each holding
+// groups of identifier/string pairs. REL0 is laid out as:
+//
+//	0xffffffff
+//	pairs: { identifier, string } for every group in order, so the first group's pairs are at 0x14
+//	groups: { pairs, pair count }
+//	categories: { name, groups, group count }
+//	root: { categories, category count }
+//	the identifiers, names and strings
+//
+// Unlike TextFile, which can only replace strings in place, a Table can be edited freely and written back out.
+type Table struct {
+	Categories []Category
+}
+
+type Category struct {
+	Name string
+	Groups []Group
+}
+
+type Group struct {
+	Pairs []TablePair
+}
+
+// A TablePair is a pair of a Table. Unlike a TextPair, it isn't tied to the entries of a TextFile.
+type TablePair struct {
+	Identifier, String string
+}
+
+const (
+	tableMarker = 0xffffffff
+	tablePairsOffset = nifl.DataOffset + 4
+
+	tablePairSize = 8
+	tableGroupSize = 8
+)
+
+type tablePair struct {
+	Identifier string
+	String string `nifl:"utf16"`
+}
+
+type tableGroup struct {
+	Pairs []tablePair `nifl:"count=Count"`
+	Count uint32
+}
+
+type tableCategory struct {
+	Name string
+	Groups []tableGroup `nifl:"count=Count"`
+	Count uint32
+}
+
+type tableRoot struct {
+	Categories []tableCategory `nifl:"count=Count"`
+	Count uint32
+}
+
+func NewTable(reader io.ReadSeeker) (*Table, error) {
+	t := &Table{}
+	return t, t.parse(reader)
+}
+
+// Identifiers are read as bytes, and each is a Latin-1 character like in TextFile
+func decodeIdentifier(s string) string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+
+	return string(runes)
+}
+
+func (t *Table) parse(reader io.ReadSeeker) error {
+	if _, err := reader.Seek(0, 0); err != nil {
+		return err
+	}
+
+	f, err := nifl.NewFile(reader)
+	if err != nil {
+		return err
+	}
+
+	var root tableRoot
+	if err = f.Decode(&root); err != nil {
+		return err
+	}
+
+	t.Categories = make([]Category, len(root.Categories))
+	for i, c := range root.Categories {
+		category := &t.Categories[i]
+		category.Name = decodeIdentifier(c.Name)
+		category.Groups = make([]Group, len(c.Groups))
+
+		for g, group := range c.Groups {
+			pairs := make([]TablePair, len(group.Pairs))
+			for p, pair := range group.Pairs {
+				pairs[p] = TablePair{ Identifier: decodeIdentifier(pair.Identifier), String: pair.String }
+			}
+			category.Groups[g].Pairs = pairs
+		}
+	}
+
+	return nil
+}
+
+// Pairs lists the pairs of every group, in file order
+func (t *Table) Pairs() (pairs []TablePair) {
+	for _, c := range t.Categories {
+		for _, g := range c.Groups {
+			pairs = append(pairs, g.Pairs...)
+		}
+	}
+
+	return
+}
+
+// Write lays the table out as the entries of a TextFile, which encodes and shares the strings
+func (t *Table) Write(writer io.Writer) error {
+	var pairCount, groupCount uint32
+	for _, c := range t.Categories {
+		groupCount += uint32(len(c.Groups))
+		for _, g := range c.Groups {
+			pairCount += uint32(len(g.Pairs))
+		}
+	}
+
+	pairsOffset := uint32(tablePairsOffset)
+	groupsOffset := pairsOffset + pairCount * tablePairSize
+	categoriesOffset := groupsOffset + groupCount * tableGroupSize
+
+	entries := []TextEntry{ { Value: []uint32{ tableMarker } } }
+
+	for _, c := range t.Categories {
+		for _, g := range c.Groups {
+			for _, p := range g.Pairs {
+				entries = append(entries,
+					TextEntry{ Value: []uint32{ 0 }, Text: p.Identifier, TextStatus: TextEntryIdentifier },
+					TextEntry{ Value: []uint32{ 0 }, Text: p.String, TextStatus: TextEntryString },
+				)
+			}
+		}
+	}
+
+	// Empty groups still point at where their pairs would be
+	offset := pairsOffset
+	for _, c := range t.Categories {
+		for _, g := range c.Groups {
+			entries = append(entries, TextEntry{ Value: []uint32{ offset, uint32(len(g.Pairs)) } })
+			offset += uint32(len(g.Pairs)) * tablePairSize
+		}
+	}
+
+	// Every pointer starts an entry, so a category is split after its name
+	offset = groupsOffset
+	for _, c := range t.Categories {
+		entries = append(entries,
+			TextEntry{ Value: []uint32{ 0 }, Text: c.Name, TextStatus: TextEntryIdentifier },
+			TextEntry{ Value: []uint32{ offset, uint32(len(c.Groups)) } },
+		)
+		offset += uint32(len(c.Groups)) * tableGroupSize
+	}
+
+	entries = append(entries, TextEntry{ Value: []uint32{ categoriesOffset, uint32(len(t.Categories)) } })
+
+	return (&TextFile{ Entries: entries }).Write(writer)
+}
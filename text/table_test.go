@@ -0,0 +1,155 @@
+package text
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func testTable() *Table {
+	return &Table{ Categories: []Category{
+		{ Name: "ui_a", Groups: []Group{
+			{ Pairs: []TablePair{ { Identifier: "x", String: "Hi" }, { Identifier: "y", String: "Yø😀" } } },
+			{ Pairs: []TablePair{} },
+		} },
+		{ Name: "ui_b", Groups: []Group{
+			{ Pairs: []TablePair{ { Identifier: "x", String: "Hi" } } },
+		} },
+		{ Name: "empty", Groups: []Group{} },
+	} }
+}
+
+func writeTable(t *testing.T, table *Table) []uint8 {
+	var buffer bytes.Buffer
+	if err := table.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestTableRoundTrip(t *testing.T) {
+	table := testTable()
+	data := writeTable(t, table)
+
+	read, err := NewTable(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(read, table) {
+		t.Errorf("read back as %+v", read)
+	}
+
+	// The same file seen as a TextFile has the same pairs, and is written back unchanged
+	f, err := NewTextFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Pairs) != 3 || f.Pairs[1].String != "Yø😀" || f.Pairs[2].Identifier != "x" {
+		t.Errorf("text file pairs %+v", f.Pairs)
+	}
+
+	var rewritten bytes.Buffer
+	if err := f.Write(&rewritten); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(rewritten.Bytes(), data) {
+		t.Error("text file rewrite differs")
+	}
+}
+
+func TestTableEditPairs(t *testing.T) {
+	table := testTable()
+
+	// Remove the first pair of one group, and add pairs to another that was empty
+	group := &table.Categories[0].Groups[0]
+	group.Pairs = group.Pairs[1:]
+	table.Categories[0].Groups[1].Pairs = []TablePair{ { Identifier: "z", String: "new" }, { Identifier: "w", String: "newer" } }
+
+	data := writeTable(t, table)
+
+	read, err := NewTable(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(read, table) {
+		t.Errorf("read back as %+v", read)
+	}
+
+	f, err := NewTextFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var identifiers []string
+	for _, p := range f.Pairs {
+		identifiers = append(identifiers, p.Identifier)
+	}
+
+	if !reflect.DeepEqual(identifiers, []string{ "y", "z", "w", "x" }) {
+		t.Errorf("text file pairs %q", identifiers)
+	}
+}
+
+// A table with one category of one group, assembled by hand from the layout described on Table. No game files ship
+// with this repository, so it stands in for one.
+var tableFixture = []uint8{
+	// NIFL: version 1, REL0 at 0x20 (0x60 bytes), NOF0 at 0x80 (0x30 bytes)
+	'N', 'I', 'F', 'L', 0x18, 0, 0, 0, 0x01, 0, 0, 0, 0x20, 0, 0, 0,
+	0x60, 0, 0, 0, 0x80, 0, 0, 0, 0x30, 0, 0, 0, 0, 0, 0, 0,
+
+	// REL0: the root at 0x38
+	'R', 'E', 'L', '0', 0x54, 0, 0, 0, 0x38, 0, 0, 0, 0, 0, 0, 0,
+	// 0x10: the marker, then the pairs { "a", "Hi" } and { "b", "Yo" }
+	0xff, 0xff, 0xff, 0xff, 0x40, 0, 0, 0, 0x44, 0, 0, 0, 0x4c, 0, 0, 0,
+	0x50, 0, 0, 0,
+	// 0x24: the group, of two pairs at 0x14
+	0x14, 0, 0, 0, 0x02, 0, 0, 0,
+	// 0x2c: the category "ui", of one group at 0x24
+	0x58, 0, 0, 0, 0x24, 0, 0, 0, 0x01, 0, 0, 0,
+	// 0x38: the root, of one category at 0x2c
+	0x2c, 0, 0, 0, 0x01, 0, 0, 0,
+	// 0x40: identifiers are single bytes and strings UTF-16, each null terminated and padded to 4 bytes
+	'a', 0, 0, 0, 'H', 0, 'i', 0, 0, 0, 0, 0, 'b', 0, 0, 0,
+	'Y', 0, 'o', 0, 0, 0, 0, 0, 'u', 'i', 0, 0, 0, 0, 0, 0,
+
+	// NOF0: every pointer above
+	'N', 'O', 'F', '0', 0x24, 0, 0, 0, 0x08, 0, 0, 0, 0x14, 0, 0, 0,
+	0x18, 0, 0, 0, 0x1c, 0, 0, 0, 0x20, 0, 0, 0, 0x24, 0, 0, 0,
+	0x2c, 0, 0, 0, 0x30, 0, 0, 0, 0x38, 0, 0, 0, 0, 0, 0, 0,
+
+	'N', 'E', 'N', 'D', 0x08, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+}
+
+func TestTableFixture(t *testing.T) {
+	table, err := NewTable(bytes.NewReader(tableFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Table{ Categories: []Category{
+		{ Name: "ui", Groups: []Group{
+			{ Pairs: []TablePair{ { Identifier: "a", String: "Hi" }, { Identifier: "b", String: "Yo" } } },
+		} },
+	} }
+	if !reflect.DeepEqual(table, expected) {
+		t.Errorf("read as %+v", table)
+	}
+
+	f, err := NewTextFile(bytes.NewReader(tableFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Pairs) != 2 || f.PairString(&f.Pairs[1]).Text != "Yo" {
+		t.Errorf("text file pairs %+v", f.Pairs)
+	}
+
+	if data := writeTable(t, table); !bytes.Equal(data, tableFixture) {
+		t.Errorf("written as % x", data)
+	}
+}
@@ -2,16 +2,17 @@ package text
 
 import (
 	"io"
+	"fmt"
+	"bufio"
+	"bytes"
 	"errors"
-	"aaronlindsay.com/go/pkg/pso2/util"
-	"github.com/quarnster/util/encoding/binary"
+	"strings"
 	"unicode/utf8"
+	"unicode/utf16"
+	"aaronlindsay.com/go/pkg/pso2/nifl"
 	bin "encoding/binary"
 )
 
-const textBufferThreshold = 0x80000
-const textBufferDataThreshold = 0x800000
-
 type TextPair struct {
 	Identifier, String string
 
@@ -22,6 +23,9 @@ type TextFile struct {
 	Entries []TextEntry
 
 	Pairs []TextPair
+
+	// The parsed container, so that fields we don't understand are written back
+	file *nifl.File
 }
 
 type TextEntry struct {
@@ -37,117 +41,61 @@ const (
 )
 
 func NewTextFile(reader io.ReadSeeker) (*TextFile, error) {
-	f := &TextFile{nil, nil}
+	f := &TextFile{}
 	return f, f.parse(reader)
 }
 
 func (t *TextFile) parse(r io.ReadSeeker) (err error) {
-	nifl, err := TagRead(r)
-
+	f, err := nifl.NewFile(r)
 	if err != nil {
 		return err
 	}
+	t.file = f
 
-	if nifl.Tag != "NIFL" {
-		return errors.New("NIFL tag expected")
-	}
-
-	reader := binary.BinaryReader{ Reader: nifl.Data, Endianess: binary.LittleEndian }
-
-	type niflHeaderType struct {
-		Unk, OffsetREL0, SizeREL0, OffsetNOF0, SizeNOF0 uint32
-	}
-
-	var niflHeader niflHeaderType
-	if err = reader.ReadInterface(&niflHeader); err != nil {
-		return err
-	}
-
-	if niflHeader.Unk != 1 {
-		return errors.New("NIFL header magic != 1")
-	}
-
-	r.Seek(int64(niflHeader.OffsetREL0), 0)
-
-	rel0, err := TagRead(r)
-	if rel0.Tag != "REL0" {
-		return errors.New("REL0 tag expected")
-	}
-
-	var rel0data io.ReadSeeker
-	var rel0strings io.ReadSeeker
-	reader = binary.BinaryReader{ Reader: rel0.Data, Endianess: binary.LittleEndian }
-	rel0size, err := reader.Uint32()
-	rel0data = io.NewSectionReader(util.ReaderAt(rel0.Data), 8, int64(rel0size) - 8)
-	rel0strings = io.NewSectionReader(util.ReaderAt(rel0.Data), int64(rel0size), int64(rel0.Size - rel0size))
-
-	if rel0size < textBufferDataThreshold {
-		rel0data, err = util.MemReader(rel0data)
-		if err != nil {
-			return err
-		}
-	}
-
-	if rel0.Size - rel0size < textBufferThreshold {
-		rel0strings, err = util.MemReader(rel0strings)
-		if err != nil {
-			return err
-		}
-	}
-
-	r.Seek(int64(niflHeader.OffsetNOF0), 0)
-
-	nof0, err := TagRead(r)
-	if nof0.Tag != "NOF0" {
-		return errors.New("NOF0 tag expected")
-	}
-
-	nof0reader := binary.BinaryReader{ Reader: nof0.Data, Endianess: binary.LittleEndian }
-	count, err := nof0reader.Uint32()
-	offsets := make([]uint32, int(count) + 1)
-	i := 0
-	for offset, _ := nof0reader.Uint32(); i < int(count); i++ {
-		end, _ := nof0reader.Uint32()
-
-		offsets[i] = end - offset
-
-		offset = end
-
-		if offsets[i] % 4 != 0 {
-			return errors.New("nof0 entry not a multiple of 32 bits")
-		}
+	// Each entry starts at a pointer, and the last is the 8 byte root structure
+	offsets := append([]uint32(nil), f.Relocations...)
+	if len(offsets) == 0 || offsets[0] != nifl.DataOffset {
+		offsets = append([]uint32{ nifl.DataOffset }, offsets...)
 	}
-	offsets[i] = 8
+	offsets = append(offsets, offsets[len(offsets) - 1] + 8)
 
-	t.Entries = make([]TextEntry, len(offsets))
-
-	rel0reader := binary.BinaryReader{ Reader: rel0data, Endianess: binary.LittleEndian }
+	t.Entries = make([]TextEntry, len(offsets) - 1)
 
 	pairMode := false
 	var pair *string
 	var pairi int
-	for i, offset := range offsets {
+	for i := range t.Entries {
 		entry := &t.Entries[i]
 
-		entry.Value = make([]uint32, offset / 4)
-		for i := 0; i < int(offset / 4); i++ {
-			entry.Value[i], err = rel0reader.Uint32()
+		if offsets[i + 1] <= offsets[i] || (offsets[i + 1] - offsets[i]) % 4 != 0 {
+			return errors.New("nof0 entry not a multiple of 32 bits")
+		}
+
+		if entry.Value, err = f.ReadUint32s(offsets[i], (offsets[i + 1] - offsets[i]) / 4); err != nil {
+			return err
 		}
 
-		if entry.Value[0] == 0xffffffff {
+		if entry.Value[0] == tableMarker {
 			pairMode = true
-		} else if entry.Value[0] == 0x14 {
+		} else if entry.Value[0] == tablePairsOffset {
 			pairMode = false
 			pair = nil
 		}
 
-		if len(entry.Value) == 1 && entry.Value[0] != 0xffffffff {
-			rel0strings.Seek(int64(entry.Value[0] - rel0size - 8), 0)
+		if len(entry.Value) == 1 && entry.Value[0] != tableMarker {
 			charSize := 1
 			if pair != nil {
 				charSize = 2
 			}
-			entry.Text, _ = readString(charSize, rel0strings)
+
+			var reader io.ReadSeeker
+			if reader, err = f.Reader(entry.Value[0]); err != nil {
+				return err
+			}
+
+			if entry.Text, err = readString(charSize, reader); err != nil {
+				return fmt.Errorf("entry %d: %v", i, err)
+			}
 
 			if pair != nil {
 				entry.TextStatus = TextEntryString
@@ -163,145 +111,126 @@ func (t *TextFile) parse(r io.ReadSeeker) (err error) {
 		}
 	}
 
-	_, err = r.Seek(int64((nof0.Size + 8 + 0x0f) / 0x10 * 0x10) - 8, 1)
-
-	nend, err := TagRead(r)
-	if nend.Tag != "NEND" {
-		return errors.New("NEND tag expected")
-	}
-
-	return err
+	return nil
 }
 
+// readString reads a null terminated string. Identifiers have one byte per character, and strings are UTF-16
+// (unpaired surrogates are replaced with U+FFFD).
 func readString(charSize int, reader io.Reader) (string, error) {
-	stringValue := make([]rune, 0)
-	for len(stringValue) == 0 || stringValue[len(stringValue) - 1] != 0 {
-		p := make([]uint8, charSize)
-		_, err := reader.Read(p)
+	reader = bufio.NewReader(reader)
 
-		if err != nil {
-			return string(stringValue), err
+	var identifier []rune
+	var units []uint16
+	for {
+		p := make([]uint8, charSize)
+		if _, err := io.ReadFull(reader, p); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
 		}
 
 		if charSize == 1 {
-			stringValue = append(stringValue, rune(p[0]))
+			if p[0] == 0 {
+				return string(identifier), nil
+			}
+			identifier = append(identifier, rune(p[0]))
 		} else {
-			stringValue = append(stringValue, rune((uint16(p[1]) << 8) | uint16(p[0])))
+			unit := bin.LittleEndian.Uint16(p)
+			if unit == 0 {
+				return string(utf16.Decode(units)), nil
+			}
+			units = append(units, unit)
+		}
+	}
+}
+
+func encodeIdentifier(text string) ([]uint8, error) {
+	data := make([]uint8, 0, len(text) + 1)
+	for _, r := range text {
+		if r == 0 || r > 0xff {
+			return nil, fmt.Errorf("identifier `%s` contains an unrepresentable character %U", text, r)
 		}
+		data = append(data, uint8(r))
+	}
+
+	return append(data, 0), nil
+}
+
+func encodeString(text string) ([]uint16, error) {
+	if !utf8.ValidString(text) {
+		return nil, fmt.Errorf("string %q is not valid UTF-8", text)
+	}
+
+	if strings.ContainsRune(text, 0) {
+		return nil, fmt.Errorf("string %q contains a null character", text)
 	}
 
-	return string(stringValue[:len(stringValue) - 1]), nil
+	return append(utf16.Encode([]rune(text)), 0), nil
 }
 
 func (t *TextFile) Write(writer io.Writer) error {
+	var data, stringData bytes.Buffer
 	end := bin.LittleEndian
 
-	// TODO: This reuse detection is pretty bad...
-	stringReuse := make(map[string]TextEntry)
-
 	entrySize := uint32(0)
-	stringSize := uint32(0)
 	for _, entry := range t.Entries {
 		entrySize += uint32(len(entry.Value) * 4)
-
-		if entry.TextStatus != TextEntryNone {
-			if reuse, ok := stringReuse[entry.Text]; ok && reuse.TextStatus == entry.TextStatus {
-				entry.Value[0] = reuse.Value[0]
-			} else {
-				entry.Value[0] = stringSize
-
-				charSize := 1
-				strlen := len(entry.Text)
-				if entry.TextStatus == TextEntryString {
-					charSize = 2
-					strlen = utf8.RuneCountInString(entry.Text)
-				}
-
-				stringSize += uint32(charSize * (strlen + 1))
-				stringSize = (stringSize + 3) / 4 * 4
-
-				stringReuse[entry.Text] = entry
-			}
-		}
 	}
 
-	rel0size := entrySize + stringSize + 0x10
-	rel0sizeRounded := (rel0size + 0x0f) / 0x10 * 0x10
-	nof0size := uint32(len(t.Entries) + 1) * 4 + 8
-	nof0sizeRounded := (nof0size + 0x0f) / 0x10 * 0x10
-
-	io.WriteString(writer, "NIFL")
-	bin.Write(writer, end, uint32(0x18))
-	bin.Write(writer, end, uint32(0x01))
-	bin.Write(writer, end, uint32(0x20))
-	bin.Write(writer, end, rel0sizeRounded)
-	bin.Write(writer, end, rel0sizeRounded + 0x20)
-	bin.Write(writer, end, nof0sizeRounded)
-	bin.Write(writer, end, uint32(0))
-
-	io.WriteString(writer, "REL0")
-	bin.Write(writer, end, rel0size - 8)
-	bin.Write(writer, end, entrySize + 8)
-	bin.Write(writer, end, uint32(0))
-
-	for _, entry := range t.Entries {
-		if entry.TextStatus != TextEntryNone {
-			entry.Value[0] += entrySize + 0x10
-		}
-
-		bin.Write(writer, end, entry.Value)
+	f := &nifl.File{ Version: 1 }
+	if t.file != nil {
+		file := *t.file
+		f = &file
+		f.Relocations = nil
 	}
 
-	stringReuse = make(map[string]TextEntry)
+	// TODO: This reuse detection is pretty bad...
+	stringReuse := make(map[string]TextEntry)
 
-	for _, entry := range t.Entries {
-		if reuse, ok := stringReuse[entry.Text]; ok && reuse.TextStatus == entry.TextStatus {
-			continue
+	for i, entry := range t.Entries {
+		// The marker that starts the pairs is not a pointer
+		if i > 0 || entry.Value[0] != tableMarker {
+			f.Relocations = append(f.Relocations, uint32(nifl.DataOffset + data.Len()))
 		}
+		value := append([]uint32(nil), entry.Value...)
+
+		if entry.TextStatus != TextEntryNone {
+			if reuse, ok := stringReuse[entry.Text]; ok && reuse.TextStatus == entry.TextStatus {
+				value[0] = reuse.Value[0]
+			} else {
+				value[0] = nifl.DataOffset + entrySize + uint32(stringData.Len())
+
+				switch entry.TextStatus {
+					case TextEntryIdentifier:
+						data, err := encodeIdentifier(entry.Text)
+						if err != nil {
+							return err
+						}
+						stringData.Write(data)
+					case TextEntryString:
+						units, err := encodeString(entry.Text)
+						if err != nil {
+							return err
+						}
+						bin.Write(&stringData, end, units)
+				}
 
-		strlen := len(entry.Text) + 1
-		switch entry.TextStatus {
-			case TextEntryIdentifier:
-				io.WriteString(writer, entry.Text)
-				bin.Write(writer, end, uint8(0))
-			case TextEntryString:
-				for _, r := range entry.Text {
-					bin.Write(writer, end, uint16(r))
+				for stringData.Len() % 4 != 0 {
+					stringData.WriteByte(0)
 				}
-				bin.Write(writer, end, uint16(0))
-				strlen = (utf8.RuneCountInString(entry.Text) + 1) * 2
-			default:
-				continue
-		}
 
-		padding := 4 - (strlen % 4)
-		if padding < 4 {
-			writer.Write(make([]uint8, padding))
+				stringReuse[entry.Text] = TextEntry{ value, entry.Text, entry.TextStatus }
+			}
 		}
 
-		stringReuse[entry.Text] = entry
-	}
-
-	writer.Write(make([]uint8, rel0sizeRounded - rel0size))
-
-	io.WriteString(writer, "NOF0")
-	bin.Write(writer, end, nof0size - 8)
-	bin.Write(writer, end, uint32(len(t.Entries) - 1))
-
-	offset := uint32(0x10)
-	for _, entry := range t.Entries {
-		bin.Write(writer, end, offset)
-		offset += uint32(len(entry.Value) * 4)
+		bin.Write(&data, end, value)
 	}
 
-	writer.Write(make([]uint8, nof0sizeRounded - nof0size))
-
-	io.WriteString(writer, "NEND")
-	bin.Write(writer, end, uint32(0x08))
-	bin.Write(writer, end, uint32(0))
-	bin.Write(writer, end, uint32(0))
+	f.Root = nifl.DataOffset + entrySize - 8
+	f.Data = append(data.Bytes(), stringData.Bytes()...)
 
-	return nil
+	return f.Write(writer)
 }
 
 func (t *TextFile) PairIdentifier(p *TextPair) *TextEntry {
@@ -0,0 +1,120 @@
+package text
+
+import (
+	"bytes"
+	"testing"
+)
+
+// A table of two pairs, laid out the way TextFile.parse expects: the marker, the pairs, and a group pointing at them
+func testTextFile(pairs ...string) *TextFile {
+	t := &TextFile{}
+	t.Entries = append(t.Entries, TextEntry{ Value: []uint32{ tableMarker } })
+	for i := 0; i < len(pairs); i += 2 {
+		t.Entries = append(t.Entries,
+			TextEntry{ Value: []uint32{ 0 }, Text: pairs[i], TextStatus: TextEntryIdentifier },
+			TextEntry{ Value: []uint32{ 0 }, Text: pairs[i + 1], TextStatus: TextEntryString },
+		)
+	}
+	t.Entries = append(t.Entries, TextEntry{ Value: []uint32{ tablePairsOffset, uint32(len(pairs) / 2) } })
+
+	return t
+}
+
+func TestTextFileRoundTrip(t *testing.T) {
+	var first bytes.Buffer
+	if err := testTextFile("a", "first", "b", "second", "c", "first").Write(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewTextFile(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Pairs) != 3 || f.Pairs[1].Identifier != "b" || f.Pairs[2].String != "first" {
+		t.Fatalf("pairs %+v", f.Pairs)
+	}
+
+	var second bytes.Buffer
+	if err := f.Write(&second); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("rewritten file differs")
+	}
+}
+
+// Characters outside the BMP are written as surrogate pairs
+func TestTextFileSurrogates(t *testing.T) {
+	for _, s := range []string{ "😀", "𠀋", "a😀b𠀋c" } {
+		var buffer bytes.Buffer
+		if err := testTextFile("id", s).Write(&buffer); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Contains(buffer.Bytes(), []uint8{ 0x3d, 0xd8, 0x00, 0xde }) && !bytes.Contains(buffer.Bytes(), []uint8{ 0x40, 0xd8, 0x0b, 0xdc }) {
+			t.Errorf("%q: no surrogate pair written", s)
+		}
+
+		f, err := NewTextFile(bytes.NewReader(buffer.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(f.Pairs) != 1 || f.Pairs[0].String != s {
+			t.Errorf("%q read back as %+v", s, f.Pairs)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	for _, s := range []string{ "id_😀", "id_日本", "id\x00" } {
+		if _, err := encodeIdentifier(s); err == nil {
+			t.Errorf("identifier %q encoded", s)
+		}
+	}
+
+	if data, err := encodeIdentifier("id_\xe9"); err == nil {
+		t.Errorf("invalid UTF-8 identifier encoded as %x", data)
+	}
+	if data, err := encodeIdentifier("idé"); err != nil || !bytes.Equal(data, []uint8{ 'i', 'd', 0xe9, 0 }) {
+		t.Errorf("latin-1 identifier: %x, %v", data, err)
+	}
+
+	for _, s := range []string{ "bad \xff utf-8", "\xed\xa0\x80", "null\x00" } {
+		if _, err := encodeString(s); err == nil {
+			t.Errorf("string %q encoded", s)
+		}
+	}
+
+	if err := testTextFile("id_😀", "s").Write(&bytes.Buffer{}); err == nil {
+		t.Error("file with an unrepresentable identifier written")
+	}
+}
+
+// Fields of the container that TextFile doesn't understand are written back
+func TestTextFileKeepsContainer(t *testing.T) {
+	var first bytes.Buffer
+	if err := testTextFile("a", "first").Write(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	// The version, and the NIFL field after the NOF0 size
+	data := first.Bytes()
+	data[0x08], data[0x1c] = 0x02, 0x77
+
+	f, err := NewTextFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var second bytes.Buffer
+	if err := f.Write(&second); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(second.Bytes(), data) {
+		t.Errorf("rewritten file differs:\n%x\n%x", second.Bytes(), data)
+	}
+}
@@ -0,0 +1,242 @@
+package texture
+
+import (
+	"io"
+	"fmt"
+	"image"
+	"errors"
+	"image/color"
+	bin "encoding/binary"
+)
+
+const (
+	ddsMagic = "DDS "
+	ddsHeaderSize = 124
+	ddsPixelFormatSize = 32
+)
+
+// DDS header flags
+const (
+	ddsdCaps uint32 = 0x1
+	ddsdHeight uint32 = 0x2
+	ddsdWidth uint32 = 0x4
+	ddsdPitch uint32 = 0x8
+	ddsdPixelFormat uint32 = 0x1000
+	ddsdMipMapCount uint32 = 0x20000
+	ddsdLinearSize uint32 = 0x80000
+
+	ddpfAlphaPixels uint32 = 0x1
+	ddpfFourCC uint32 = 0x4
+	ddpfRGB uint32 = 0x40
+
+	ddscapsComplex uint32 = 0x8
+	ddscapsTexture uint32 = 0x1000
+	ddscapsMipMap uint32 = 0x400000
+)
+
+type Format int
+
+const (
+	// Uncompressed pixels, described by the header's bit masks. Encoded as 32-bit BGRA.
+	FormatRGB Format = iota
+	FormatDXT1
+	FormatDXT3
+	FormatDXT5
+)
+
+func (f Format) String() string {
+	switch f {
+		case FormatRGB:
+			return "RGB"
+		case FormatDXT1:
+			return "DXT1"
+		case FormatDXT3:
+			return "DXT3"
+		case FormatDXT5:
+			return "DXT5"
+	}
+
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// Size in bytes of a 4x4 block, for compressed formats
+func (f Format) blockSize() int {
+	if f == FormatDXT1 {
+		return 8
+	}
+	return 16
+}
+
+type ddsPixelFormat struct {
+	Size, Flags uint32
+	FourCC [4]uint8
+	RGBBitCount uint32
+	RBitMask, GBitMask, BBitMask, ABitMask uint32
+}
+
+type ddsHeader struct {
+	Size, Flags uint32
+	Height, Width uint32
+	PitchOrLinearSize uint32
+	Depth uint32
+	MipMapCount uint32
+	Reserved1 [11]uint32
+	PixelFormat ddsPixelFormat
+	Caps, Caps2, Caps3, Caps4 uint32
+	Reserved2 uint32
+}
+
+// Header describes a DDS texture
+type Header struct {
+	Width, Height int
+	MipMapCount int
+	Format Format
+
+	// For uncompressed textures
+	BitCount int
+	RMask, GMask, BMask, AMask uint32
+}
+
+// ReadHeader reads the header of a DDS file, leaving the reader at the start of the pixel data
+func ReadHeader(reader io.Reader) (*Header, error) {
+	var magic [4]uint8
+	if _, err := io.ReadFull(reader, magic[:]); err != nil {
+		return nil, err
+	}
+
+	if string(magic[:]) != ddsMagic {
+		return nil, errors.New("not a DDS file")
+	}
+
+	var h ddsHeader
+	if err := bin.Read(reader, bin.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+
+	if h.Size != ddsHeaderSize || h.PixelFormat.Size != ddsPixelFormatSize {
+		return nil, errors.New("DDS header size mismatch")
+	}
+
+	header := &Header{ Width: int(h.Width), Height: int(h.Height), MipMapCount: 1 }
+	if h.Flags & ddsdMipMapCount != 0 && h.MipMapCount > 1 {
+		header.MipMapCount = int(h.MipMapCount)
+	}
+
+	pf := &h.PixelFormat
+	if pf.Flags & ddpfFourCC != 0 {
+		switch string(pf.FourCC[:]) {
+			case "DXT1":
+				header.Format = FormatDXT1
+			case "DXT2", "DXT3":
+				header.Format = FormatDXT3
+			case "DXT4", "DXT5":
+				header.Format = FormatDXT5
+			default:
+				return nil, fmt.Errorf("unsupported DDS format `%s`", string(pf.FourCC[:]))
+		}
+	} else {
+		header.Format = FormatRGB
+		header.BitCount = int(pf.RGBBitCount)
+		header.RMask, header.GMask, header.BMask = pf.RBitMask, pf.GBitMask, pf.BBitMask
+		if pf.Flags & ddpfAlphaPixels != 0 {
+			header.AMask = pf.ABitMask
+		}
+
+		if header.BitCount % 8 != 0 || header.BitCount < 8 || header.BitCount > 32 {
+			return nil, fmt.Errorf("unsupported DDS pixel size %d", header.BitCount)
+		}
+	}
+
+	if header.Width <= 0 || header.Height <= 0 || header.Width > 0x4000 || header.Height > 0x4000 {
+		return nil, fmt.Errorf("invalid DDS dimensions %dx%d", header.Width, header.Height)
+	}
+
+	return header, nil
+}
+
+// The position of a mask's lowest bit
+func maskShift(mask uint32) (shift uint) {
+	for mask & (1 << shift) == 0 {
+		shift++
+	}
+
+	return
+}
+
+// Expands a masked channel to 8 bits, returning def if the mask is empty
+func maskChannel(pixel, mask uint32, def uint8) uint8 {
+	if mask == 0 {
+		return def
+	}
+
+	shift := maskShift(mask)
+	max := uint64(mask >> shift)
+	return uint8(uint64((pixel & mask) >> shift) * 0xff / max)
+}
+
+// Decode reads the top level of a DDS texture
+func Decode(reader io.Reader) (image.Image, error) {
+	h, err := ReadHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, h.Width, h.Height))
+
+	if h.Format == FormatRGB {
+		size := h.BitCount / 8
+		row := make([]uint8, h.Width * size)
+
+		for y := 0; y < h.Height; y++ {
+			if _, err := io.ReadFull(reader, row); err != nil {
+				return nil, err
+			}
+
+			for x := 0; x < h.Width; x++ {
+				var pixel uint32
+				for i := size - 1; i >= 0; i-- {
+					pixel = pixel << 8 | uint32(row[x * size + i])
+				}
+
+				img.SetNRGBA(x, y, color.NRGBA{
+					maskChannel(pixel, h.RMask, 0), maskChannel(pixel, h.GMask, 0), maskChannel(pixel, h.BMask, 0), maskChannel(pixel, h.AMask, 0xff),
+				})
+			}
+		}
+
+		return img, nil
+	}
+
+	block := make([]uint8, h.Format.blockSize())
+	var pixels [16]color.NRGBA
+	for by := 0; by < h.Height; by += 4 {
+		for bx := 0; bx < h.Width; bx += 4 {
+			if _, err := io.ReadFull(reader, block); err != nil {
+				return nil, err
+			}
+
+			decodeBlock(h.Format, block, &pixels)
+
+			for i, c := range pixels {
+				if x, y := bx + i % 4, by + i / 4; x < h.Width && y < h.Height {
+					img.SetNRGBA(x, y, c)
+				}
+			}
+		}
+	}
+
+	return img, nil
+}
+
+func DecodeConfig(reader io.Reader) (image.Config, error) {
+	h, err := ReadHeader(reader)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{ ColorModel: color.NRGBAModel, Width: h.Width, Height: h.Height }, nil
+}
+
+func init() {
+	image.RegisterFormat("dds", ddsMagic, Decode, DecodeConfig)
+}
@@ -0,0 +1,113 @@
+package texture
+
+import (
+	"bytes"
+	"image"
+	"testing"
+	"image/color"
+	bin "encoding/binary"
+)
+
+// testDDS lays out a 4x4 texture by hand, with either a FourCC or an RGB pixel format
+func testDDS(fourCC string, bitCount int, masks [4]uint32, data []uint8) []uint8 {
+	header := ddsHeader{
+		Size: ddsHeaderSize,
+		Flags: ddsdCaps | ddsdHeight | ddsdWidth | ddsdPixelFormat,
+		Height: 4,
+		Width: 4,
+		Caps: ddscapsTexture,
+	}
+	header.PixelFormat.Size = ddsPixelFormatSize
+
+	if fourCC != "" {
+		header.PixelFormat.Flags = ddpfFourCC
+		copy(header.PixelFormat.FourCC[:], fourCC)
+	} else {
+		header.PixelFormat.Flags = ddpfRGB
+		if masks[3] != 0 {
+			header.PixelFormat.Flags |= ddpfAlphaPixels
+		}
+		header.PixelFormat.RGBBitCount = uint32(bitCount)
+		header.PixelFormat.RBitMask, header.PixelFormat.GBitMask, header.PixelFormat.BBitMask, header.PixelFormat.ABitMask = masks[0], masks[1], masks[2], masks[3]
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(ddsMagic)
+	bin.Write(&buffer, bin.LittleEndian, &header)
+	buffer.Write(data)
+	return buffer.Bytes()
+}
+
+// A colour block whose first four pixels use palette entries 0 to 3, and the rest entry 0
+func testColorBlock(c0, c1 uint16) []uint8 {
+	block := make([]uint8, 8)
+	bin.LittleEndian.PutUint16(block, c0)
+	bin.LittleEndian.PutUint16(block[2:], c1)
+	bin.LittleEndian.PutUint32(block[4:], 0 | 1 << 2 | 2 << 4 | 3 << 6)
+	return block
+}
+
+func checkPixels(t *testing.T, name string, data []uint8, expected []color.NRGBA) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil || format != "dds" {
+		t.Fatalf("%s: decoded as %s, %v", name, format, err)
+	}
+
+	for i, c := range expected {
+		if actual := img.(*image.NRGBA).NRGBAAt(i % 4, i / 4); actual != c {
+			t.Errorf("%s: pixel %d is %v, expected %v", name, i, actual, c)
+		}
+	}
+}
+
+var (
+	testRed = color.NRGBA{ 0xff, 0, 0, 0xff }
+	testBlue = color.NRGBA{ 0, 0, 0xff, 0xff }
+)
+
+func TestDecodeDXT1(t *testing.T) {
+	// c0 > c1 gives four colours
+	checkPixels(t, "opaque", testDDS("DXT1", 0, [4]uint32{}, testColorBlock(0xf800, 0x001f)), []color.NRGBA{
+		testRed, testBlue, { 0xaa, 0, 0x55, 0xff }, { 0x55, 0, 0xaa, 0xff }, testRed,
+	})
+
+	// c0 <= c1 gives three colours and transparent black
+	checkPixels(t, "alpha", testDDS("DXT1", 0, [4]uint32{}, testColorBlock(0x001f, 0xf800)), []color.NRGBA{
+		testBlue, testRed, { 0x7f, 0, 0x7f, 0xff }, {}, testBlue,
+	})
+}
+
+func TestDecodeDXT3(t *testing.T) {
+	// Explicit alpha comes first, four bits per pixel. The colour block always has four colours.
+	block := append([]uint8{ 0x8f, 0x20, 0, 0, 0, 0, 0, 0 }, testColorBlock(0x001f, 0xf800)...)
+	checkPixels(t, "DXT3", testDDS("DXT3", 0, [4]uint32{}, block), []color.NRGBA{
+		{ 0, 0, 0xff, 0xff }, { 0xff, 0, 0, 0x88 }, { 0x55, 0, 0xaa, 0 }, { 0xaa, 0, 0x55, 0x22 }, { 0, 0, 0xff, 0 },
+	})
+}
+
+func TestDecodeDXT5(t *testing.T) {
+	// Two alpha endpoints and 3 bit indices, here 0, 1, 2 and 7 for the first pixels
+	alpha := []uint8{ 0xff, 0x00, 0 | 1 << 3 | 2 << 6, 2 >> 2 | 7 << 1, 0, 0, 0, 0 }
+	block := append(alpha, testColorBlock(0xf800, 0x001f)...)
+	checkPixels(t, "DXT5", testDDS("DXT5", 0, [4]uint32{}, block), []color.NRGBA{
+		testRed, { 0, 0, 0xff, 0 }, { 0xaa, 0, 0x55, 0xda }, { 0x55, 0, 0xaa, 0x24 }, testRed,
+	})
+}
+
+func TestDecodeRGB(t *testing.T) {
+	pixels := []uint16{ 0xf800, 0x07e0, 0x001f, 0x8410 }
+	data := make([]uint8, 32)
+	for i, p := range pixels {
+		bin.LittleEndian.PutUint16(data[i * 2:], p)
+	}
+
+	checkPixels(t, "565", testDDS("", 16, [4]uint32{ 0xf800, 0x07e0, 0x001f, 0 }, data), []color.NRGBA{
+		testRed, { 0, 0xff, 0, 0xff }, testBlue, { 0x83, 0x81, 0x83, 0xff },
+	})
+
+	data = []uint8{ 0x10, 0x20, 0x30, 0x40, 0x50, 0x60 }
+	data = append(data, make([]uint8, 48 - len(data))...)
+	checkPixels(t, "BGR", testDDS("", 24, [4]uint32{ 0xff0000, 0xff00, 0xff, 0 }, data), []color.NRGBA{
+		{ 0x30, 0x20, 0x10, 0xff }, { 0x60, 0x50, 0x40, 0xff },
+	})
+}
@@ -0,0 +1,200 @@
+package texture
+
+import (
+	"image/color"
+	bin "encoding/binary"
+)
+
+func unpack565(c uint16) color.NRGBA {
+	r, g, b := uint8(c >> 11 & 0x1f), uint8(c >> 5 & 0x3f), uint8(c & 0x1f)
+	return color.NRGBA{ r << 3 | r >> 2, g << 2 | g >> 4, b << 3 | b >> 2, 0xff }
+}
+
+func pack565(c color.NRGBA) uint16 {
+	return uint16(c.R >> 3) << 11 | uint16(c.G >> 2) << 5 | uint16(c.B >> 3)
+}
+
+func mix(a, b uint8, wa, wb, d int) uint8 {
+	return uint8((int(a) * wa + int(b) * wb) / d)
+}
+
+// The four entries of a colour block's palette. Blocks with c0 <= c1 have three colours and transparent black,
+// unless opaque is set (DXT3 and DXT5 always use four colours).
+func colorPalette(c0, c1 uint16, opaque bool) (palette [4]color.NRGBA) {
+	palette[0], palette[1] = unpack565(c0), unpack565(c1)
+	a, b := palette[0], palette[1]
+
+	if c0 > c1 || opaque {
+		palette[2] = color.NRGBA{ mix(a.R, b.R, 2, 1, 3), mix(a.G, b.G, 2, 1, 3), mix(a.B, b.B, 2, 1, 3), 0xff }
+		palette[3] = color.NRGBA{ mix(a.R, b.R, 1, 2, 3), mix(a.G, b.G, 1, 2, 3), mix(a.B, b.B, 1, 2, 3), 0xff }
+	} else {
+		palette[2] = color.NRGBA{ mix(a.R, b.R, 1, 1, 2), mix(a.G, b.G, 1, 1, 2), mix(a.B, b.B, 1, 1, 2), 0xff }
+	}
+
+	return
+}
+
+func alphaPalette(a0, a1 uint8) (palette [8]uint8) {
+	palette[0], palette[1] = a0, a1
+
+	if a0 > a1 {
+		for i := 1; i < 7; i++ {
+			palette[i + 1] = mix(a0, a1, 7 - i, i, 7)
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			palette[i + 1] = mix(a0, a1, 5 - i, i, 5)
+		}
+		palette[6], palette[7] = 0, 0xff
+	}
+
+	return
+}
+
+func decodeColorBlock(block []uint8, pixels *[16]color.NRGBA, opaque bool) {
+	palette := colorPalette(bin.LittleEndian.Uint16(block), bin.LittleEndian.Uint16(block[2:]), opaque)
+	indices := bin.LittleEndian.Uint32(block[4:])
+
+	for i := range pixels {
+		pixels[i] = palette[indices >> uint(i * 2) & 3]
+	}
+}
+
+// decodeBlock unpacks a 4x4 block of pixels, in rows
+func decodeBlock(format Format, block []uint8, pixels *[16]color.NRGBA) {
+	switch format {
+		case FormatDXT1:
+			decodeColorBlock(block, pixels, false)
+
+		case FormatDXT3:
+			decodeColorBlock(block[8:], pixels, true)
+
+			alpha := bin.LittleEndian.Uint64(block)
+			for i := range pixels {
+				a := uint8(alpha >> uint(i * 4) & 0xf)
+				pixels[i].A = a << 4 | a
+			}
+
+		case FormatDXT5:
+			decodeColorBlock(block[8:], pixels, true)
+
+			palette := alphaPalette(block[0], block[1])
+			var indices uint64
+			for i := 7; i >= 2; i-- {
+				indices = indices << 8 | uint64(block[i])
+			}
+
+			for i := range pixels {
+				pixels[i].A = palette[indices >> uint(i * 3) & 7]
+			}
+	}
+}
+
+func colorDistance(a, b color.NRGBA) int {
+	dr, dg, db := int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B)
+	return dr * dr + dg * dg + db * db
+}
+
+// Encodes colours by fitting them between the corners of their bounding box
+func encodeColorBlock(block []uint8, pixels *[16]color.NRGBA, opaque bool) {
+	transparent := false
+	min, max := color.NRGBA{ 0xff, 0xff, 0xff, 0xff }, color.NRGBA{}
+	for _, c := range pixels {
+		if !opaque && c.A < 0x80 {
+			transparent = true
+			continue
+		}
+
+		if c.R < min.R { min.R = c.R }
+		if c.G < min.G { min.G = c.G }
+		if c.B < min.B { min.B = c.B }
+		if c.R > max.R { max.R = c.R }
+		if c.G > max.G { max.G = c.G }
+		if c.B > max.B { max.B = c.B }
+	}
+
+	c0, c1 := pack565(max), pack565(min)
+	if transparent {
+		// Three colour mode keeps index 3 for transparent pixels
+		c0, c1 = c1, c0
+		if max.R < min.R {
+			c0, c1 = 0, 0
+		}
+	} else if c0 < c1 {
+		c0, c1 = c1, c0
+	}
+
+	palette := colorPalette(c0, c1, opaque)
+	colors := 4
+	if !opaque && c0 <= c1 {
+		colors = 3
+	}
+
+	var indices uint32
+	for i, c := range pixels {
+		best := 3
+		if !transparent || c.A >= 0x80 {
+			best = 0
+			for p := 1; p < colors; p++ {
+				if colorDistance(c, palette[p]) < colorDistance(c, palette[best]) {
+					best = p
+				}
+			}
+		}
+		indices |= uint32(best) << uint(i * 2)
+	}
+
+	bin.LittleEndian.PutUint16(block, c0)
+	bin.LittleEndian.PutUint16(block[2:], c1)
+	bin.LittleEndian.PutUint32(block[4:], indices)
+}
+
+// encodeBlock packs a 4x4 block of pixels, in rows
+func encodeBlock(format Format, block []uint8, pixels *[16]color.NRGBA) {
+	switch format {
+		case FormatDXT1:
+			encodeColorBlock(block, pixels, false)
+
+		case FormatDXT3:
+			var alpha uint64
+			for i, c := range pixels {
+				alpha |= uint64(c.A >> 4) << uint(i * 4)
+			}
+			bin.LittleEndian.PutUint64(block, alpha)
+
+			encodeColorBlock(block[8:], pixels, true)
+
+		case FormatDXT5:
+			a0, a1 := uint8(0), uint8(0xff)
+			for _, c := range pixels {
+				if c.A > a0 { a0 = c.A }
+				if c.A < a1 { a1 = c.A }
+			}
+
+			palette := alphaPalette(a0, a1)
+			var indices uint64
+			for i, c := range pixels {
+				best := 0
+				for p := 1; p < len(palette); p++ {
+					if absDiff(c.A, palette[p]) < absDiff(c.A, palette[best]) {
+						best = p
+					}
+				}
+				indices |= uint64(best) << uint(i * 3)
+			}
+
+			block[0], block[1] = a0, a1
+			for i := 2; i < 8; i++ {
+				block[i] = uint8(indices >> uint((i - 2) * 8))
+			}
+
+			encodeColorBlock(block[8:], pixels, true)
+	}
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
@@ -0,0 +1,216 @@
+package texture
+
+import (
+	"io"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/color"
+	bin "encoding/binary"
+)
+
+// Options are the encoding parameters
+type Options struct {
+	Format Format
+
+	// Generate a full chain of mipmaps, down to 1x1
+	MipMaps bool
+
+	// Stops the chain early if non-zero, for matching an existing texture
+	MipMapCount int
+
+	// The pixel size and channel masks of FormatRGB textures, also for matching an existing texture. Pixels are
+	// stored as 32-bit BGRA if BitCount is zero.
+	BitCount int
+	RMask, GMask, BMask, AMask uint32
+}
+
+// Scales an 8 bit channel to a mask, the reverse of maskChannel
+func packChannel(value uint8, mask uint32) uint32 {
+	if mask == 0 {
+		return 0
+	}
+
+	shift := maskShift(mask)
+	max := uint64(mask >> shift)
+	return uint32((uint64(value) * max + 0x7f) / 0xff) << shift
+}
+
+func toNRGBA(m image.Image) *image.NRGBA {
+	if img, ok := m.(*image.NRGBA); ok && img.Bounds().Min == (image.Point{}) {
+		return img
+	}
+
+	bounds := m.Bounds()
+	img := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Bounds(), m, bounds.Min, draw.Src)
+	return img
+}
+
+// Halves an image with a box filter
+func downsample(img *image.NRGBA) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	small := image.NewNRGBA(image.Rect(0, 0, (w + 1) / 2, (h + 1) / 2))
+
+	for y := 0; y < small.Bounds().Dy(); y++ {
+		for x := 0; x < small.Bounds().Dx(); x++ {
+			var sum [4]int
+			count := 0
+			for dy := 0; dy < 2; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					if sx, sy := x * 2 + dx, y * 2 + dy; sx < w && sy < h {
+						c := img.NRGBAAt(sx, sy)
+						sum[0] += int(c.R)
+						sum[1] += int(c.G)
+						sum[2] += int(c.B)
+						sum[3] += int(c.A)
+						count++
+					}
+				}
+			}
+
+			small.SetNRGBA(x, y, color.NRGBA{ uint8(sum[0] / count), uint8(sum[1] / count), uint8(sum[2] / count), uint8(sum[3] / count) })
+		}
+	}
+
+	return small
+}
+
+func writeLevel(writer io.Writer, img *image.NRGBA, o *Options) error {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	format := o.Format
+
+	if format == FormatRGB {
+		size := o.BitCount / 8
+		row := make([]uint8, w * size)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				c := img.NRGBAAt(x, y)
+				pixel := packChannel(c.R, o.RMask) | packChannel(c.G, o.GMask) | packChannel(c.B, o.BMask) | packChannel(c.A, o.AMask)
+				for i := 0; i < size; i++ {
+					row[x * size + i] = uint8(pixel >> uint(i * 8))
+				}
+			}
+
+			if _, err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	block := make([]uint8, format.blockSize())
+	var pixels [16]color.NRGBA
+	for by := 0; by < h; by += 4 {
+		for bx := 0; bx < w; bx += 4 {
+			// Pixels past the edge repeat the last row or column
+			for i := range pixels {
+				x, y := bx + i % 4, by + i / 4
+				if x >= w {
+					x = w - 1
+				}
+				if y >= h {
+					y = h - 1
+				}
+				pixels[i] = img.NRGBAAt(x, y)
+			}
+
+			encodeBlock(format, block, &pixels)
+			if _, err := writer.Write(block); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Encode writes an image as a DDS texture
+func Encode(writer io.Writer, m image.Image, o *Options) error {
+	if o == nil {
+		o = &Options{}
+	}
+
+	if o.Format == FormatRGB {
+		layout := *o
+		if layout.BitCount == 0 {
+			layout.BitCount = 32
+			layout.RMask, layout.GMask, layout.BMask, layout.AMask = 0xff0000, 0xff00, 0xff, 0xff000000
+		}
+
+		if layout.BitCount % 8 != 0 || layout.BitCount < 8 || layout.BitCount > 32 {
+			return fmt.Errorf("unsupported DDS pixel size %d", layout.BitCount)
+		}
+
+		if mask := layout.RMask | layout.GMask | layout.BMask | layout.AMask; uint64(mask) >> uint(layout.BitCount) != 0 {
+			return fmt.Errorf("DDS channel masks 0x%x don't fit in %d bits", mask, layout.BitCount)
+		}
+		o = &layout
+	}
+
+	img := toNRGBA(m)
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	levels := 1
+	if o.MipMaps {
+		for size := w | h; size > 1; size >>= 1 {
+			levels++
+		}
+
+		if o.MipMapCount > 0 && o.MipMapCount < levels {
+			levels = o.MipMapCount
+		}
+	}
+
+	header := ddsHeader{
+		Size: ddsHeaderSize,
+		Flags: ddsdCaps | ddsdHeight | ddsdWidth | ddsdPixelFormat,
+		Height: uint32(h),
+		Width: uint32(w),
+		Caps: ddscapsTexture,
+	}
+	header.PixelFormat.Size = ddsPixelFormatSize
+
+	if o.Format == FormatRGB {
+		header.Flags |= ddsdPitch
+		header.PitchOrLinearSize = uint32(w * o.BitCount / 8)
+		header.PixelFormat.Flags = ddpfRGB
+		if o.AMask != 0 {
+			header.PixelFormat.Flags |= ddpfAlphaPixels
+		}
+		header.PixelFormat.RGBBitCount = uint32(o.BitCount)
+		header.PixelFormat.RBitMask, header.PixelFormat.GBitMask, header.PixelFormat.BBitMask, header.PixelFormat.ABitMask = o.RMask, o.GMask, o.BMask, o.AMask
+	} else {
+		header.Flags |= ddsdLinearSize
+		header.PitchOrLinearSize = uint32(((w + 3) / 4) * ((h + 3) / 4) * o.Format.blockSize())
+		header.PixelFormat.Flags = ddpfFourCC
+		copy(header.PixelFormat.FourCC[:], o.Format.String())
+	}
+
+	if levels > 1 {
+		header.Flags |= ddsdMipMapCount
+		header.MipMapCount = uint32(levels)
+		header.Caps |= ddscapsComplex | ddscapsMipMap
+	}
+
+	if _, err := io.WriteString(writer, ddsMagic); err != nil {
+		return err
+	}
+
+	if err := bin.Write(writer, bin.LittleEndian, &header); err != nil {
+		return err
+	}
+
+	for level := 0; level < levels; level++ {
+		if level > 0 {
+			img = downsample(img)
+		}
+
+		if err := writeLevel(writer, img, o); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package texture
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestEncodeMipMapCount(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 37, 21))
+
+	for _, c := range []struct {
+		options Options
+		levels int
+	}{
+		{ Options{ Format: FormatDXT5 }, 1 },
+		{ Options{ Format: FormatDXT5, MipMaps: true }, 6 },
+		{ Options{ Format: FormatDXT1, MipMaps: true, MipMapCount: 3 }, 3 },
+		{ Options{ Format: FormatRGB, MipMaps: true, MipMapCount: 10 }, 6 },
+	} {
+		var buffer bytes.Buffer
+		if err := Encode(&buffer, img, &c.options); err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := ReadHeader(bytes.NewReader(buffer.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if h.Format != c.options.Format || h.MipMapCount != c.levels {
+			t.Errorf("%+v: encoded as %v with %d levels", c.options, h.Format, h.MipMapCount)
+		}
+	}
+}
+
+func TestEncodeRGBLayout(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 37)
+	}
+
+	for _, o := range []Options{
+		{ Format: FormatRGB },
+		{ Format: FormatRGB, BitCount: 24, RMask: 0xff0000, GMask: 0xff00, BMask: 0xff },
+		{ Format: FormatRGB, BitCount: 16, RMask: 0xf800, GMask: 0x07e0, BMask: 0x001f },
+		{ Format: FormatRGB, BitCount: 16, RMask: 0x0f00, GMask: 0x00f0, BMask: 0x000f, AMask: 0xf000 },
+	} {
+		var buffer bytes.Buffer
+		if err := Encode(&buffer, img, &o); err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := ReadHeader(bytes.NewReader(buffer.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bitCount := o.BitCount
+		if bitCount == 0 {
+			bitCount = 32
+		}
+		if h.BitCount != bitCount || (o.BitCount != 0 && (h.RMask != o.RMask || h.GMask != o.GMask || h.BMask != o.BMask || h.AMask != o.AMask)) {
+			t.Errorf("%+v: encoded as %+v", o, h)
+		}
+
+		if expected := 4 + 124 + 5 * 3 * bitCount / 8; buffer.Len() != expected {
+			t.Errorf("%+v: %d bytes, expected %d", o, buffer.Len(), expected)
+		}
+
+		// Decoding gives back the image, to the precision of the channel masks
+		decoded, err := Decode(bytes.NewReader(buffer.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, v := range decoded.(*image.NRGBA).Pix {
+			expected := int(img.Pix[i])
+			if i % 4 == 3 && o.BitCount != 0 && o.AMask == 0 {
+				expected = 0xff
+			}
+
+			if d := int(v) - expected; d > 0x11 || d < -0x11 {
+				t.Errorf("%+v: byte %d decoded as 0x%02x, expected 0x%02x", o, i, v, expected)
+				break
+			}
+		}
+	}
+
+	if err := Encode(&bytes.Buffer{}, img, &Options{ Format: FormatRGB, BitCount: 16, RMask: 0xff0000 }); err == nil {
+		t.Error("mask wider than the pixel size accepted")
+	}
+}
@@ -1,18 +1,23 @@
 package cmd
 
 import (
+	"io"
 	"os"
+	"fmt"
 	"path"
 	"time"
 	"sync"
 	"bufio"
 	"errors"
+	"strconv"
 	"io/ioutil"
+	"encoding/csv"
 	"github.com/cheggaaa/pb"
 	"aaronlindsay.com/go/pkg/pso2/ice"
 	"aaronlindsay.com/go/pkg/pso2/text"
 	"aaronlindsay.com/go/pkg/pso2/util"
 	"aaronlindsay.com/go/pkg/pso2/trans"
+	"aaronlindsay.com/go/pkg/pso2/names"
 )
 
 func StripDatabase(dbpath, flagStrip string) (err error) {
@@ -33,7 +38,136 @@ func StripDatabase(dbpath, flagStrip string) (err error) {
 	return
 }
 
-func PatchFiles(db *trans.Database, pso2dir, translationName, backupPath, outputPath string, parallel int) (errs []error) {
+// MarkStale reads a csv of changes from `pso2-text diff -o`, and marks the translations of changed and removed
+// strings as stale. Archives may be given by hash, or by logical name if dict lists them, like on the pso2-trans
+// command line. Strings that are not in the database are ignored.
+func MarkStale(db *trans.Database, diffPath string, dict *names.Dictionary) (marked int, errs []error) {
+	f, err := os.Open(diffPath)
+	if err != nil {
+		return 0, []error{err}
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return 0, []error{err}
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[name] = i
+	}
+
+	for _, name := range []string{ "archive", "file", "identifier", "collision", "change" } {
+		if _, ok := columns[name]; !ok {
+			return 0, []error{fmt.Errorf("%s: column `%s` missing", diffPath, name)}
+		}
+	}
+
+	db.Begin()
+	defer db.End()
+
+	for {
+		line, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return marked, append(errs, err)
+		}
+
+		if change := line[columns["change"]]; change != "changed" && change != "removed" {
+			continue
+		}
+
+		hash, err := dict.Lookup(line[columns["archive"]])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		aname := (*trans.ArchiveName)(&hash)
+
+		collision, err := strconv.Atoi(line[columns["collision"]])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		a, err := db.QueryArchive(aname)
+		if err != nil || a == nil {
+			errs = appendError(errs, err)
+			continue
+		}
+
+		file, err := db.QueryFile(a, line[columns["file"]])
+		if err != nil || file == nil {
+			errs = appendError(errs, err)
+			continue
+		}
+
+		s, err := db.QueryString(file, collision, line[columns["identifier"]])
+		if err != nil || s == nil {
+			errs = appendError(errs, err)
+			continue
+		}
+
+		if err = db.MarkStringStale(s); err != nil {
+			errs = append(errs, err)
+		} else {
+			marked++
+		}
+	}
+
+	return
+}
+
+func appendError(errs []error, err error) []error {
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// StaleMode decides what PatchFiles does with translations whose source string has changed since
+type StaleMode int
+
+const (
+	StaleApply StaleMode = iota
+	StaleWarn
+	StaleSkip
+)
+
+func ParseStaleMode(value string) (StaleMode, error) {
+	switch value {
+		case "apply":
+			return StaleApply, nil
+		case "warn":
+			return StaleWarn, nil
+		case "skip":
+			return StaleSkip, nil
+	}
+
+	return StaleApply, errors.New("stale mode must be apply, warn or skip")
+}
+
+// A StaleWarning reports the stale translations found in a file, for StaleWarn and StaleSkip
+type StaleWarning struct {
+	Archive, File string
+	Count int
+	Skipped bool
+}
+
+func (w *StaleWarning) Error() string {
+	action := "applied"
+	if w.Skipped {
+		action = "skipped"
+	}
+
+	return fmt.Sprintf("%s/%s: %d stale translations %s", w.Archive, w.File, w.Count, action)
+}
+
+func PatchFiles(db *trans.Database, pso2dir, translationName, backupPath, outputPath string, parallel int, stale StaleMode) (errs []error) {
 	translation, err := db.QueryTranslation(translationName)
 	if err == nil && translation == nil {
 		err = errors.New("translation not found")
@@ -115,6 +249,7 @@ func PatchFiles(db *trans.Database, pso2dir, translationName, backupPath, output
 					textfile, err := text.NewTextFile(file.Data)
 
 					collisions := make(map[string]int)
+					staleCount := 0
 
 					for _, p := range textfile.Pairs {
 						collision := collisions[p.Identifier]
@@ -131,6 +266,13 @@ func PatchFiles(db *trans.Database, pso2dir, translationName, backupPath, output
 							continue
 						}
 
+						if ts.Stale {
+							staleCount++
+							if stale == StaleSkip {
+								continue
+							}
+						}
+
 						if p.String != ts.Translation {
 							entry := textfile.PairString(&p)
 							entry.Text = ts.Translation
@@ -138,6 +280,10 @@ func PatchFiles(db *trans.Database, pso2dir, translationName, backupPath, output
 						}
 					}
 
+					if staleCount > 0 && stale != StaleApply {
+						complain(&StaleWarning{ a.Name.String(), f.Name, staleCount, stale == StaleSkip })
+					}
+
 					tf, err := ioutil.TempFile("", "")
 					if complain(err) {
 						continue
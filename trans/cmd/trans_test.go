@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+	"path/filepath"
+	"aaronlindsay.com/go/pkg/pso2/trans"
+	"aaronlindsay.com/go/pkg/pso2/names"
+)
+
+// Archives in the diff can be named by hash, or by a logical name the dictionary knows
+func TestMarkStaleNames(t *testing.T) {
+	dir := t.TempDir()
+	db, err := trans.NewDatabase(filepath.Join(dir, "trans.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dict := names.NewDictionary()
+	hash := dict.Add("logical")
+	a, err := db.InsertArchive((*trans.ArchiveName)(&hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := db.InsertFile(a, "file.text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := db.InsertTranslation("eng")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{ "a", "b" } {
+		s, err := db.InsertString(f, 1, 0, id, "original")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = db.InsertTranslationString(tr, s, "translated"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diff := filepath.Join(dir, "diff.csv")
+	err = os.WriteFile(diff, []uint8("archive,file,identifier,collision,change,old,new\n" +
+		hash.String() + ",file.text,a,0,changed,original,new\n" +
+		"logical,file.text,b,0,removed,original,\n" +
+		"unknown,file.text,b,0,removed,original,\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marked, errs := MarkStale(db, diff, dict)
+	if marked != 2 || len(errs) != 1 {
+		t.Errorf("marked %d, errors %v", marked, errs)
+	}
+
+	if stale, err := db.QueryStaleStrings(tr); err != nil || len(stale) != 2 {
+		t.Errorf("stale strings %+v, %v", stale, err)
+	}
+}
@@ -39,11 +39,49 @@ const sqlCreateTables = `
 	CREATE TABLE IF NOT EXISTS translationstrings (
 		translationid INTEGER REFERENCES translations(translationid) NOT NULL,
 		stringid INTEGER REFERENCES strings(stringid) NOT NULL,
-		translation TEXT NOT NULL
+		translation TEXT NOT NULL,
+		stale INTEGER NOT NULL DEFAULT 0
 	);
 	CREATE UNIQUE INDEX IF NOT EXISTS translationstrings_index ON translationstrings(translationid, stringid);
+
+	CREATE TABLE IF NOT EXISTS stringhistory (
+		stringid INTEGER REFERENCES strings(stringid) NOT NULL,
+		version INTEGER NOT NULL,
+		value TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS stringhistory_index ON stringhistory(stringid);
 `
 
+// Databases created before translations could go stale are missing the column
+func (d *Database) upgrade() error {
+	rows, err := d.db.Query("PRAGMA table_info(translationstrings)")
+	if err != nil {
+		return err
+	}
+
+	stale := false
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, ctype string
+		var dflt interface{}
+		if err = rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if name == "stale" {
+			stale = true
+		}
+	}
+	rows.Close()
+
+	if !stale {
+		_, err = d.db.Exec("ALTER TABLE translationstrings ADD COLUMN stale INTEGER NOT NULL DEFAULT 0")
+	}
+
+	return err
+}
+
 
 func NewDatabase(path string) (*Database, error){
 	db, err := sql.Open("sqlite3", path)
@@ -52,9 +90,12 @@ func NewDatabase(path string) (*Database, error){
 		return nil, err
 	}
 
-	_, err = db.Exec(sqlCreateTables)
+	d := &Database{db}
+	if _, err = db.Exec(sqlCreateTables); err != nil {
+		return d, err
+	}
 
-	return &Database{db}, err
+	return d, d.upgrade()
 }
 
 func (d *Database) Close() {
@@ -241,7 +282,22 @@ func (d *Database) InsertString(f *File, version, collision int, identifier, val
 	return
 }
 
+// UpdateString replaces the source value of a string. When the value changes, the old one is kept in the
+// string's history and every translation of it is marked stale. Strings cleared by Strip have no value to compare
+// against, so they are just refilled.
 func (d *Database) UpdateString(f *String, version int, value string) (s *String, err error) {
+	stripped := f.Version == 0 || f.Value == ""
+	if value != f.Value && !stripped {
+		_, err = d.exec("INSERT INTO stringhistory (stringid, version, value) VALUES (?, ?, ?)", f.id, f.Version, f.Value)
+		if err != nil {
+			return
+		}
+
+		if err = d.MarkStringStale(f); err != nil {
+			return
+		}
+	}
+
 	_, err = d.exec("UPDATE strings SET version = ?, value = ? WHERE stringid = ?", version, value, f.id)
 	if err != nil {
 		return
@@ -292,8 +348,8 @@ func (d *Database) QueryStringTranslation(t *TranslationString) (s *String, err
 	return
 }
 
-func (d *Database) QueryStrings() (strings []String, err error) {
-	rows, err := d.query(sqlQueryString)
+func (d *Database) queryStrings(query string, args ...interface{}) (strings []String, err error) {
+	rows, err := d.query(query, args...)
 	if err != nil {
 		return
 	}
@@ -312,6 +368,52 @@ func (d *Database) QueryStrings() (strings []String, err error) {
 	return
 }
 
+func (d *Database) QueryStrings() (strings []String, err error) {
+	return d.queryStrings(sqlQueryString)
+}
+
+// QueryStaleStrings lists the strings whose translation changed source since it was written
+func (d *Database) QueryStaleStrings(t *Translation) (strings []String, err error) {
+	return d.queryStrings(`
+		SELECT s.stringid, s.fileid, s.version, s.collision, s.identifier, s.value
+		FROM translationstrings AS ts
+			JOIN strings AS s ON s.stringid = ts.stringid
+		WHERE ts.translationid = ? AND ts.stale != 0`, t.id)
+}
+
+// MarkStringStale flags every translation of a string as written against an outdated source value
+func (d *Database) MarkStringStale(f *String) (err error) {
+	_, err = d.exec("UPDATE translationstrings SET stale = 1 WHERE stringid = ?", f.id)
+	return
+}
+
+type StringHistory struct {
+	stringid int64
+	DB *Database
+	Version int
+	Value string
+}
+
+// QueryStringHistory lists the previous source values of a string, oldest first
+func (d *Database) QueryStringHistory(f *String) (history []StringHistory, err error) {
+	rows, err := d.query("SELECT stringid, version, value FROM stringhistory WHERE stringid = ? ORDER BY rowid", f.id)
+	if err != nil {
+		return
+	}
+
+	for rows.Next() {
+		h := StringHistory{ DB: d }
+		if err = rows.Scan(&h.stringid, &h.Version, &h.Value); err != nil {
+			break
+		}
+
+		history = append(history, h)
+	}
+
+	rows.Close()
+	return
+}
+
 func (d *Database) InsertTranslation(name string) (t *Translation, err error) {
 	result, err := d.exec("INSERT INTO translations (name) VALUES (?)", name)
 	if err != nil {
@@ -376,27 +478,28 @@ func (d *Database) InsertTranslationString(t *Translation, f *String, translatio
 		return
 	}
 
-	s = &TranslationString{f.id, t.id, d, translation}
+	s = &TranslationString{f.id, t.id, d, translation, false}
 
 	return
 }
 
+// UpdateTranslationString replaces a translation, which is then current with its source again
 func (d *Database) UpdateTranslationString(f *TranslationString, value string) (s *TranslationString, err error) {
-	_, err = d.exec("UPDATE translationstrings SET translation = ? WHERE translationid = ? AND stringid = ?", value, f.translationid, f.stringid)
+	_, err = d.exec("UPDATE translationstrings SET translation = ?, stale = 0 WHERE translationid = ? AND stringid = ?", value, f.translationid, f.stringid)
 	if err != nil {
 		return
 	}
 
-	s = &TranslationString{f.translationid, f.stringid, d, value}
+	s = &TranslationString{f.stringid, f.translationid, d, value, false}
 
 	return
 }
 
-const sqlQueryTranslationString = "SELECT translationid, stringid, translation FROM translationstrings "
+const sqlQueryTranslationString = "SELECT translationid, stringid, translation, stale FROM translationstrings "
 func (d *Database) queryTranslationString(rows *sql.Rows) (s *TranslationString, err error) {
 	s = &TranslationString{}
 	s.DB = d
-	err = rows.Scan(&s.translationid, &s.stringid, &s.Translation)
+	err = rows.Scan(&s.translationid, &s.stringid, &s.Translation, &s.Stale)
 	if err != nil {
 		s = nil
 	}
@@ -439,12 +542,12 @@ func (d *Database) QueryTranslationString(t *Translation, f *String) (s *Transla
 }
 
 func (d *Database) QueryTranslationStrings(t *Translation) (strings []TranslationString, err error) {
-	return d.queryTranslationStrings(sqlQueryTranslationString + "WHERE translationid = ?", t)
+	return d.queryTranslationStrings(sqlQueryTranslationString + "WHERE translationid = ?", t.id)
 }
 
 func (d *Database) QueryTranslationStringsFile(t *Translation, f *File) (strings []TranslationString, err error) {
 	return d.queryTranslationStrings(`
-		SELECT ts.translationid, ts.stringid, ts.translation
+		SELECT ts.translationid, ts.stringid, ts.translation, ts.stale
 		FROM translationstrings AS ts
 			JOIN strings AS s ON s.stringid = ts.stringid
 		WHERE ts.translationid = ? AND s.fileid = ?`, t.id, f.id)
@@ -453,6 +556,7 @@ func (d *Database) QueryTranslationStringsFile(t *Translation, f *File) (strings
 func (d *Database) Strip() (err error) {
 	_, err = d.db.Exec(`
 		UPDATE strings SET value = '', version = 0;
+		DELETE FROM stringhistory;
 		DELETE FROM strings WHERE NOT stringid IN (SELECT stringid FROM translationstrings);
 		DELETE FROM files WHERE NOT fileid IN (SELECT fileid FROM strings);
 		DELETE FROM archives WHERE NOT archiveid IN (SELECT archiveid FROM files);
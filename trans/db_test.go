@@ -0,0 +1,186 @@
+package trans
+
+import (
+	"testing"
+	"database/sql"
+	"path/filepath"
+)
+
+func testDatabase(t *testing.T) *Database {
+	d, err := NewDatabase(filepath.Join(t.TempDir(), "trans.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+// A translation with one string, ready to have its source updated
+func testString(t *testing.T, d *Database, value string) (*Translation, *String) {
+	var name ArchiveName
+	a, err := d.InsertArchive(&name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := d.InsertFile(a, "file.text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := d.InsertString(f, 1, 0, "id", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := d.InsertTranslation("eng")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = d.InsertTranslationString(tr, s, "translated"); err != nil {
+		t.Fatal(err)
+	}
+
+	return tr, s
+}
+
+func TestUpgrade(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+
+	// The translationstrings table as it was before the stale column
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`
+		CREATE TABLE translationstrings (
+			translationid INTEGER NOT NULL,
+			stringid INTEGER NOT NULL,
+			translation TEXT NOT NULL
+		);
+		INSERT INTO translationstrings VALUES (1, 1, 'existing');
+	`)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening twice checks that an upgraded database isn't altered again
+	for i := 0; i < 2; i++ {
+		d, err := NewDatabase(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stale int
+		if err = d.db.QueryRow("SELECT stale FROM translationstrings WHERE stringid = 1").Scan(&stale); err != nil || stale != 0 {
+			t.Errorf("existing translation: stale %d, %v", stale, err)
+		}
+
+		d.Close()
+	}
+}
+
+func TestUpdateStringStale(t *testing.T) {
+	d := testDatabase(t)
+	defer d.Close()
+
+	tr, s := testString(t, d, "original")
+
+	s, err := d.UpdateString(s, 2, "original")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale, _ := d.QueryStaleStrings(tr); len(stale) != 0 {
+		t.Errorf("unchanged string marked stale")
+	}
+
+	if s, err = d.UpdateString(s, 3, "changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := d.QueryStaleStrings(tr)
+	if err != nil || len(stale) != 1 || stale[0].Value != "changed" {
+		t.Errorf("stale strings %+v, %v", stale, err)
+	}
+
+	history, err := d.QueryStringHistory(s)
+	if err != nil || len(history) != 1 || history[0].Value != "original" || history[0].Version != 2 {
+		t.Errorf("history %+v, %v", history, err)
+	}
+
+	// Updating the translation brings it up to date
+	ts, err := d.QueryTranslationString(tr, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.UpdateTranslationString(ts, "retranslated"); err != nil {
+		t.Fatal(err)
+	}
+	if stale, _ := d.QueryStaleStrings(tr); len(stale) != 0 {
+		t.Errorf("retranslated string still stale")
+	}
+}
+
+// Strip empties every string, and refilling them isn't a change in the source
+func TestUpdateStrippedString(t *testing.T) {
+	d := testDatabase(t)
+	defer d.Close()
+
+	tr, _ := testString(t, d, "original")
+
+	if err := d.Strip(); err != nil {
+		t.Fatal(err)
+	}
+
+	strings, err := d.QueryStrings()
+	if err != nil || len(strings) != 1 || strings[0].Value != "" || strings[0].Version != 0 {
+		t.Fatalf("stripped strings %+v, %v", strings, err)
+	}
+
+	s, err := d.UpdateString(&strings[0], 4, "original")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stale, _ := d.QueryStaleStrings(tr); len(stale) != 0 {
+		t.Errorf("refilled string marked stale")
+	}
+
+	if history, _ := d.QueryStringHistory(s); len(history) != 0 {
+		t.Errorf("stripped value kept in history: %+v", history)
+	}
+}
+
+// Translation strings are keyed by both ids, which only differ once there is more than one translation
+func TestTranslationStrings(t *testing.T) {
+	d := testDatabase(t)
+	defer d.Close()
+
+	if _, err := d.InsertTranslation("other"); err != nil {
+		t.Fatal(err)
+	}
+	tr, s := testString(t, d, "original")
+
+	ts, err := d.QueryTranslationString(tr, s)
+	if err != nil || ts == nil {
+		t.Fatalf("translation string %+v, %v", ts, err)
+	}
+
+	// The returned value can be updated again
+	for _, value := range []string{ "first", "second" } {
+		if ts, err = d.UpdateTranslationString(ts, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	strings, err := d.QueryTranslationStrings(tr)
+	if err != nil || len(strings) != 1 || strings[0].Translation != "second" {
+		t.Fatalf("translation strings %+v, %v", strings, err)
+	}
+
+	if str, err := d.QueryStringTranslation(&strings[0]); err != nil || str == nil || str.Value != "original" {
+		t.Errorf("source string %+v, %v", str, err)
+	}
+}
@@ -40,6 +40,9 @@ type TranslationString struct {
 	translationid int64
 	DB *Database
 	Translation string
+
+	// The source string changed after this was translated
+	Stale bool
 }
 
 func (a *ArchiveName) String() string {
@@ -0,0 +1,173 @@
+package util
+
+import (
+	"io"
+	"sort"
+	"time"
+	"path"
+	"io/fs"
+)
+
+// FSEntry describes a file to expose through NewFS. Entries without Data are treated as directories
+type FSEntry struct {
+	Path string
+	Size int64
+	Data io.ReaderAt
+	Sys interface{}
+}
+
+type fsNode struct {
+	name string
+	entry *FSEntry
+	children []*fsNode
+}
+
+type fsTree struct {
+	nodes map[string]*fsNode
+}
+
+// NewFS builds a read-only fs.FS out of a snapshot of archive entries. Parent directories are created as needed.
+func NewFS(entries []FSEntry) fs.FS {
+	t := &fsTree{ map[string]*fsNode{ ".": &fsNode{ name: "." } } }
+
+	for i := range entries {
+		entry := &entries[i]
+		if !fs.ValidPath(entry.Path) || entry.Path == "." {
+			continue
+		}
+
+		node := t.node(entry.Path)
+		if node.entry == nil {
+			node.entry = entry
+		}
+	}
+
+	for _, node := range t.nodes {
+		sort.Slice(node.children, func(i, j int) bool {
+			return node.children[i].name < node.children[j].name
+		})
+	}
+
+	return t
+}
+
+func (t *fsTree) node(name string) *fsNode {
+	if node, ok := t.nodes[name]; ok {
+		return node
+	}
+
+	node := &fsNode{ name: path.Base(name) }
+	t.nodes[name] = node
+
+	parent := t.node(path.Dir(name))
+	parent.children = append(parent.children, node)
+
+	return node
+}
+
+func (t *fsTree) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{ Op: "open", Path: name, Err: fs.ErrInvalid }
+	}
+
+	node, ok := t.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{ Op: "open", Path: name, Err: fs.ErrNotExist }
+	}
+
+	if node.isDir() {
+		return &fsDir{ node: node }, nil
+	}
+
+	return &fsFile{ io.NewSectionReader(node.entry.Data, 0, node.entry.Size), node }, nil
+}
+
+func (n *fsNode) isDir() bool {
+	return n.entry == nil || n.entry.Data == nil
+}
+
+func (n *fsNode) Name() string {
+	return n.name
+}
+
+func (n *fsNode) Size() int64 {
+	if n.isDir() {
+		return 0
+	}
+
+	return n.entry.Size
+}
+
+func (n *fsNode) Mode() fs.FileMode {
+	if n.isDir() {
+		return fs.ModeDir | 0555
+	}
+
+	return 0444
+}
+
+func (n *fsNode) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (n *fsNode) IsDir() bool {
+	return n.isDir()
+}
+
+func (n *fsNode) Sys() interface{} {
+	if n.entry == nil {
+		return nil
+	}
+
+	return n.entry.Sys
+}
+
+type fsFile struct {
+	*io.SectionReader
+	node *fsNode
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.node, nil
+}
+
+func (f *fsFile) Close() error {
+	return nil
+}
+
+type fsDir struct {
+	node *fsNode
+	offset int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.node, nil
+}
+
+func (d *fsDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{ Op: "read", Path: d.node.name, Err: fs.ErrInvalid }
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+func (d *fsDir) ReadDir(count int) (entries []fs.DirEntry, err error) {
+	children := d.node.children[d.offset:]
+	if count > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+
+		if count < len(children) {
+			children = children[:count]
+		}
+	}
+
+	for _, child := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(child))
+	}
+	d.offset += len(children)
+
+	return
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNewFS(t *testing.T) {
+	fsys := NewFS([]FSEntry{
+		{ Path: "a/b/c.txt", Size: 3, Data: strings.NewReader("abc") },
+		{ Path: "a/d.txt", Size: 0, Data: strings.NewReader("") },
+		{ Path: "empty" },
+		{ Path: "top.txt", Size: 5, Data: strings.NewReader("hello"), Sys: 42 },
+
+		// Invalid paths are left out, and the first of two entries with the same path wins
+		{ Path: "../escape", Size: 1, Data: strings.NewReader("x") },
+		{ Path: "top.txt", Size: 1, Data: strings.NewReader("x") },
+	})
+
+	if err := fstest.TestFS(fsys, "a/b/c.txt", "a/d.txt", "top.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := fs.ReadFile(fsys, "top.txt"); err != nil || string(data) != "hello" {
+		t.Errorf("top.txt: %q, %v", data, err)
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	if strings.Join(names, " ") != "a empty top.txt" {
+		t.Errorf("root entries %q", names)
+	}
+
+	if info, err := fs.Stat(fsys, "empty"); err != nil || !info.IsDir() {
+		t.Errorf("empty: %+v, %v", info, err)
+	}
+}